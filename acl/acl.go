@@ -0,0 +1,150 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Config is a list of CIDRs (or single IPs) that clients may
+// connect from, and ones they may not. Deny takes precedence
+// over Allow, and if Allow is empty any client that isn't
+// denied is allowed.
+//
+// File holds further rules, one per line, of the form
+// "allow CIDR" or "deny CIDR". Blank lines and lines
+// starting with # are ignored.
+type Config struct {
+	Allow []string
+	Deny  []string
+	File  string
+}
+
+// Enabled reports whether any rules are configured.
+func (cfg Config) Enabled() bool {
+	return len(cfg.Allow) > 0 || len(cfg.Deny) > 0 || cfg.File != ""
+}
+
+type rule struct {
+	allow bool
+	net   *net.IPNet
+}
+
+func (r rule) String() string {
+	if r.allow {
+		return "allow " + r.net.String()
+	}
+	return "deny " + r.net.String()
+}
+
+// List is a parsed set of access control rules.
+type List struct {
+	allow []rule
+	deny  []rule
+}
+
+// Load parses cfg's rules, reading its file, if any.
+func Load(cfg Config) (*List, error) {
+	l := &List{}
+	for _, s := range cfg.Allow {
+		if err := l.add(true, s); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range cfg.Deny {
+		if err := l.add(false, s); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.File != "" {
+		f, err := os.Open(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err = l.read(f); err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", cfg.File)
+		}
+	}
+	return l, nil
+}
+
+func (l *List) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || (fields[0] != "allow" && fields[0] != "deny") {
+			return errors.New(fmt.Sprintf("line %d: expected allow|deny CIDR, got %q", n, line))
+		}
+		if err := l.add(fields[0] == "allow", fields[1]); err != nil {
+			return errors.Wrapf(err, "line %d", n)
+		}
+	}
+	return scanner.Err()
+}
+
+func (l *List) add(allow bool, s string) error {
+	ipNet, err := parseCIDR(s)
+	if err != nil {
+		return err
+	}
+	if allow {
+		l.allow = append(l.allow, rule{true, ipNet})
+	} else {
+		l.deny = append(l.deny, rule{false, ipNet})
+	}
+	return nil
+}
+
+// parseCIDR parses an IPv4 or IPv6 CIDR, treating
+// a single IP as a network of just that address.
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.New(fmt.Sprintf("invalid IP address %q", s))
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid CIDR %q", s))
+	}
+	return ipNet, nil
+}
+
+// Len returns the number of rules in the list.
+func (l *List) Len() int {
+	return len(l.allow) + len(l.deny)
+}
+
+// Check reports whether ip is allowed, along with
+// a description of the rule that decided it.
+func (l *List) Check(ip net.IP) (bool, string) {
+	for _, r := range l.deny {
+		if r.net.Contains(ip) {
+			return false, r.String()
+		}
+	}
+	for _, r := range l.allow {
+		if r.net.Contains(ip) {
+			return true, r.String()
+		}
+	}
+	if len(l.allow) > 0 {
+		return false, "not in allow list"
+	}
+	return true, "not in deny list"
+}
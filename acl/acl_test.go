@@ -0,0 +1,91 @@
+package acl
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	l, err := Load(Config{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1"},
+		Deny:  []string{"10.1.0.0/16", "2001:db8:bad::/48"},
+	})
+	check(t, err)
+
+	tests := []struct {
+		ip      string
+		allowed bool
+		rule    string
+	}{
+		{"10.0.0.1", true, "allow 10.0.0.0/8"},
+		{"::ffff:10.0.0.1", true, "allow 10.0.0.0/8"},
+		{"10.1.2.3", false, "deny 10.1.0.0/16"},
+		{"192.0.2.1", true, "allow 192.0.2.1/32"},
+		{"192.0.2.2", false, "not in allow list"},
+		{"2001:db8::1", true, "allow 2001:db8::/32"},
+		{"2001:db8:bad::1", false, "deny 2001:db8:bad::/48"},
+		{"::1", false, "not in allow list"},
+	}
+	for _, test := range tests {
+		allowed, rule := l.Check(net.ParseIP(test.ip))
+		if allowed != test.allowed || rule != test.rule {
+			t.Errorf("%s: expected %v (%s), got %v (%s)", test.ip, test.allowed, test.rule, allowed, rule)
+		}
+	}
+}
+
+func TestCheckDenyOnly(t *testing.T) {
+	l, err := Load(Config{Deny: []string{"::1"}})
+	check(t, err)
+
+	if allowed, _ := l.Check(net.ParseIP("::1")); allowed {
+		t.Error("expected ::1 to be denied")
+	}
+	if allowed, rule := l.Check(net.ParseIP("127.0.0.1")); !allowed || rule != "not in deny list" {
+		t.Errorf("expected 127.0.0.1 to be allowed, got %v (%s)", allowed, rule)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "acl")
+	check(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("# Office network.\nallow 198.51.100.0/24\n\ndeny 198.51.100.7\n")
+	check(t, err)
+	f.Close()
+
+	l, err := Load(Config{File: f.Name()})
+	check(t, err)
+	if l.Len() != 2 {
+		t.Errorf("expected 2 rules, got %d", l.Len())
+	}
+	if allowed, rule := l.Check(net.ParseIP("198.51.100.7")); allowed || rule != "deny 198.51.100.7/32" {
+		t.Errorf("expected 198.51.100.7 to be denied, got %v (%s)", allowed, rule)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"not-an-ip"}},
+		{File: "/nonexistent/acl"},
+	} {
+		if _, err := Load(cfg); err == nil {
+			t.Errorf("expected %+v to be invalid", cfg)
+		}
+	}
+
+	l := &List{}
+	if err := l.read(strings.NewReader("permit 10.0.0.0/8")); err == nil {
+		t.Error("expected unknown action to be invalid")
+	}
+}
+
+func check(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
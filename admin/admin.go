@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/pkg/errors"
+)
+
+const defaultRemoveTimeout = 30 * time.Second
+
+// Proxy is the part of *proxy.TCPProxy the admin API controls.
+type Proxy interface {
+	Stats() map[string]interface{}
+	Drain(addr string) error
+	RemoveBackend(addr string, timeout time.Duration) (bool, error)
+}
+
+// NewHandler serves the admin API:
+//
+//	GET  /stats                      metrics as JSON
+//	POST /drain?backend=ADDR         stop new connections to a backend
+//	POST /remove?backend=ADDR        drain a backend, wait for its connections
+//	     [&timeout=DURATION]         to close (30s by default), then remove it
+func NewHandler(p Proxy) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, p.Stats())
+	})
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := backendParam(w, r)
+		if !ok {
+			return
+		}
+		err := p.Drain(addr)
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{"backend": addr, "state": "DRAINING"})
+	})
+	mux.HandleFunc("/remove", func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := backendParam(w, r)
+		if !ok {
+			return
+		}
+		timeout := defaultRemoveTimeout
+		if s := r.URL.Query().Get("timeout"); s != "" {
+			var err error
+			timeout, err = time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, "invalid timeout: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		drained, err := p.RemoveBackend(addr, timeout)
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{"backend": addr, "state": "REMOVED", "drained": drained})
+	})
+	return mux
+}
+
+// ListenAndServe serves the admin API on addr.
+func ListenAndServe(addr string, p Proxy) error {
+	logger.Infof("admin: listening on %s", addr)
+	return http.ListenAndServe(addr, NewHandler(p))
+}
+
+// backendParam returns the backend a POST request is for,
+// writing an error response if there isn't one.
+func backendParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	addr := r.URL.Query().Get("backend")
+	if addr == "" {
+		http.Error(w, "missing backend parameter", http.StatusBadRequest)
+		return "", false
+	}
+	return addr, true
+}
+
+// errorStatus is the status code for an error from the proxy.
+func errorStatus(err error) int {
+	if _, ok := errors.Cause(err).(*backend.UnknownBackendError); ok {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.Error(err)
+	}
+}
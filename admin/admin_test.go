@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/pkg/errors"
+)
+
+type fakeProxy struct {
+	drained []string
+	removed map[string]time.Duration
+}
+
+func (p *fakeProxy) Stats() map[string]interface{} {
+	return map[string]interface{}{"requests": uint64(3)}
+}
+
+func (p *fakeProxy) Drain(addr string) error {
+	switch addr {
+	case "localhost:8001":
+	case "localhost:8500":
+		return errors.New("health monitor failed")
+	default:
+		return &backend.UnknownBackendError{Addr: addr}
+	}
+	p.drained = append(p.drained, addr)
+	return nil
+}
+
+func (p *fakeProxy) RemoveBackend(addr string, timeout time.Duration) (bool, error) {
+	if addr == "localhost:9999" {
+		return false, errors.Wrap(&backend.UnknownBackendError{Addr: addr}, "failed to remove")
+	}
+	p.removed[addr] = timeout
+	return true, nil
+}
+
+func TestAdmin(t *testing.T) {
+	p := &fakeProxy{removed: make(map[string]time.Duration)}
+	handler := NewHandler(p)
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"GET", "/stats", http.StatusOK},
+		{"POST", "/stats", http.StatusMethodNotAllowed},
+		{"POST", "/drain?backend=localhost:8001", http.StatusOK},
+		{"GET", "/drain?backend=localhost:8001", http.StatusMethodNotAllowed},
+		{"POST", "/drain", http.StatusBadRequest},
+		{"POST", "/drain?backend=localhost:9999", http.StatusNotFound},
+		{"POST", "/drain?backend=localhost:8500", http.StatusInternalServerError},
+		{"POST", "/remove?backend=localhost:9999", http.StatusNotFound},
+		{"POST", "/remove?backend=localhost:8001", http.StatusOK},
+		{"POST", "/remove?backend=localhost:8002&timeout=5s", http.StatusOK},
+		{"POST", "/remove?backend=localhost:8002&timeout=soon", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", test.method, test.url, test.status, w.Code, w.Body)
+		}
+	}
+
+	if len(p.drained) != 1 {
+		t.Errorf("expected 1 backend to be drained, got %v", p.drained)
+	}
+	if p.removed["localhost:8001"] != defaultRemoveTimeout || p.removed["localhost:8002"] != 5*time.Second {
+		t.Errorf("expected backends to be removed with their timeouts, got %v", p.removed)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/stats", nil))
+	var stats map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["requests"] != float64(3) {
+		t.Errorf("expected stats as JSON, got %s", w.Body)
+	}
+}
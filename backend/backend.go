@@ -2,35 +2,170 @@ package backend
 
 import (
 	"sync/atomic"
+	"time"
 )
 
+// Limits caps the connections proxied to a backend: the number
+// established or being dialed (MaxConns), and the number being
+// dialed (MaxPending). Zero is unlimited.
+type Limits struct {
+	MaxConns   uint64
+	MaxPending uint64
+}
+
 type Backend struct {
-	addr        string
-	state       State
-	activeConns uint64
+	addr         string
+	priority     int
+	weight       uint32
+	state        State
+	healthySince int64
+	activeConns  uint64
+	pendingDials uint64
+	limits       Limits
+	// Set while the backend is at its limits.
+	circuitOpen uint32
+	trips       uint64
+}
+
+func NewBackend(addr string, state State) *Backend {
+	return &Backend{addr: addr, state: state}
 }
 
 func (b *Backend) Addr() string {
 	return b.addr
 }
 
+// Priority returns the backend's priority tier; see Endpoint.
+func (b *Backend) Priority() int {
+	return b.priority
+}
+
+// Weight returns the backend's share of connections
+// relative to others in its tier; see Endpoint.
+func (b *Backend) Weight() uint32 {
+	if w := atomic.LoadUint32(&b.weight); w > 0 {
+		return w
+	}
+	return 1
+}
+
 func (b *Backend) State() State {
 	return (State)(atomic.LoadUint32((*uint32)(&b.state)))
 }
 
 func (b *Backend) SetState(state State) (updated bool) {
 	prev := (State)(atomic.SwapUint32((*uint32)(&b.state), (uint32)(state)))
+	if prev != state && state == HEALTHY {
+		atomic.StoreInt64(&b.healthySince, time.Now().UnixNano())
+	}
 	return prev != state
 }
 
+// setHealth sets the backend HEALTHY or UNHEALTHY,
+// unless it's DRAINING or has been REMOVED.
+func (b *Backend) setHealth(state State) (updated bool) {
+	for {
+		prev := b.State()
+		if prev == DRAINING || prev == REMOVED {
+			return false
+		}
+		if atomic.CompareAndSwapUint32((*uint32)(&b.state), uint32(prev), uint32(state)) {
+			if prev != state && state == HEALTHY {
+				atomic.StoreInt64(&b.healthySince, time.Now().UnixNano())
+			}
+			return prev != state
+		}
+	}
+}
+
+// HealthySince returns when the backend last became HEALTHY, or
+// the zero time if it has been since it was first registered.
+func (b *Backend) HealthySince() time.Time {
+	since := atomic.LoadInt64(&b.healthySince)
+	if since == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, since)
+}
+
 func (b *Backend) IncrActiveConns() uint64 {
 	return atomic.AddUint64(&b.activeConns, 1)
 }
 
 func (b *Backend) DecrActiveConns() uint64 {
-	return atomic.AddUint64(&b.activeConns, ^uint64(0))
+	active := atomic.AddUint64(&b.activeConns, ^uint64(0))
+	b.closeCircuit()
+	return active
 }
 
 func (b *Backend) ActiveConns() uint64 {
 	return atomic.LoadUint64(&b.activeConns)
 }
+
+func (b *Backend) PendingDials() uint64 {
+	return atomic.LoadUint64(&b.pendingDials)
+}
+
+func (b *Backend) Limits() Limits {
+	return b.limits
+}
+
+// Available reports whether the backend is below its limits.
+func (b *Backend) Available() bool {
+	pending := b.PendingDials()
+	if b.limits.MaxPending > 0 && pending >= b.limits.MaxPending {
+		return false
+	}
+	return b.limits.MaxConns == 0 || b.ActiveConns()+pending < b.limits.MaxConns
+}
+
+// BeginDial reserves a dial to the backend within its limits,
+// reporting whether it could. Each successful call must be
+// followed by EndDial. The backend's circuit opens once it
+// reaches its limits, and closes when it's back below them.
+func (b *Backend) BeginDial() bool {
+	pending := atomic.AddUint64(&b.pendingDials, 1)
+	if (b.limits.MaxPending > 0 && pending > b.limits.MaxPending) ||
+		(b.limits.MaxConns > 0 && b.ActiveConns()+pending > b.limits.MaxConns) {
+		atomic.AddUint64(&b.pendingDials, ^uint64(0))
+		b.openCircuit()
+		return false
+	}
+	if !b.Available() {
+		b.openCircuit()
+	}
+	return true
+}
+
+// EndDial releases a dial reserved with BeginDial. If the dial
+// connected, the connection becomes one of the backend's active
+// connections and must be released with DecrActiveConns.
+func (b *Backend) EndDial(connected bool) {
+	if connected {
+		b.IncrActiveConns()
+	}
+	atomic.AddUint64(&b.pendingDials, ^uint64(0))
+	b.closeCircuit()
+}
+
+// CircuitOpen reports whether the backend is at its limits.
+func (b *Backend) CircuitOpen() bool {
+	return atomic.LoadUint32(&b.circuitOpen) == 1
+}
+
+func (b *Backend) openCircuit() {
+	if atomic.CompareAndSwapUint32(&b.circuitOpen, 0, 1) {
+		atomic.AddUint64(&b.trips, 1)
+	}
+}
+
+func (b *Backend) closeCircuit() {
+	if b.CircuitOpen() && b.Available() {
+		atomic.StoreUint32(&b.circuitOpen, 0)
+	}
+}
+
+// Trips returns the number of times the backend's circuit opened.
+func (b *Backend) Trips() uint64 {
+	return atomic.LoadUint64(&b.trips)
+}
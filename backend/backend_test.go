@@ -0,0 +1,63 @@
+package backend
+
+import "testing"
+
+func TestLimits(t *testing.T) {
+	b := NewBackend("localhost:12345", HEALTHY)
+	b.limits = Limits{MaxConns: 2, MaxPending: 1}
+
+	// Only one dial may be pending at a time.
+	if !b.BeginDial() {
+		t.Fatal("expected first dial to be allowed")
+	}
+	if b.Available() || b.BeginDial() {
+		t.Error("expected second concurrent dial to be refused")
+	}
+	if !b.CircuitOpen() || b.Trips() != 1 {
+		t.Errorf("expected circuit to have opened once, was open %v with %d trips", b.CircuitOpen(), b.Trips())
+	}
+	b.EndDial(true)
+	if b.CircuitOpen() {
+		t.Error("expected circuit to close once the dial ended")
+	}
+
+	// Pending dials count towards the connection limit.
+	if !b.BeginDial() {
+		t.Fatal("expected second dial to be allowed")
+	}
+	b.EndDial(true)
+	if b.ActiveConns() != 2 || b.PendingDials() != 0 {
+		t.Errorf("expected 2 active connections and no pending dials, had %d and %d", b.ActiveConns(), b.PendingDials())
+	}
+	if b.BeginDial() {
+		t.Error("expected dial over the connection limit to be refused")
+	}
+	if !b.CircuitOpen() || b.Trips() != 2 {
+		t.Errorf("expected circuit to have opened twice, was open %v with %d trips", b.CircuitOpen(), b.Trips())
+	}
+
+	// Failed dials don't become connections.
+	b.DecrActiveConns()
+	if !b.Available() || b.CircuitOpen() {
+		t.Fatal("expected circuit to close once a connection closed")
+	}
+	if !b.BeginDial() {
+		t.Fatal("expected dial to be allowed once a connection closed")
+	}
+	b.EndDial(false)
+	if b.ActiveConns() != 1 {
+		t.Errorf("expected 1 active connection, had %d", b.ActiveConns())
+	}
+}
+
+func TestNoLimits(t *testing.T) {
+	b := NewBackend("localhost:12345", HEALTHY)
+	for i := 0; i < 100; i++ {
+		if !b.BeginDial() {
+			t.Fatal("expected backend without limits to allow every dial")
+		}
+	}
+	if b.CircuitOpen() {
+		t.Error("expected backend without limits to never open its circuit")
+	}
+}
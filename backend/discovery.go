@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultReconcileInterval is the least time, by default, between
+// a Reconciler's changes to its registry after the first.
+const DefaultReconcileInterval = 1 * time.Second
+
+// Discoverer is a source of endpoints, such as a static list,
+// a file, DNS names or a service catalog.
+type Discoverer interface {
+	// Start finds the current set of endpoints and passes it to
+	// update, or returns an error if it can't. It then watches for
+	// changes in the background, passing update each new complete
+	// set, until Stop.
+	Start(update func([]Endpoint)) error
+	Stop()
+}
+
+// Static is a Discoverer of a fixed set of endpoints.
+type Static []Endpoint
+
+func (s Static) Start(update func([]Endpoint)) error {
+	update(s)
+	return nil
+}
+
+func (s Static) Stop() {}
+
+// Reconciler keeps a registry's backends in line with the union of
+// the endpoints found by its Discoverers. Where several report the
+// same address, the first Discoverer's endpoint is used.
+//
+// Backends are only added, removed or re-added (on a change of
+// priority) as their endpoints come and go, so unchanged backends
+// keep their health monitors and state. Changes are applied at
+// most once per interval, each time with the latest endpoints.
+// A backend removed with the registry's DrainAndRemove stays
+// removed until its endpoint is no longer discovered.
+type Reconciler struct {
+	registry *Registry
+	sources  []Discoverer
+	interval time.Duration
+	onAdd    func(*Backend)
+	onRemove func(*Backend)
+
+	lock sync.Mutex
+	// The latest endpoints from each source.
+	sets     [][]Endpoint
+	started  bool
+	stopped  bool
+	applied  time.Time
+	pending  *time.Timer
+	stopOnce sync.Once
+}
+
+func NewReconciler(registry *Registry, sources ...Discoverer) *Reconciler {
+	return &Reconciler{
+		registry: registry,
+		sources:  sources,
+		interval: DefaultReconcileInterval,
+		sets:     make([][]Endpoint, len(sources)),
+	}
+}
+
+// SetInterval changes the least time between changes to the
+// registry. It must be called before Start.
+func (r *Reconciler) SetInterval(interval time.Duration) {
+	r.interval = interval
+}
+
+// OnAdd sets a func called with each backend the reconciler
+// adds. It must be called before Start.
+func (r *Reconciler) OnAdd(f func(*Backend)) {
+	r.onAdd = f
+}
+
+// OnRemove sets a func called with each backend the reconciler
+// removes, once its endpoint is no longer discovered. It must
+// be called before Start.
+func (r *Reconciler) OnRemove(f func(*Backend)) {
+	r.onRemove = f
+}
+
+// Start starts every Discoverer, all of which must find their
+// current endpoints, and applies those endpoints to the registry.
+func (r *Reconciler) Start() error {
+	for i, source := range r.sources {
+		i := i
+		err := source.Start(func(endpoints []Endpoint) {
+			r.update(i, endpoints)
+		})
+		if err != nil {
+			for _, started := range r.sources[:i] {
+				started.Stop()
+			}
+			return err
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.started = true
+	return r.apply()
+}
+
+// Stop stops every Discoverer. Backends stay registered.
+func (r *Reconciler) Stop() {
+	r.stopOnce.Do(func() {
+		r.lock.Lock()
+		r.stopped = true
+		if r.pending != nil {
+			r.pending.Stop()
+		}
+		r.lock.Unlock()
+
+		for _, source := range r.sources {
+			source.Stop()
+		}
+	})
+}
+
+func (r *Reconciler) update(source int, endpoints []Endpoint) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sets[source] = endpoints
+	if !r.started || r.stopped || r.pending != nil {
+		return
+	}
+
+	wait := r.interval - time.Since(r.applied)
+	if wait <= 0 {
+		r.logApply()
+		return
+	}
+	r.pending = time.AfterFunc(wait, func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.pending = nil
+		if !r.stopped {
+			r.logApply()
+		}
+	})
+}
+
+func (r *Reconciler) logApply() {
+	if err := r.apply(); err != nil {
+		r.registry.lock.RLock()
+		log := r.registry.log
+		r.registry.lock.RUnlock()
+		log.Errorw("failed to apply discovered endpoints", "error", err)
+	}
+}
+
+// apply makes the registry's backends match the latest endpoints.
+// Endpoints that fail to be added don't stop the others.
+func (r *Reconciler) apply() error {
+	r.applied = time.Now()
+
+	wanted := make(map[string]Endpoint)
+	for _, set := range r.sets {
+		for _, e := range set {
+			if _, exists := wanted[e.Addr]; !exists {
+				wanted[e.Addr] = e
+			}
+		}
+	}
+
+	// Forget operator removals once discovery drops them too.
+	r.registry.lock.Lock()
+	for addr := range r.registry.removed {
+		if _, exists := wanted[addr]; exists {
+			delete(wanted, addr)
+		} else {
+			delete(r.registry.removed, addr)
+		}
+	}
+	r.registry.lock.Unlock()
+
+	current := make(map[string]Endpoint)
+	for _, e := range r.registry.Endpoints() {
+		current[e.Addr] = e
+	}
+	for addr := range current {
+		if _, exists := wanted[addr]; !exists {
+			b := r.registry.Get(addr)
+			r.registry.Remove(addr)
+			if b != nil && r.onRemove != nil {
+				r.onRemove(b)
+			}
+		}
+	}
+
+	var firstErr error
+	for _, e := range wanted {
+		if c, exists := current[e.Addr]; exists && c.Priority == e.Priority {
+			if c.Weight != e.Weight {
+				r.registry.SetWeight(e.Addr, e.Weight)
+			}
+			continue
+		}
+		b, err := r.registry.AddEndpoint(e)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "failed to add %s", e.Addr)
+			}
+			continue
+		}
+		if r.onAdd != nil {
+			r.onAdd(b)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/health"
+)
+
+// fakeDiscoverer finds endpoints that are pushed to it.
+type fakeDiscoverer struct {
+	endpoints []Endpoint
+	err       error
+
+	lock    sync.Mutex
+	update  func([]Endpoint)
+	stopped bool
+}
+
+func (d *fakeDiscoverer) Start(update func([]Endpoint)) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.lock.Lock()
+	d.update = update
+	d.lock.Unlock()
+	update(d.endpoints)
+	return nil
+}
+
+func (d *fakeDiscoverer) Stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.stopped = true
+}
+
+func (d *fakeDiscoverer) push(endpoints ...Endpoint) {
+	d.lock.Lock()
+	update := d.update
+	d.lock.Unlock()
+	update(endpoints)
+}
+
+func TestReconcilerMerges(t *testing.T) {
+	registry := NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	discovered := &fakeDiscoverer{endpoints: []Endpoint{{Addr: "localhost:9001"}, {Addr: "localhost:9002"}}}
+	r := NewReconciler(registry, Static{{Addr: "localhost:9000"}, {Addr: "localhost:9001", Priority: 1}}, discovered)
+	r.SetInterval(0)
+	check(t, r.Start())
+	defer r.Stop()
+
+	// The first source's endpoint wins.
+	assertEndpoints(t, registry,
+		Endpoint{Addr: "localhost:9000"},
+		Endpoint{Addr: "localhost:9001", Priority: 1},
+		Endpoint{Addr: "localhost:9002"},
+	)
+
+	discovered.push(Endpoint{Addr: "localhost:9003", Weight: 5})
+	assertEndpoints(t, registry,
+		Endpoint{Addr: "localhost:9000"},
+		Endpoint{Addr: "localhost:9001", Priority: 1},
+		Endpoint{Addr: "localhost:9003", Weight: 5},
+	)
+
+	r.Stop()
+	if !discovered.stopped {
+		t.Error("expected stopping the reconciler to stop its sources")
+	}
+}
+
+func TestReconcilerKeepsBackends(t *testing.T) {
+	registry := NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	discovered := &fakeDiscoverer{endpoints: []Endpoint{{Addr: "localhost:9000"}, {Addr: "localhost:9001"}}}
+	r := NewReconciler(registry, discovered)
+	r.SetInterval(0)
+	added, removed := 0, 0
+	r.OnAdd(func(b *Backend) {
+		added++
+	})
+	r.OnRemove(func(b *Backend) {
+		removed++
+	})
+	check(t, r.Start())
+	defer r.Stop()
+	unchanged := registry.Get("localhost:9000")
+	reprioritized := registry.Get("localhost:9001")
+
+	// Reapplying the same endpoints changes nothing...
+	discovered.push(Endpoint{Addr: "localhost:9000"}, Endpoint{Addr: "localhost:9001"})
+	// ...nor does a change of weight...
+	discovered.push(Endpoint{Addr: "localhost:9000", Weight: 3}, Endpoint{Addr: "localhost:9001"})
+	// ...but a change of priority replaces the backend.
+	discovered.push(Endpoint{Addr: "localhost:9000", Weight: 3}, Endpoint{Addr: "localhost:9001", Priority: 1})
+
+	if registry.Get("localhost:9000") != unchanged {
+		t.Error("expected an unchanged endpoint to keep its backend")
+	}
+	if b := registry.Get("localhost:9000"); b.Weight() != 3 {
+		t.Errorf("expected weight 3, was %d", b.Weight())
+	}
+	if registry.Get("localhost:9001") == reprioritized {
+		t.Error("expected a change of priority to replace the backend")
+	}
+	if added != 3 {
+		t.Errorf("expected 3 backends to be added, got %d", added)
+	}
+	if removed != 0 {
+		t.Errorf("expected no backends to be removed, got %d", removed)
+	}
+
+	// Only endpoints that are no longer discovered are removed.
+	discovered.push(Endpoint{Addr: "localhost:9000", Weight: 3})
+	if removed != 1 {
+		t.Errorf("expected 1 backend to be removed, got %d", removed)
+	}
+}
+
+func TestReconcilerRateLimits(t *testing.T) {
+	registry := NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	discovered := &fakeDiscoverer{endpoints: []Endpoint{{Addr: "localhost:9000"}}}
+	r := NewReconciler(registry, discovered)
+	r.SetInterval(100 * time.Millisecond)
+	var lock sync.Mutex
+	var added []string
+	r.OnAdd(func(b *Backend) {
+		lock.Lock()
+		defer lock.Unlock()
+		added = append(added, b.Addr())
+	})
+	check(t, r.Start())
+	defer r.Stop()
+
+	// Changes soon after the first are held back,
+	// then applied together.
+	discovered.push(Endpoint{Addr: "localhost:9001"})
+	discovered.push(Endpoint{Addr: "localhost:9002"})
+	assertEndpoints(t, registry, Endpoint{Addr: "localhost:9000"})
+
+	time.Sleep(200 * time.Millisecond)
+	assertEndpoints(t, registry, Endpoint{Addr: "localhost:9002"})
+	lock.Lock()
+	defer lock.Unlock()
+	if expected := []string{"localhost:9000", "localhost:9002"}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected %v to be added, got %v", expected, added)
+	}
+}
+
+func TestReconcilerKeepsRemovals(t *testing.T) {
+	registry := NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	discovered := &fakeDiscoverer{endpoints: []Endpoint{{Addr: "localhost:9000"}, {Addr: "localhost:9001"}}}
+	r := NewReconciler(registry, discovered)
+	r.SetInterval(0)
+	check(t, r.Start())
+	defer r.Stop()
+
+	// A backend removed by an operator isn't re-added
+	// while it's still discovered...
+	_, err := registry.DrainAndRemove("localhost:9000", 0)
+	check(t, err)
+	discovered.push(Endpoint{Addr: "localhost:9000"}, Endpoint{Addr: "localhost:9001"})
+	assertEndpoints(t, registry, Endpoint{Addr: "localhost:9001"})
+
+	// ...but is once it's gone from discovery and come back.
+	discovered.push(Endpoint{Addr: "localhost:9001"})
+	discovered.push(Endpoint{Addr: "localhost:9000"}, Endpoint{Addr: "localhost:9001"})
+	assertEndpoints(t, registry, Endpoint{Addr: "localhost:9000"}, Endpoint{Addr: "localhost:9001"})
+}
+
+func TestReconcilerStartFails(t *testing.T) {
+	registry := NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	started := &fakeDiscoverer{endpoints: []Endpoint{{Addr: "localhost:9000"}}}
+	r := NewReconciler(registry, started, &fakeDiscoverer{err: errors.New("unavailable")})
+	if err := r.Start(); err == nil {
+		t.Fatal("expected a source that fails to start to fail the reconciler")
+	}
+	if !started.stopped {
+		t.Error("expected sources already started to be stopped")
+	}
+	assertEndpoints(t, registry)
+}
+
+func assertEndpoints(t *testing.T, registry *Registry, expected ...Endpoint) {
+	t.Helper()
+	endpoints := registry.Endpoints()
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Addr < endpoints[j].Addr
+	})
+	if len(endpoints) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(endpoints, expected) {
+		t.Errorf("expected endpoints %v, got %v", expected, endpoints)
+	}
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Endpoint is a backend's address and priority tier. Lower
+// priorities are preferred; 0 is the highest. Within a tier,
+// backends are sent connections in proportion to their Weight;
+// 0 is the same as 1.
+type Endpoint struct {
+	Addr     string
+	Priority int
+	Weight   uint32
+}
+
+func (e Endpoint) String() string {
+	if e.Priority == 0 {
+		return e.Addr
+	}
+	return fmt.Sprintf("%s@%d", e.Addr, e.Priority)
+}
+
+// ParseEndpoint parses an address with an optional priority
+// suffix, e.g. 10.0.0.1:80@1. The priority defaults to 0.
+func ParseEndpoint(s string) (Endpoint, error) {
+	i := strings.LastIndex(s, "@")
+	if i < 0 {
+		return Endpoint{Addr: s}, nil
+	}
+	priority, err := strconv.Atoi(s[i+1:])
+	if err != nil || priority < 0 {
+		return Endpoint{}, errors.New(fmt.Sprintf("invalid priority in %q", s))
+	}
+	if i == 0 {
+		return Endpoint{}, errors.New(fmt.Sprintf("missing address in %q", s))
+	}
+	return Endpoint{Addr: s[:i], Priority: priority}, nil
+}
@@ -0,0 +1,32 @@
+package backend
+
+import "testing"
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected Endpoint
+	}{
+		{"localhost:8000", Endpoint{Addr: "localhost:8000"}},
+		{"localhost:8000@2", Endpoint{Addr: "localhost:8000", Priority: 2}},
+		{"unix:/var/run/app.sock@1", Endpoint{Addr: "unix:/var/run/app.sock", Priority: 1}},
+	}
+	for _, test := range tests {
+		e, err := ParseEndpoint(test.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.s, test.expected, e)
+		}
+		if e.String() != test.s {
+			t.Errorf("expected %+v to format as %s, got %s", e, test.s, e.String())
+		}
+	}
+
+	for _, s := range []string{"localhost:8000@", "localhost:8000@-1", "localhost:8000@dr", "@1"} {
+		if _, err := ParseEndpoint(s); err == nil {
+			t.Errorf("expected %s to be invalid", s)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/jmuia/tcp-proxy/health"
+	logger "github.com/jmuia/tcp-proxy/logging"
 	"github.com/pkg/errors"
 )
 
@@ -27,6 +28,7 @@ type HealthMonitor struct {
 	healthyStreak   int
 	listeners       []UpdateListener
 	state           healthMonitorState
+	log             *logger.Logger
 }
 
 func NewHealthMonitor(backend *Backend, cfg health.HealthCheckConfig) *HealthMonitor {
@@ -40,6 +42,7 @@ func NewHealthMonitor(backend *Backend, cfg health.HealthCheckConfig) *HealthMon
 		healthyStreak:   0,
 		listeners:       make([]UpdateListener, 0),
 		state:           new_,
+		log:             logger.With(),
 	}
 }
 
@@ -109,11 +112,13 @@ func (hm *HealthMonitor) Stop() {
 
 func (hm *HealthMonitor) applyHealthCheck(err error) {
 	if err != nil {
+		hm.log.Debugw("health check failed", "backend", hm.backend.Addr(), "error", err)
 		hm.healthyStreak = 0
 		hm.unhealthyStreak = min(hm.unhealthyStreak+1, hm.cfg.UnhealthyThreshold)
 		if hm.unhealthyStreak >= hm.cfg.UnhealthyThreshold {
-			updated := hm.backend.SetState(UNHEALTHY)
+			updated := hm.backend.setHealth(UNHEALTHY)
 			if updated {
+				hm.log.Warnw("backend failed health checks", "backend", hm.backend.Addr(), "error", err)
 				hm.updateListeners(hm.backend)
 			}
 		}
@@ -121,7 +126,7 @@ func (hm *HealthMonitor) applyHealthCheck(err error) {
 		hm.unhealthyStreak = 0
 		hm.healthyStreak = min(hm.healthyStreak+1, hm.cfg.HealthyThreshold)
 		if hm.healthyStreak >= hm.cfg.HealthyThreshold {
-			updated := hm.backend.SetState(HEALTHY)
+			updated := hm.backend.setHealth(HEALTHY)
 			if updated {
 				hm.updateListeners(hm.backend)
 			}
@@ -14,7 +14,7 @@ type fakeHealthCheck func() error
 func (hc fakeHealthCheck) Check() error { return hc() }
 
 func TestHealthFlappingAboveThreshold(t *testing.T) {
-	backend := &Backend{"localhost:57803", HEALTHY, 0}
+	backend := NewBackend("localhost:57803", HEALTHY)
 	cfg := health.HealthCheckConfig{
 		Interval:           5 * time.Millisecond,
 		UnhealthyThreshold: 3,
@@ -83,7 +83,7 @@ func TestHealthFlappingAboveThreshold(t *testing.T) {
 }
 
 func TestHealthFlappingBelowThreshold(t *testing.T) {
-	backend := &Backend{"localhost:57803", HEALTHY, 0}
+	backend := NewBackend("localhost:57803", HEALTHY)
 	cfg := health.HealthCheckConfig{
 		Interval:           5 * time.Millisecond,
 		UnhealthyThreshold: 3,
@@ -2,27 +2,67 @@ package backend
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jmuia/tcp-proxy/health"
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/jmuia/tcp-proxy/netaddr"
 )
 
+// How often DrainAndRemove checks for a backend's connections to close.
+const drainPollInterval = 100 * time.Millisecond
+
+// HealthCheckFactory creates the health check run against a backend.
+type HealthCheckFactory func(addr string, timeout time.Duration) health.HealthCheck
+
+// UnknownBackendError is returned for an address
+// that no backend is registered for.
+type UnknownBackendError struct {
+	Addr string
+}
+
+func (e *UnknownBackendError) Error() string {
+	return "unknown backend " + e.Addr
+}
+
+// DialHealthCheckFactory is the default HealthCheckFactory. It
+// connects to Unix domain socket backends (unix:/path/to.sock)
+// and TCP backends otherwise.
+func DialHealthCheckFactory(addr string, timeout time.Duration) health.HealthCheck {
+	network, address := netaddr.Split(addr, "tcp")
+	if network == "unix" {
+		return health.NewUnixHealthCheck(address, timeout)
+	}
+	return health.NewTCPHealthCheck(address, timeout)
+}
+
 type Registry struct {
 	lock      sync.RWMutex
 	cfg       health.HealthCheckConfig
+	checks    HealthCheckFactory
+	limits    Limits
 	backends  map[string]*Backend
 	monitors  map[string]*HealthMonitor
 	listeners []UpdateListener
 	aggr      chan *Backend
+	log       *logger.Logger
+	// Addresses removed by DrainAndRemove, which a Reconciler
+	// doesn't add again while they're still discovered.
+	removed map[string]bool
 }
 
 func NewRegistry(cfg health.HealthCheckConfig) *Registry {
 	r := &Registry{
 		lock:      sync.RWMutex{},
 		cfg:       cfg,
+		checks:    DialHealthCheckFactory,
 		backends:  make(map[string]*Backend),
 		monitors:  make(map[string]*HealthMonitor),
+		removed:   make(map[string]bool),
 		listeners: make([]UpdateListener, 0),
 		aggr:      make(chan *Backend),
+		log:       logger.With(),
 	}
 	go func() {
 		for b := range r.aggr {
@@ -39,16 +79,28 @@ func NewRegistry(cfg health.HealthCheckConfig) *Registry {
 }
 
 func (r *Registry) Add(addr string) (*Backend, error) {
+	return r.AddEndpoint(Endpoint{Addr: addr})
+}
+
+// AddEndpoint is like Add, but sets the backend's priority tier.
+func (r *Registry) AddEndpoint(e Endpoint) (*Backend, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	addr := e.Addr
 	r.remove(addr)
+	delete(r.removed, addr)
 
 	// TODO: perform an initial health check rather than assuming healthy.
-	r.backends[addr] = &Backend{addr, HEALTHY, 0}
+	b := NewBackend(addr, HEALTHY)
+	b.priority = e.Priority
+	b.weight = e.Weight
+	b.limits = r.limits
+	r.backends[addr] = b
 
 	if r.cfg != (health.HealthCheckConfig{}) {
 		r.monitors[addr] = NewHealthMonitor(r.backends[addr], r.cfg)
-		r.monitors[addr].AddHealthCheck(health.NewTCPHealthCheck(addr, r.cfg.Timeout))
+		r.monitors[addr].log = r.log
+		r.monitors[addr].AddHealthCheck(r.checks(addr, r.cfg.Timeout))
 		r.monitors[addr].RegisterUpdateListener(func(b *Backend) {
 			r.aggr <- b
 		})
@@ -58,8 +110,9 @@ func (r *Registry) Add(addr string) (*Backend, error) {
 			return nil, err
 		}
 	}
-	go func() { r.aggr <- r.backends[addr] }()
-	return r.backends[addr], nil
+	r.log.Debugw("registered backend", "backend", addr, "priority", e.Priority, "weight", b.Weight())
+	go func() { r.aggr <- b }()
+	return b, nil
 }
 
 func (r *Registry) Remove(addr string) {
@@ -68,6 +121,104 @@ func (r *Registry) Remove(addr string) {
 	r.remove(addr)
 }
 
+// Get returns the backend registered for addr, or nil.
+func (r *Registry) Get(addr string) *Backend {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.backends[addr]
+}
+
+// Drain stops new connections being sent to the backend
+// registered for addr, without affecting existing ones.
+func (r *Registry) Drain(addr string) error {
+	_, err := r.drain(addr)
+	return err
+}
+
+// drain marks the backend registered for addr as
+// DRAINING and returns it, under the same lock.
+func (r *Registry) drain(addr string) (*Backend, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	b, exists := r.backends[addr]
+	if !exists {
+		return nil, &UnknownBackendError{addr}
+	}
+	if b.SetState(DRAINING) {
+		go func() { r.aggr <- b }()
+	}
+	return b, nil
+}
+
+// SetWeight changes the weight of the backend registered for addr.
+func (r *Registry) SetWeight(addr string, weight uint32) error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	b, exists := r.backends[addr]
+	if !exists {
+		return &UnknownBackendError{addr}
+	}
+	if atomic.SwapUint32(&b.weight, weight) != weight {
+		go func() { r.aggr <- b }()
+	}
+	return nil
+}
+
+// DrainAndRemove drains the backend registered for addr, waits
+// for its connections to close or the timeout to pass, and then
+// removes it. It reports whether every connection had closed.
+// Dials begun before the backend was drained are waited for too,
+// since they may yet open connections. A Reconciler won't add
+// the backend again until its endpoint is no longer discovered.
+func (r *Registry) DrainAndRemove(addr string, timeout time.Duration) (bool, error) {
+	b, err := r.drain(addr)
+	if err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for !idle(b) && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// Only remove the backend that was drained, not
+	// one that has been added again since.
+	if r.backends[addr] == b {
+		r.remove(addr)
+		r.removed[addr] = true
+	}
+	return idle(b), nil
+}
+
+func idle(b *Backend) bool {
+	return b.ActiveConns() == 0 && b.PendingDials() == 0
+}
+
+// SetHealthCheckFactory changes the health check used
+// for backends that are subsequently added.
+func (r *Registry) SetHealthCheckFactory(factory HealthCheckFactory) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.checks = factory
+}
+
+// SetLimits changes the limits of backends that are subsequently added.
+func (r *Registry) SetLimits(limits Limits) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.limits = limits
+}
+
+// SetLogger changes the logger that the registry, its health
+// monitors and its reconcilers log to.
+func (r *Registry) SetLogger(log *logger.Logger) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.log = log
+}
+
 func (r *Registry) RegisterUpdateListener(listener UpdateListener) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -84,6 +235,17 @@ func (r *Registry) Snapshot() []Backend {
 	return backends
 }
 
+// Endpoints returns the address and priority of each registered backend.
+func (r *Registry) Endpoints() []Endpoint {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	endpoints := make([]Endpoint, 0, len(r.backends))
+	for _, b := range r.backends {
+		endpoints = append(endpoints, Endpoint{Addr: b.addr, Priority: b.priority, Weight: atomic.LoadUint32(&b.weight)})
+	}
+	return endpoints
+}
+
 func (r *Registry) EvictAll() {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -95,9 +257,8 @@ func (r *Registry) EvictAll() {
 func (r *Registry) remove(addr string) {
 	b, exists := r.backends[addr]
 	if exists {
-		// TODO: it should probably provide
-		// better context about its removal.
-		b.SetState(UNHEALTHY)
+		b.SetState(REMOVED)
+		r.log.Debugw("deregistered backend", "backend", addr)
 		go func() { r.aggr <- b }()
 		delete(r.backends, addr)
 	}
@@ -20,7 +20,8 @@ func TestUpdates(t *testing.T) {
 
 	updatec := make(chan Backend)
 	registry.RegisterUpdateListener(func(backend *Backend) {
-		updatec <- *backend
+		// The health monitor changes the backend's state concurrently.
+		updatec <- Backend{addr: backend.Addr(), state: backend.State()}
 	})
 
 	backend1 := proxytesting.NewLocalListener(t)
@@ -56,9 +57,10 @@ func TestUpdates(t *testing.T) {
 	// Removing backend2 publishes an update.
 	registry.Remove(backend2.Addr().String())
 	update = <-updatec
-	if updateState != UNHEALTHY || update.Addr() != backend2.Addr().String() {
+	updateState = update.State()
+	if updateState != REMOVED || update.Addr() != backend2.Addr().String() {
 		t.Errorf(
-			"update expected to indicate backend2 (%s) removed as UNHEALTHY, was %s %s",
+			"update expected to indicate backend2 (%s) REMOVED, was %s %s",
 			backend2.Addr().String(),
 			update.Addr(),
 			updateState.String(),
@@ -127,8 +129,86 @@ func TestNoHealthConfig(t *testing.T) {
 	registry.Remove("localhost:12345")
 	update = <-updatec
 	updateState = update.State()
-	if updateState != UNHEALTHY {
-		t.Errorf("update expected to indicate UNHEALTHY, was %s", updateState.String())
+	if updateState != REMOVED {
+		t.Errorf("update expected to indicate REMOVED, was %s", updateState.String())
+	}
+}
+
+func TestDrainAndRemove(t *testing.T) {
+	cfg := health.HealthCheckConfig{
+		Timeout:            10 * time.Millisecond,
+		Interval:           10 * time.Millisecond,
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   1,
+	}
+	registry := NewRegistry(cfg)
+	defer registry.EvictAll()
+
+	listener := proxytesting.NewLocalListener(t)
+	defer listener.Close()
+	addr := listener.Addr().String()
+	backend, _ := registry.Add(addr)
+	backend.IncrActiveConns()
+
+	if err := registry.Drain("localhost:1"); err == nil {
+		t.Error("expected draining an unknown backend to fail")
+	}
+
+	// Passing health checks don't undo draining.
+	check(t, registry.Drain(addr))
+	time.Sleep(50 * time.Millisecond)
+	if backend.State() != DRAINING {
+		t.Errorf("expected backend to be DRAINING, was %s", backend.State().String())
+	}
+
+	// The backend is removed once its connections close.
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		backend.DecrActiveConns()
+	}()
+	drained, err := registry.DrainAndRemove(addr, 5*time.Second)
+	check(t, err)
+	if !drained {
+		t.Error("expected backend to have drained")
+	}
+	if backend.State() != REMOVED || registry.Get(addr) != nil {
+		t.Errorf("expected backend to be REMOVED, was %s", backend.State().String())
+	}
+
+	// Or once the timeout passes.
+	backend, _ = registry.Add(addr)
+	backend.IncrActiveConns()
+	drained, err = registry.DrainAndRemove(addr, 150*time.Millisecond)
+	check(t, err)
+	if drained || registry.Get(addr) != nil {
+		t.Error("expected backend to be removed before draining")
+	}
+
+	// Dials begun before draining are waited for.
+	backend, _ = registry.Add(addr)
+	if !backend.BeginDial() {
+		t.Fatal("expected to be able to dial the backend")
+	}
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		backend.EndDial(true)
+		backend.DecrActiveConns()
+	}()
+	drained, err = registry.DrainAndRemove(addr, 5*time.Second)
+	check(t, err)
+	if !drained || backend.PendingDials() != 0 || backend.ActiveConns() != 0 {
+		t.Error("expected the pending dial's connection to have closed")
+	}
+
+	// A backend that's already gone can't be removed.
+	if _, err := registry.DrainAndRemove(addr, time.Second); err == nil {
+		t.Error("expected removing an unknown backend to fail")
+	}
+}
+
+func check(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
 	}
 }
 
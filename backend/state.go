@@ -2,15 +2,21 @@ package backend
 
 type State uint32
 
+// A DRAINING backend isn't sent new connections but keeps
+// its existing ones. REMOVED backends are no longer in the
+// registry. Health checks only move backends between
+// HEALTHY and UNHEALTHY.
 const (
 	HEALTHY   State = 1
 	UNHEALTHY State = 2
+	DRAINING  State = 3
+	REMOVED   State = 4
 )
 
 func (s State) String() string {
-	strings := [...]string{"HEALTHY", "UNHEALTHY"}
+	strings := [...]string{"HEALTHY", "UNHEALTHY", "DRAINING", "REMOVED"}
 	switch s {
-	case HEALTHY, UNHEALTHY:
+	case HEALTHY, UNHEALTHY, DRAINING, REMOVED:
 		return strings[s-1]
 	default:
 		return "UNKNOWN"
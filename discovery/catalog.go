@@ -0,0 +1,246 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCatalogWait = 5 * time.Minute
+	minCatalogBackoff  = 1 * time.Second
+	maxCatalogBackoff  = 1 * time.Minute
+	// Blocking queries return at once while the index is 0,
+	// so don't poll faster than this.
+	minCatalogInterval = 1 * time.Second
+	priorityTag        = "priority="
+)
+
+// CatalogWatcher is a backend.Discoverer of the instances
+// of a service in a Consul-style catalog, polled with
+// blocking queries: each request waits (up to Wait) for the
+// catalog's index, in the X-Consul-Index header, to move on from
+// the last one seen. Failed requests are retried with exponential
+// backoff, keeping the last set of instances.
+//
+// The URL is a health endpoint returning a JSON array of service
+// instances, e.g. http://localhost:8500/v1/health/service/web,
+// optionally with query parameters like passing or tag to filter
+// them. Instances with a critical check are left out, and the
+// others are weighted by their passing or warning weight. Instances
+// tagged priority=N are in priority tier N.
+type CatalogWatcher struct {
+	url    string
+	wait   time.Duration
+	client *http.Client
+	update func([]backend.Endpoint)
+	index  uint64
+	// Exposed for tests.
+	minBackoff  time.Duration
+	minInterval time.Duration
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewCatalogWatcher creates a CatalogWatcher for the instances at
+// rawURL, whose blocking queries wait up to wait, or 5 minutes if
+// it isn't positive.
+func NewCatalogWatcher(rawURL string, wait time.Duration) (*CatalogWatcher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New(fmt.Sprintf("invalid catalog URL %q", rawURL))
+	}
+	if wait <= 0 {
+		wait = defaultCatalogWait
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CatalogWatcher{
+		url:  rawURL,
+		wait: wait,
+		// Leave time for the catalog to respond after waiting.
+		client:      &http.Client{Timeout: wait + wait/16 + 10*time.Second},
+		minBackoff:  minCatalogBackoff,
+		minInterval: minCatalogInterval,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// Start reads the catalog, which must respond, then watches
+// it for changes in the background until Stop.
+func (w *CatalogWatcher) Start(update func([]backend.Endpoint)) error {
+	w.update = update
+	if err := w.poll(); err != nil {
+		w.cancel()
+		close(w.done)
+		return err
+	}
+	go w.run()
+	return nil
+}
+
+// Stop stops watching the catalog.
+func (w *CatalogWatcher) Stop() {
+	w.closeOnce.Do(w.cancel)
+	<-w.done
+}
+
+func (w *CatalogWatcher) run() {
+	defer close(w.done)
+	backoff := time.Duration(0)
+	for {
+		start := time.Now()
+		err := w.poll()
+		switch {
+		case w.ctx.Err() != nil:
+			return
+		case err != nil:
+			if backoff == 0 {
+				backoff = w.minBackoff
+			} else if backoff *= 2; backoff > maxCatalogBackoff {
+				backoff = maxCatalogBackoff
+			}
+			logger.Error(errors.Wrapf(err, "discovery: retrying in %v", backoff))
+		default:
+			backoff = 0
+		}
+
+		delay := backoff
+		if elapsed := time.Since(start); delay == 0 && elapsed < w.minInterval {
+			delay = w.minInterval - elapsed
+		}
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// catalogEntry is an instance of a service, as returned
+// by Consul's /v1/health/service/<service> endpoint.
+type catalogEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Tags    []string
+		Weights struct {
+			Passing uint32
+			Warning uint32
+		}
+	}
+	Checks []struct {
+		Status string
+	}
+}
+
+// poll waits for the catalog to change, if it has been
+// read before, and passes on its instances.
+func (w *CatalogWatcher) poll() error {
+	u, _ := url.Parse(w.url)
+	query := u.Query()
+	if w.index > 0 {
+		query.Set("index", strconv.FormatUint(w.index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(w.wait/time.Second)))
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Do(req.WithContext(w.ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("catalog %s responded %s", w.url, resp.Status))
+	}
+
+	index, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		index = 0
+	}
+	if index == w.index && index > 0 {
+		// The wait timed out without any changes.
+		return nil
+	}
+
+	var entries []catalogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return errors.Wrapf(err, "invalid response from catalog %s", w.url)
+	}
+	// An index that goes backwards (e.g. after the catalog's state
+	// is restored) would make later queries block needlessly.
+	if index < w.index {
+		index = 0
+	}
+	w.index = index
+
+	w.update(endpointsOf(entries))
+	return nil
+}
+
+// endpointsOf maps service instances to endpoints,
+// leaving out instances with a critical check.
+func endpointsOf(entries []catalogEntry) []backend.Endpoint {
+	endpoints := make([]backend.Endpoint, 0, len(entries))
+	for _, entry := range entries {
+		status := "passing"
+		for _, check := range entry.Checks {
+			if check.Status == "critical" {
+				status = check.Status
+				break
+			}
+			if check.Status == "warning" {
+				status = check.Status
+			}
+		}
+		if status == "critical" {
+			continue
+		}
+
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		weight := entry.Service.Weights.Passing
+		if status == "warning" {
+			weight = entry.Service.Weights.Warning
+		}
+		priority := 0
+		for _, tag := range entry.Service.Tags {
+			if !strings.HasPrefix(tag, priorityTag) {
+				continue
+			}
+			if p, err := strconv.Atoi(strings.TrimPrefix(tag, priorityTag)); err == nil && p >= 0 {
+				priority = p
+			}
+		}
+		endpoints = append(endpoints, backend.Endpoint{
+			Addr:     net.JoinHostPort(address, strconv.Itoa(entry.Service.Port)),
+			Priority: priority,
+			Weight:   weight,
+		})
+	}
+	return endpoints
+}
@@ -0,0 +1,161 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/jmuia/tcp-proxy/health"
+)
+
+// catalog is a stand-in for Consul's health endpoint,
+// supporting blocking queries.
+type catalog struct {
+	lock    sync.Mutex
+	index   uint64
+	entries []catalogEntry
+	// Closed and replaced when the entries change.
+	changed chan struct{}
+	fail    bool
+	queries int
+}
+
+func newCatalog() *catalog {
+	return &catalog{index: 1, changed: make(chan struct{})}
+}
+
+func (c *catalog) set(index uint64, entries ...catalogEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.index = index
+	c.entries = entries
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *catalog) setFail(fail bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fail = fail
+}
+
+func (c *catalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.lock.Lock()
+	c.queries++
+	index, changed := c.index, c.changed
+	c.lock.Unlock()
+
+	if wanted, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); wanted > 0 && wanted == index {
+		wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fail {
+		http.Error(w, "no cluster leader", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	json.NewEncoder(w).Encode(c.entries)
+}
+
+func instance(node, address string, port int, status string, tags ...string) catalogEntry {
+	var e catalogEntry
+	e.Node.Address = node
+	e.Service.Address = address
+	e.Service.Port = port
+	e.Service.Tags = tags
+	e.Service.Weights.Passing = 10
+	e.Service.Weights.Warning = 1
+	e.Checks = append(e.Checks, struct{ Status string }{"passing"}, struct{ Status string }{status})
+	return e
+}
+
+func TestCatalogWatcher(t *testing.T) {
+	c := newCatalog()
+	c.set(5,
+		instance("10.0.0.1", "", 8080, "passing"),
+		instance("10.0.0.2", "10.0.1.2", 8080, "warning"),
+		instance("10.0.0.3", "", 8080, "critical"),
+		instance("192.0.2.1", "", 8080, "passing", "dr", "priority=1"),
+	)
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	registry := backend.NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	w, err := NewCatalogWatcher(server.URL+"/v1/health/service/web?passing=false", 1*time.Minute)
+	check(t, err)
+	w.minBackoff = 10 * time.Millisecond
+	w.minInterval = 10 * time.Millisecond
+	r := reconcile(registry, w)
+	check(t, r.Start())
+	defer r.Stop()
+
+	// Instances are weighted by their health, and
+	// critical ones left out.
+	assertEndpoints(t, registry,
+		backend.Endpoint{Addr: "10.0.0.1:8080", Weight: 10},
+		backend.Endpoint{Addr: "10.0.1.2:8080", Weight: 1},
+		backend.Endpoint{Addr: "192.0.2.1:8080", Priority: 1, Weight: 10},
+	)
+
+	// Changes are seen as soon as a blocking query returns...
+	c.set(6, instance("10.0.0.1", "", 8080, "passing"), instance("10.0.0.2", "10.0.1.2", 8080, "passing"))
+	waitForEndpoints(t, registry,
+		backend.Endpoint{Addr: "10.0.0.1:8080", Weight: 10},
+		backend.Endpoint{Addr: "10.0.1.2:8080", Weight: 10},
+	)
+
+	// ...errors keep the last instances...
+	c.setFail(true)
+	c.set(7)
+	time.Sleep(100 * time.Millisecond)
+	assertEndpoints(t, registry,
+		backend.Endpoint{Addr: "10.0.0.1:8080", Weight: 10},
+		backend.Endpoint{Addr: "10.0.1.2:8080", Weight: 10},
+	)
+	c.lock.Lock()
+	queries := c.queries
+	c.lock.Unlock()
+	// The first query, the two blocking ones that saw changes,
+	// and retries after 10ms, 20ms and 40ms.
+	if queries > 7 {
+		t.Errorf("expected failed queries to back off, made %d", queries)
+	}
+
+	// ...and an index that goes backwards is reset.
+	c.setFail(false)
+	c.set(2, instance("10.0.0.4", "", 8080, "passing"))
+	waitForEndpoints(t, registry, backend.Endpoint{Addr: "10.0.0.4:8080", Weight: 10})
+	r.Stop()
+	if w.index >= 7 {
+		t.Errorf("expected index to be reset, was %d", w.index)
+	}
+}
+
+func TestCatalogWatcherInvalid(t *testing.T) {
+	if _, err := NewCatalogWatcher("localhost:8500/v1/health/service/web", 0); err == nil {
+		t.Error("expected a URL without a scheme to be invalid")
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	w, err := NewCatalogWatcher(server.URL, 0)
+	check(t, err)
+	if err := w.Start(ignore); err == nil {
+		w.Stop()
+		t.Error("expected a catalog that doesn't respond to fail to start")
+	}
+}
@@ -0,0 +1,11 @@
+// Package discovery provides backend.Discoverers of endpoints
+// from files, DNS names and Consul-style service catalogs.
+package discovery
+
+import "github.com/jmuia/tcp-proxy/backend"
+
+var (
+	_ backend.Discoverer = (*FileWatcher)(nil)
+	_ backend.Discoverer = (*DNSWatcher)(nil)
+	_ backend.Discoverer = (*CatalogWatcher)(nil)
+)
@@ -0,0 +1,206 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	dnsPrefix = "dns:"
+	srvPrefix = "srv:"
+	// Names aren't re-resolved more often than this,
+	// however short their TTLs.
+	minRefreshInterval = 1 * time.Second
+)
+
+// IsDNS reports whether addr is a name to be resolved into
+// backends: dns:NAME:PORT for each of NAME's addresses, or
+// srv:_SERVICE._PROTO.NAME for each target of its SRV records.
+func IsDNS(addr string) bool {
+	return strings.HasPrefix(addr, dnsPrefix) || strings.HasPrefix(addr, srvPrefix)
+}
+
+// DNSWatcher is a backend.Discoverer that expands dns: and srv:
+// endpoints (see IsDNS) into an endpoint per address. Names are
+// re-resolved every interval, or sooner when the resolver reports
+// a shorter TTL. A name that fails to resolve keeps the endpoints
+// it last resolved to.
+//
+// The targets of SRV records with the lowest priority are in the
+// endpoint's priority tier, those with the next lowest in the tier
+// after, and so on. SRV weights are ignored.
+type DNSWatcher struct {
+	names    []backend.Endpoint
+	resolver Resolver
+	interval time.Duration
+	update   func([]backend.Endpoint)
+	// The last successful resolution of each name.
+	resolved map[string][]backend.Endpoint
+	// The endpoints last passed to update.
+	last      []backend.Endpoint
+	stopc     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewDNSWatcher creates a DNSWatcher of names, resolved with
+// resolver, or the system's resolver if nil, every interval, or
+// DefaultPollInterval if it isn't positive.
+func NewDNSWatcher(names []backend.Endpoint, resolver Resolver, interval time.Duration) *DNSWatcher {
+	if resolver == nil {
+		resolver = NetResolver{}
+	}
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	return &DNSWatcher{
+		names:    names,
+		resolver: resolver,
+		interval: interval,
+		resolved: make(map[string][]backend.Endpoint),
+		stopc:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start resolves every name, all of which must resolve, then
+// re-resolves them in the background until Stop.
+func (w *DNSWatcher) Start(update func([]backend.Endpoint)) error {
+	w.update = update
+	next, err := w.refresh()
+	if err != nil {
+		close(w.done)
+		return err
+	}
+	go w.run(next)
+	return nil
+}
+
+// Stop stops resolving names.
+func (w *DNSWatcher) Stop() {
+	w.closeOnce.Do(func() {
+		close(w.stopc)
+	})
+	<-w.done
+}
+
+func (w *DNSWatcher) run(next time.Duration) {
+	defer close(w.done)
+	timer := time.NewTimer(next)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.stopc:
+			return
+		case <-timer.C:
+		}
+		next, err := w.refresh()
+		if err != nil {
+			logger.Error(errors.Wrap(err, "discovery: keeping the last resolved backends"))
+		}
+		timer.Reset(next)
+	}
+}
+
+// refresh resolves every name and passes on the endpoints, if they
+// changed, returning how long until names should be resolved again.
+func (w *DNSWatcher) refresh() (time.Duration, error) {
+	next := w.interval
+	var firstErr error
+	var endpoints []backend.Endpoint
+	for _, e := range w.names {
+		resolved, ttl, err := w.resolve(e)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			endpoints = append(endpoints, w.resolved[e.Addr]...)
+			continue
+		}
+		w.resolved[e.Addr] = resolved
+		endpoints = append(endpoints, resolved...)
+		if ttl > 0 && ttl < next {
+			next = ttl
+		}
+	}
+	if next < minRefreshInterval {
+		next = minRefreshInterval
+	}
+	if firstErr != nil && len(w.resolved) < len(w.names) {
+		// Not every name has resolved yet; don't pass on a partial set.
+		return next, firstErr
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Addr < endpoints[j].Addr
+	})
+	if w.last == nil || !reflect.DeepEqual(endpoints, w.last) {
+		w.last = endpoints
+		w.update(endpoints)
+	}
+	return next, firstErr
+}
+
+// resolve expands a dns: or srv: endpoint, returning
+// the shortest TTL among its records, or 0 if unknown.
+func (w *DNSWatcher) resolve(e backend.Endpoint) ([]backend.Endpoint, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.interval)
+	defer cancel()
+
+	if strings.HasPrefix(e.Addr, dnsPrefix) {
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(e.Addr, dnsPrefix))
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "invalid backend %s", e.Addr)
+		}
+		addrs, ttl, err := w.resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to resolve %s", e.Addr)
+		}
+		endpoints := make([]backend.Endpoint, 0, len(addrs))
+		for _, addr := range addrs {
+			endpoints = append(endpoints, backend.Endpoint{Addr: net.JoinHostPort(addr, port), Priority: e.Priority})
+		}
+		return endpoints, ttl, nil
+	}
+
+	srvs, ttl, err := w.resolver.LookupSRV(ctx, strings.TrimPrefix(e.Addr, srvPrefix))
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to resolve %s", e.Addr)
+	}
+	if len(srvs) == 0 {
+		return nil, 0, errors.New(fmt.Sprintf("failed to resolve %s: no SRV records", e.Addr))
+	}
+	sort.Slice(srvs, func(i, j int) bool {
+		return srvs[i].Priority < srvs[j].Priority
+	})
+	var endpoints []backend.Endpoint
+	tier := e.Priority
+	for i, srv := range srvs {
+		if i > 0 && srv.Priority != srvs[i-1].Priority {
+			tier++
+		}
+		addrs, hostTTL, err := w.resolver.LookupHost(ctx, strings.TrimSuffix(srv.Target, "."))
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to resolve %s", e.Addr)
+		}
+		if hostTTL > 0 && (ttl == 0 || hostTTL < ttl) {
+			ttl = hostTTL
+		}
+		port := strconv.Itoa(int(srv.Port))
+		for _, addr := range addrs {
+			endpoints = append(endpoints, backend.Endpoint{Addr: net.JoinHostPort(addr, port), Priority: tier})
+		}
+	}
+	return endpoints, ttl, nil
+}
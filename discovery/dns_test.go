@@ -0,0 +1,279 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/jmuia/tcp-proxy/health"
+)
+
+// dnsServer is an in-process DNS server answering A, AAAA and SRV
+// queries over UDP and TCP from a table of records.
+type dnsServer struct {
+	addr string
+	pc   net.PacketConn
+	ln   net.Listener
+
+	lock    sync.Mutex
+	records map[string][]dnsRecord
+	// Whether UDP responses are truncated, forcing a retry over TCP.
+	truncate bool
+}
+
+func newDNSServer(t *testing.T) *dnsServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check(t, err)
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+	s := &dnsServer{addr: pc.LocalAddr().String(), pc: pc, ln: ln, records: make(map[string][]dnsRecord)}
+	go s.serveUDP()
+	go s.serveTCP()
+	return s
+}
+
+func (s *dnsServer) Close() {
+	s.pc.Close()
+	s.ln.Close()
+}
+
+// set replaces the records of a name, which is fully qualified.
+func (s *dnsServer) set(name string, records ...dnsRecord) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range records {
+		records[i].name = name
+	}
+	s.records[name] = records
+}
+
+func (s *dnsServer) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, s.addr)
+		},
+	}
+}
+
+func (s *dnsServer) serveUDP() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.respond(buf[:n], true); resp != nil {
+			s.pc.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *dnsServer) serveTCP() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var length [2]byte
+			for {
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := s.respond(query, false)
+				binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+				conn.Write(append(length[:], resp...))
+			}
+		}()
+	}
+}
+
+func (s *dnsServer) respond(query []byte, udp bool) []byte {
+	if len(query) < headerLen {
+		return nil
+	}
+	name, next, err := readName(query, headerLen)
+	if err != nil || next+4 > len(query) {
+		return nil
+	}
+	name = strings.ToLower(name)
+	typ := binary.BigEndian.Uint16(query[next:])
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var answers []dnsRecord
+	for _, r := range s.records[name] {
+		if r.typ == typ {
+			answers = append(answers, r)
+		}
+	}
+
+	resp := make([]byte, headerLen, 512)
+	copy(resp, query[:2])
+	flags := uint16(flagResponse | flagRecursion | 1<<10 | 1<<7)
+	if _, exists := s.records[name]; !exists {
+		flags |= rcodeNXDomain
+	}
+	if udp && s.truncate {
+		flags |= flagTruncated
+		answers = nil
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+	resp = append(resp, query[headerLen:next+4]...)
+	for _, r := range answers {
+		resp, _ = appendName(resp, r.name)
+		var data []byte
+		switch {
+		case r.ip != nil && r.typ == typeA:
+			data = r.ip.To4()
+		case r.ip != nil:
+			data = r.ip.To16()
+		default:
+			data = make([]byte, 6)
+			binary.BigEndian.PutUint16(data[0:], r.srv.Priority)
+			binary.BigEndian.PutUint16(data[2:], r.srv.Weight)
+			binary.BigEndian.PutUint16(data[4:], r.srv.Port)
+			data, _ = appendName(data, r.srv.Target)
+		}
+		var fixed [10]byte
+		binary.BigEndian.PutUint16(fixed[0:], r.typ)
+		binary.BigEndian.PutUint16(fixed[2:], classINET)
+		binary.BigEndian.PutUint32(fixed[4:], r.ttl)
+		binary.BigEndian.PutUint16(fixed[8:], uint16(len(data)))
+		resp = append(append(resp, fixed[:]...), data...)
+	}
+	return resp
+}
+
+func a(ip string, ttl uint32) dnsRecord {
+	return dnsRecord{typ: typeA, ttl: ttl, ip: net.ParseIP(ip)}
+}
+
+func aaaa(ip string, ttl uint32) dnsRecord {
+	return dnsRecord{typ: typeAAAA, ttl: ttl, ip: net.ParseIP(ip)}
+}
+
+func srv(target string, port, priority uint16, ttl uint32) dnsRecord {
+	return dnsRecord{typ: typeSRV, ttl: ttl, srv: &net.SRV{Target: target, Port: port, Priority: priority}}
+}
+
+func TestResolvers(t *testing.T) {
+	server := newDNSServer(t)
+	defer server.Close()
+	server.set("db.test.", a("10.0.0.1", 30), a("10.0.0.2", 10), aaaa("2001:db8::1", 60))
+	server.set("_db._tcp.test.", srv("db.test.", 5432, 0, 20))
+
+	tests := []struct {
+		name string
+		r    Resolver
+		tcp  bool
+		// Only resolving a server directly reveals TTLs.
+		ttl time.Duration
+	}{
+		{"DNSResolver", DNSResolver{Server: server.addr}, false, 10 * time.Second},
+		{"DNSResolver over TCP", DNSResolver{Server: server.addr}, true, 10 * time.Second},
+		{"NetResolver", NetResolver{server.resolver()}, false, 0},
+	}
+	for _, test := range tests {
+		name, r := test.name, test.r
+		server.lock.Lock()
+		server.truncate = test.tcp
+		server.lock.Unlock()
+
+		ctx := context.Background()
+		addrs, ttl, err := r.LookupHost(ctx, "db.test")
+		check(t, err)
+		sort.Strings(addrs)
+		if expected := []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"}; !reflect.DeepEqual(addrs, expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, addrs)
+		}
+		if ttl != test.ttl {
+			t.Errorf("%s: expected TTL %v, got %v", name, test.ttl, ttl)
+		}
+
+		srvs, _, err := r.LookupSRV(ctx, "_db._tcp.test")
+		check(t, err)
+		if len(srvs) != 1 || srvs[0].Target != "db.test." || srvs[0].Port != 5432 {
+			t.Errorf("%s: unexpected SRV records %+v", name, srvs)
+		}
+
+		if _, _, err := r.LookupHost(ctx, "missing.test"); err == nil {
+			t.Errorf("%s: expected missing.test not to resolve", name)
+		}
+	}
+}
+
+func TestDNSWatcher(t *testing.T) {
+	server := newDNSServer(t)
+	defer server.Close()
+	server.set("db.test.", a("10.0.0.1", 1), a("10.0.0.2", 1))
+	server.set("primary.test.", a("10.0.1.1", 1))
+	server.set("dr.test.", a("10.0.2.1", 1))
+	server.set("_app._tcp.test.", srv("primary.test.", 8080, 10, 1), srv("dr.test.", 8081, 20, 1))
+
+	registry := backend.NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	names := []backend.Endpoint{
+		{Addr: "dns:db.test:5432"},
+		{Addr: "srv:_app._tcp.test", Priority: 1},
+	}
+	// The records' TTLs are shorter than the interval.
+	w := NewDNSWatcher(names, DNSResolver{Server: server.addr}, 1*time.Minute)
+	r := reconcile(registry, backend.Static{{Addr: "localhost:9000"}}, w)
+	check(t, r.Start())
+	defer r.Stop()
+	assertEndpoints(t, registry,
+		backend.Endpoint{Addr: "localhost:9000"},
+		backend.Endpoint{Addr: "10.0.0.1:5432"},
+		backend.Endpoint{Addr: "10.0.0.2:5432"},
+		backend.Endpoint{Addr: "10.0.1.1:8080", Priority: 1},
+		backend.Endpoint{Addr: "10.0.2.1:8081", Priority: 2},
+	)
+
+	// Changes are picked up once the records expire...
+	server.set("db.test.", a("10.0.0.2", 1), a("10.0.0.3", 1))
+	// ...but names that stop resolving keep their backends.
+	server.set("_app._tcp.test.")
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) && registry.Get("10.0.0.3:5432") == nil {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assertEndpoints(t, registry,
+		backend.Endpoint{Addr: "localhost:9000"},
+		backend.Endpoint{Addr: "10.0.0.2:5432"},
+		backend.Endpoint{Addr: "10.0.0.3:5432"},
+		backend.Endpoint{Addr: "10.0.1.1:8080", Priority: 1},
+		backend.Endpoint{Addr: "10.0.2.1:8081", Priority: 2},
+	)
+}
+
+func TestDNSWatcherUnresolvable(t *testing.T) {
+	server := newDNSServer(t)
+	defer server.Close()
+
+	w := NewDNSWatcher([]backend.Endpoint{{Addr: "dns:missing.test:80"}}, DNSResolver{Server: server.addr}, 0)
+	if err := w.Start(ignore); err == nil {
+		w.Stop()
+		t.Error("expected an unresolvable name to fail to start")
+	}
+}
@@ -0,0 +1,185 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Just enough of the DNS wire format (RFC 1035) to look up
+// A, AAAA and SRV records along with their TTLs.
+
+const (
+	typeA     uint16 = 1
+	typeAAAA  uint16 = 28
+	typeSRV   uint16 = 33
+	classINET uint16 = 1
+
+	headerLen = 12
+	// Flags.
+	flagResponse  = 1 << 15
+	flagTruncated = 1 << 9
+	flagRecursion = 1 << 8
+	rcodeMask     = 0xf
+	rcodeNXDomain = 3
+	// Bounds the compression pointers followed in one name.
+	maxPointers = 16
+)
+
+// errUnexpectedMessage is returned for messages that aren't
+// responses to the query, e.g. late responses to an earlier one.
+var errUnexpectedMessage = errors.New("unexpected DNS message")
+
+type dnsRecord struct {
+	name string
+	typ  uint16
+	ttl  uint32
+	ip   net.IP
+	srv  *net.SRV
+}
+
+// newQuery encodes a recursive query for name's records of type typ.
+func newQuery(id uint16, name string, typ uint16) ([]byte, error) {
+	msg := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], flagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg, err := appendName(msg, name)
+	if err != nil {
+		return nil, err
+	}
+	msg = append(msg, byte(typ>>8), byte(typ), byte(classINET>>8), byte(classINET))
+	return msg, nil
+}
+
+// appendName encodes a domain name, without compression.
+func appendName(msg []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, errors.New(fmt.Sprintf("invalid domain name %q", name))
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	return append(msg, 0), nil
+}
+
+// readName decodes the domain name at off, following
+// compression pointers, and returns the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for pointers := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("truncated domain name")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || pointers == maxPointers {
+				return "", 0, errors.New("invalid domain name compression")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			pointers++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errors.New("truncated domain name")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// parseResponse decodes the answers in a response to the query with
+// the given id, reporting whether the response was truncated.
+func parseResponse(msg []byte, id uint16) ([]dnsRecord, bool, error) {
+	if len(msg) < headerLen {
+		return nil, false, errors.New("truncated DNS response")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if binary.BigEndian.Uint16(msg[0:]) != id || flags&flagResponse == 0 {
+		return nil, false, errUnexpectedMessage
+	}
+	if flags&flagTruncated != 0 {
+		return nil, true, nil
+	}
+	switch rcode := flags & rcodeMask; rcode {
+	case 0:
+	case rcodeNXDomain:
+		return nil, false, errors.New("no such host")
+	default:
+		return nil, false, errors.New(fmt.Sprintf("DNS server failure (rcode %d)", rcode))
+	}
+
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+	off := headerLen
+	for i := 0; i < questions; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, false, err
+		}
+		off = next + 4
+	}
+
+	var records []dnsRecord
+	for i := 0; i < answers; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return nil, false, err
+		}
+		off = next
+		if off+10 > len(msg) {
+			return nil, false, errors.New("truncated DNS record")
+		}
+		r := dnsRecord{
+			name: name,
+			typ:  binary.BigEndian.Uint16(msg[off:]),
+			ttl:  binary.BigEndian.Uint32(msg[off+4:]),
+		}
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, false, errors.New("truncated DNS record")
+		}
+		data := msg[off : off+length]
+
+		switch {
+		case r.typ == typeA && length == net.IPv4len, r.typ == typeAAAA && length == net.IPv6len:
+			r.ip = net.IP(append([]byte(nil), data...))
+		case r.typ == typeSRV && length > 6:
+			target, _, err := readName(msg, off+6)
+			if err != nil {
+				return nil, false, err
+			}
+			r.srv = &net.SRV{
+				Target:   target,
+				Port:     binary.BigEndian.Uint16(data[4:]),
+				Priority: binary.BigEndian.Uint16(data[0:]),
+				Weight:   binary.BigEndian.Uint16(data[2:]),
+			}
+		default:
+			// e.g. CNAMEs, whose targets' records follow.
+			off += length
+			continue
+		}
+		records = append(records, r)
+		off += length
+	}
+	return records, false, nil
+}
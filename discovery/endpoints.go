@@ -0,0 +1,82 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ParseJSON parses a JSON array of endpoints, each either a string
+// of the form ADDR[@PRIORITY] or an object with an "addr" field and
+// optional "priority" and "weight" fields.
+func ParseJSON(data []byte) ([]backend.Endpoint, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, errors.Wrap(err, "expected a JSON array of endpoints")
+	}
+	endpoints := make([]backend.Endpoint, 0, len(items))
+	for i, item := range items {
+		var s string
+		if err := json.Unmarshal(item, &s); err == nil {
+			e, err := backend.ParseEndpoint(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "endpoint %d", i)
+			}
+			endpoints = append(endpoints, e)
+			continue
+		}
+		var obj struct {
+			Addr     string `json:"addr"`
+			Priority int    `json:"priority"`
+			Weight   uint32 `json:"weight"`
+		}
+		if err := json.Unmarshal(item, &obj); err != nil || obj.Addr == "" || obj.Priority < 0 {
+			return nil, errors.New(fmt.Sprintf("endpoint %d: expected \"ADDR[@PRIORITY]\" or {\"addr\": ..., \"priority\": ...}, got %s", i, item))
+		}
+		endpoints = append(endpoints, backend.Endpoint{Addr: obj.Addr, Priority: obj.Priority, Weight: obj.Weight})
+	}
+	return endpoints, nil
+}
+
+// ParseYAML parses a YAML list of endpoints, the equivalent
+// of the JSON format accepted by ParseJSON:
+//
+//	# Primary site.
+//	- 10.0.0.1:8080
+//	- addr: 10.0.0.2:8080
+//	  weight: 3
+//	# DR site.
+//	- "192.0.2.1:8080@1"
+func ParseYAML(data []byte) ([]backend.Endpoint, error) {
+	var items []interface{}
+	if err := yaml.Unmarshal(data, &items); err != nil {
+		return nil, errors.Wrap(err, "expected a YAML list of endpoints")
+	}
+	endpoints := make([]backend.Endpoint, 0, len(items))
+	for i, item := range items {
+		if s, ok := item.(string); ok {
+			e, err := backend.ParseEndpoint(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "endpoint %d", i)
+			}
+			endpoints = append(endpoints, e)
+			continue
+		}
+		var obj struct {
+			Addr     string `yaml:"addr"`
+			Priority int    `yaml:"priority"`
+			Weight   uint32 `yaml:"weight"`
+		}
+		_, isMap := item.(map[interface{}]interface{})
+		b, _ := yaml.Marshal(item)
+		if !isMap || yaml.Unmarshal(b, &obj) != nil || obj.Addr == "" || obj.Priority < 0 {
+			return nil, errors.New(fmt.Sprintf("endpoint %d: expected \"ADDR[@PRIORITY]\" or {addr: ..., priority: ..., weight: ...}, got %s", i, strings.TrimSpace(string(b))))
+		}
+		endpoints = append(endpoints, backend.Endpoint{Addr: obj.Addr, Priority: obj.Priority, Weight: obj.Weight})
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jmuia/tcp-proxy/backend"
+)
+
+func TestParse(t *testing.T) {
+	expected := []backend.Endpoint{
+		{Addr: "10.0.0.1:8080"},
+		{Addr: "10.0.0.2:8080", Priority: 1},
+		{Addr: "unix:/var/run/app#1.sock"},
+	}
+
+	endpoints, err := ParseJSON([]byte(`["10.0.0.1:8080", {"addr": "10.0.0.2:8080", "priority": 1}, "unix:/var/run/app#1.sock"]`))
+	check(t, err)
+	if !reflect.DeepEqual(endpoints, expected) {
+		t.Errorf("expected %+v from JSON, got %+v", expected, endpoints)
+	}
+
+	endpoints, err = ParseJSON([]byte(`[{"addr": "10.0.0.1:8080", "weight": 3}]`))
+	check(t, err)
+	if weighted := []backend.Endpoint{{Addr: "10.0.0.1:8080", Weight: 3}}; !reflect.DeepEqual(endpoints, weighted) {
+		t.Errorf("expected %+v from JSON, got %+v", weighted, endpoints)
+	}
+
+	endpoints, err = ParseYAML([]byte("---\n# Primary site.\n- 10.0.0.1:8080\n- '10.0.0.2:8080@1' # DR site.\n\n- \"unix:/var/run/app#1.sock\"\n"))
+	check(t, err)
+	if !reflect.DeepEqual(endpoints, expected) {
+		t.Errorf("expected %+v from YAML, got %+v", expected, endpoints)
+	}
+
+	endpoints, err = ParseYAML([]byte("- 10.0.0.1:8080\n- addr: 10.0.0.2:8080\n  priority: 1\n- {addr: \"unix:/var/run/app#1.sock\"}\n"))
+	check(t, err)
+	if !reflect.DeepEqual(endpoints, expected) {
+		t.Errorf("expected %+v from YAML mappings, got %+v", expected, endpoints)
+	}
+
+	endpoints, err = ParseYAML([]byte("- addr: 10.0.0.1:8080\n  weight: 3\n"))
+	check(t, err)
+	if weighted := []backend.Endpoint{{Addr: "10.0.0.1:8080", Weight: 3}}; !reflect.DeepEqual(endpoints, weighted) {
+		t.Errorf("expected %+v from YAML, got %+v", weighted, endpoints)
+	}
+
+	endpoints, err = ParseYAML([]byte("# Nothing yet.\n"))
+	check(t, err)
+	if len(endpoints) != 0 {
+		t.Errorf("expected no endpoints from an empty YAML file, got %+v", endpoints)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{
+		`{"endpoints": []}`,
+		`["10.0.0.1:8080@dr"]`,
+		`[{"priority": 1}]`,
+		`[1]`,
+		`["10.0.0.1:8080"`,
+	} {
+		if _, err := ParseJSON([]byte(s)); err == nil {
+			t.Errorf("expected JSON %s to be invalid", s)
+		}
+	}
+	for _, s := range []string{
+		"endpoints:\n  - 10.0.0.1:8080\n",
+		"- 10.0.0.1:8080@-1\n",
+		"-\n",
+		"- priority: 1\n",
+		"- addr: 10.0.0.1:8080\n  weight: heavy\n",
+		"- [10.0.0.1:8080]\n",
+		"- 8080\n",
+	} {
+		if _, err := ParseYAML([]byte(s)); err == nil {
+			t.Errorf("expected YAML %q to be invalid", s)
+		}
+	}
+}
+
+func check(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/pkg/errors"
+)
+
+// DefaultPollInterval is how often a FileWatcher rereads its file
+// by default, whether or not it's notified of changes.
+const DefaultPollInterval = 5 * time.Second
+
+// FileWatcher is a backend.Discoverer of the endpoints in a file:
+// YAML if it has a .yaml or .yml extension (see ParseYAML) and JSON
+// otherwise (see ParseJSON). The file is reread when inotify reports
+// a change to it, where supported, and polled regardless. An invalid
+// file is logged and ignored, keeping the last valid set of endpoints.
+type FileWatcher struct {
+	path     string
+	interval time.Duration
+	update   func([]backend.Endpoint)
+	// The contents last read, valid or not.
+	last      []byte
+	stopc     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewFileWatcher creates a FileWatcher that polls path every
+// interval, or DefaultPollInterval if it isn't positive.
+func NewFileWatcher(path string, interval time.Duration) *FileWatcher {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	return &FileWatcher{
+		path:     path,
+		interval: interval,
+		stopc:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start reads the file, which must be valid, then watches
+// it for changes in the background until Stop.
+func (w *FileWatcher) Start(update func([]backend.Endpoint)) error {
+	w.update = update
+	changes, err := watchFile(w.path, w.stopc)
+	if err != nil {
+		logger.Warnf("discovery: polling %s every %v: %v", w.path, w.interval, err)
+	}
+	return w.start(changes)
+}
+
+func (w *FileWatcher) start(changes <-chan struct{}) error {
+	if err := w.reload(); err != nil {
+		w.closeOnce.Do(func() {
+			close(w.stopc)
+		})
+		close(w.done)
+		return err
+	}
+	go w.run(changes)
+	return nil
+}
+
+// Stop stops watching the file.
+func (w *FileWatcher) Stop() {
+	w.closeOnce.Do(func() {
+		close(w.stopc)
+	})
+	<-w.done
+}
+
+func (w *FileWatcher) run(changes <-chan struct{}) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopc:
+			return
+		case <-changes:
+		case <-ticker.C:
+		}
+		if err := w.reload(); err != nil {
+			logger.Error(errors.Wrap(err, "discovery: keeping the last valid endpoints"))
+		}
+	}
+}
+
+// reload passes on the file's endpoints, if it changed.
+func (w *FileWatcher) reload() error {
+	data, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	if w.last != nil && bytes.Equal(data, w.last) {
+		return nil
+	}
+	w.last = data
+
+	endpoints, err := w.parse(data)
+	if err != nil {
+		return errors.Wrapf(err, "invalid endpoints file %s", w.path)
+	}
+	logger.Infof("discovery: loaded %d endpoints from %s", len(endpoints), w.path)
+	w.update(endpoints)
+	return nil
+}
+
+func (w *FileWatcher) parse(data []byte) ([]backend.Endpoint, error) {
+	// An empty file is more likely to be one being
+	// written than a deliberate lack of endpoints.
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, errors.New("file is empty")
+	}
+	switch strings.ToLower(filepath.Ext(w.path)) {
+	case ".yaml", ".yml":
+		return ParseYAML(data)
+	default:
+		return ParseJSON(data)
+	}
+}
@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/jmuia/tcp-proxy/health"
+)
+
+func TestFileWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	check(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "endpoints.json")
+	check(t, ioutil.WriteFile(path, []byte(`["localhost:9000", "localhost:9001"]`), 0644))
+
+	registry := backend.NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	// Poll rarely, so changes are only seen promptly through inotify.
+	r := reconcile(registry, NewFileWatcher(path, 1*time.Minute))
+	var added []string
+	r.OnAdd(func(b *backend.Backend) {
+		added = append(added, b.Addr())
+	})
+	check(t, r.Start())
+	defer r.Stop()
+	assertEndpoints(t, registry, backend.Endpoint{Addr: "localhost:9000"}, backend.Endpoint{Addr: "localhost:9001"})
+
+	// Files replaced by a rename are picked up...
+	replace(t, path, `["localhost:9001@1", "localhost:9002"]`)
+	waitForEndpoints(t, registry, backend.Endpoint{Addr: "localhost:9001", Priority: 1}, backend.Endpoint{Addr: "localhost:9002"})
+
+	// ...but invalid ones are ignored.
+	replace(t, path, `["localhost:9003"`)
+	replace(t, path, ``)
+	time.Sleep(100 * time.Millisecond)
+	assertEndpoints(t, registry, backend.Endpoint{Addr: "localhost:9001", Priority: 1}, backend.Endpoint{Addr: "localhost:9002"})
+
+	r.Stop()
+	sort.Strings(added)
+	if expected := []string{"localhost:9000", "localhost:9001", "localhost:9001", "localhost:9002"}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected %v to be added, got %v", expected, added)
+	}
+}
+
+func TestFileWatcherPolls(t *testing.T) {
+	f, err := ioutil.TempFile("", "endpoints*.yaml")
+	check(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("- localhost:9000\n")
+	check(t, err)
+	f.Close()
+
+	registry := backend.NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	w := NewFileWatcher(f.Name(), 20*time.Millisecond)
+	r := reconcile(registry, w)
+	check(t, r.Start())
+	defer r.Stop()
+
+	check(t, ioutil.WriteFile(f.Name(), []byte("- localhost:9001\n"), 0644))
+	waitForEndpoints(t, registry, backend.Endpoint{Addr: "localhost:9001"})
+}
+
+func TestFileWatcherInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "endpoints*.json")
+	check(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	w := NewFileWatcher(f.Name(), 0)
+	if err := w.Start(ignore); err == nil {
+		w.Stop()
+		t.Error("expected an empty endpoints file to fail to start")
+	}
+}
+
+// reconcile applies every change from sources to registry immediately.
+func reconcile(registry *backend.Registry, sources ...backend.Discoverer) *backend.Reconciler {
+	r := backend.NewReconciler(registry, sources...)
+	r.SetInterval(0)
+	return r
+}
+
+func ignore([]backend.Endpoint) {}
+
+// replace atomically replaces a file's contents.
+func replace(t *testing.T, path string, contents string) {
+	tmp := path + ".tmp"
+	check(t, ioutil.WriteFile(tmp, []byte(contents), 0644))
+	check(t, os.Rename(tmp, path))
+}
+
+func waitForEndpoints(t *testing.T, registry *backend.Registry, expected ...backend.Endpoint) {
+	t.Helper()
+	deadline := time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) && !reflect.DeepEqual(sorted(registry.Endpoints()), sorted(expected)) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertEndpoints(t, registry, expected...)
+}
+
+func assertEndpoints(t *testing.T, registry *backend.Registry, expected ...backend.Endpoint) {
+	t.Helper()
+	if endpoints := sorted(registry.Endpoints()); !reflect.DeepEqual(endpoints, sorted(expected)) {
+		t.Errorf("expected endpoints %v, got %v", expected, endpoints)
+	}
+}
+
+func sorted(endpoints []backend.Endpoint) []backend.Endpoint {
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Addr < endpoints[j].Addr
+	})
+	return endpoints
+}
@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const inotifyEvents = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM
+
+// watchFile reports changes in path's directory until stop is
+// closed. The directory is watched, rather than the file, so that
+// files replaced by a rename (or a symlink swap) are still seen.
+func watchFile(path string, stop <-chan struct{}) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	wd, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), inotifyEvents)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-stop
+		// Removing the watch queues an IN_IGNORED
+		// event, waking the blocked read below.
+		syscall.InotifyRmWatch(fd, uint32(wd))
+	}()
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 4096)
+		for {
+			_, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err != nil {
+				return
+			}
+			// Coalesce changes the watcher hasn't caught up with.
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package discovery
+
+import "github.com/pkg/errors"
+
+// watchFile isn't supported off Linux; files are only polled.
+func watchFile(path string, stop <-chan struct{}) (<-chan struct{}, error) {
+	return nil, errors.New("file notifications are only supported on Linux")
+}
@@ -0,0 +1,176 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Resolver looks up the records behind dns: and srv: backends,
+// along with how long they may be cached, or 0 if that's unknown.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error)
+	LookupSRV(ctx context.Context, name string) (srvs []*net.SRV, ttl time.Duration, err error)
+}
+
+// NetResolver resolves names with a net.Resolver, or the system's
+// resolver if nil. It can't report TTLs.
+type NetResolver struct {
+	Resolver *net.Resolver
+}
+
+func (r NetResolver) resolver() *net.Resolver {
+	if r.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return r.Resolver
+}
+
+func (r NetResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	addrs, err := r.resolver().LookupHost(ctx, host)
+	return addrs, 0, err
+}
+
+func (r NetResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	_, srvs, err := r.resolver().LookupSRV(ctx, "", "", name)
+	return srvs, 0, err
+}
+
+// DNSResolver queries a DNS server (HOST:PORT) directly, which,
+// unlike NetResolver, reports TTLs. Names are fully qualified;
+// search domains and the hosts file aren't consulted.
+type DNSResolver struct {
+	Server  string
+	Timeout time.Duration
+}
+
+func (r DNSResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, 0, nil
+	}
+	var addrs []string
+	ttl := noTTL
+	for _, typ := range []uint16{typeA, typeAAAA} {
+		records, err := r.exchange(ctx, host, typ)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "lookup %s", host)
+		}
+		for _, record := range records {
+			if record.ip != nil && record.typ == typ {
+				addrs = append(addrs, record.ip.String())
+				ttl = minTTL(ttl, record.ttl)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, errors.New(fmt.Sprintf("lookup %s: no addresses", host))
+	}
+	return addrs, ttl, nil
+}
+
+func (r DNSResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	records, err := r.exchange(ctx, name, typeSRV)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "lookup %s", name)
+	}
+	var srvs []*net.SRV
+	ttl := noTTL
+	for _, record := range records {
+		if record.srv != nil {
+			srvs = append(srvs, record.srv)
+			ttl = minTTL(ttl, record.ttl)
+		}
+	}
+	if len(srvs) == 0 {
+		return nil, 0, errors.New(fmt.Sprintf("lookup %s: no SRV records", name))
+	}
+	return srvs, ttl, nil
+}
+
+// exchange sends a query over UDP, retrying over TCP if
+// the response is truncated, and returns its answers.
+func (r DNSResolver) exchange(ctx context.Context, name string, typ uint16) ([]dnsRecord, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	id := uint16(rand.Uint32())
+	query, err := newQuery(id, name, typ)
+	if err != nil {
+		return nil, err
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	records, truncated, err := r.exchangeOver(ctx, "udp", query, id)
+	if err == nil && truncated {
+		records, _, err = r.exchangeOver(ctx, "tcp", query, id)
+	}
+	return records, err
+}
+
+func (r DNSResolver) exchangeOver(ctx context.Context, network string, query []byte, id uint16) ([]dnsRecord, bool, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, r.Server)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err = conn.Write(query); err != nil {
+			return nil, false, err
+		}
+		buf := make([]byte, 65535)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, false, err
+			}
+			records, truncated, err := parseResponse(buf[:n], id)
+			if err != errUnexpectedMessage {
+				return records, truncated, err
+			}
+		}
+	}
+
+	// Over TCP, messages are prefixed with their length.
+	msg := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	if _, err = conn.Write(append(msg, query...)); err != nil {
+		return nil, false, err
+	}
+	if _, err = io.ReadFull(conn, msg[:2]); err != nil {
+		return nil, false, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(msg))
+	if _, err = io.ReadFull(conn, resp); err != nil {
+		return nil, false, err
+	}
+	return parseResponse(resp, id)
+}
+
+// noTTL is larger than any TTL in seconds, so that
+// minTTL with it returns the other TTL.
+const noTTL = time.Duration(1<<32) * time.Second
+
+// minTTL returns the smaller of ttl and a record's TTL in seconds.
+func minTTL(ttl time.Duration, seconds uint32) time.Duration {
+	if other := time.Duration(seconds) * time.Second; other < ttl {
+		return other
+	}
+	return ttl
+}
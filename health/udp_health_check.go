@@ -0,0 +1,42 @@
+package health
+
+import (
+	"net"
+	"time"
+)
+
+// UDPHealthCheck sends an empty datagram and waits for an ICMP
+// port unreachable error. UDP is connectionless, so a backend
+// that stays silent is assumed to be healthy.
+type UDPHealthCheck struct {
+	addr    string
+	timeout time.Duration
+}
+
+func (hc *UDPHealthCheck) Check() error {
+	conn, err := net.DialTimeout("udp", hc.addr, hc.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte{})
+	if err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(hc.timeout))
+	_, err = conn.Read(make([]byte, 1))
+	if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+		return nil
+	}
+	return err
+}
+
+func (hc *UDPHealthCheck) Addr() string {
+	return hc.addr
+}
+
+func NewUDPHealthCheck(addr string, timeout time.Duration) *UDPHealthCheck {
+	return &UDPHealthCheck{addr, timeout}
+}
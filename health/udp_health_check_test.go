@@ -0,0 +1,37 @@
+package health
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPHealthCheckOk(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	hc := NewUDPHealthCheck(backend.LocalAddr().String(), 10*time.Millisecond)
+	err = hc.Check()
+
+	if err != nil {
+		t.Errorf("UDPHealthCheck failed: %v", err)
+	}
+}
+
+func TestUDPHealthCheckFail(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Close()
+
+	hc := NewUDPHealthCheck(backend.LocalAddr().String(), 100*time.Millisecond)
+	err = hc.Check()
+
+	if err == nil {
+		t.Errorf("UDPHealthCheck passed, but it was expected to fail")
+	}
+}
@@ -0,0 +1,28 @@
+package health
+
+import (
+	"net"
+	"time"
+)
+
+type UnixHealthCheck struct {
+	path    string
+	timeout time.Duration
+}
+
+func (hc *UnixHealthCheck) Check() error {
+	conn, err := net.DialTimeout("unix", hc.path, hc.timeout)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func (hc *UnixHealthCheck) Path() string {
+	return hc.path
+}
+
+func NewUnixHealthCheck(path string, timeout time.Duration) *UnixHealthCheck {
+	return &UnixHealthCheck{path, timeout}
+}
@@ -0,0 +1,46 @@
+package health
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestUnixHealthCheckOk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcp-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := proxytesting.NewLocalUnixListener(t, dir)
+	defer backend.Close()
+
+	hc := NewUnixHealthCheck(backend.Addr().String(), 10*time.Millisecond)
+	err = hc.Check()
+
+	if err != nil {
+		t.Errorf("UnixHealthCheck failed: %v", err)
+	}
+}
+
+func TestUnixHealthCheckFail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcp-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := proxytesting.NewLocalUnixListener(t, dir)
+	backend.Close()
+
+	hc := NewUnixHealthCheck(backend.Addr().String(), 10*time.Millisecond)
+	err = hc.Check()
+
+	if err == nil {
+		t.Errorf("UnixHealthCheck passed, but it was expected to fail")
+	}
+}
@@ -1,5 +1,12 @@
 package loadbalancer
 
 type Config struct {
-	Type Type
+	Type      Type
+	SlowStart SlowStart
+	// How much a priority tier's healthy fraction is scaled up
+	// before its load spills to the next tier. Defaults to 1.4.
+	Overprovisioning float64
+	// The percentage of backends that must be healthy for health
+	// to be taken into account. 0 (the default) disables it.
+	PanicThreshold float64
 }
@@ -0,0 +1,218 @@
+package loadbalancer
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	logger "github.com/jmuia/tcp-proxy/logging"
+)
+
+const defaultOverprovisioning = 1.4
+
+// TierStats describes a priority tier of a load balancer's
+// backends. Load is the share of new connections sent to the
+// tier, and Spillovers counts the connections it was sent while
+// a higher priority tier had backends.
+type TierStats struct {
+	Priority   int
+	Healthy    int
+	Total      int
+	Load       float64
+	Spillovers uint64
+}
+
+// hostSet groups a load balancer's backends into priority tiers
+// and spreads connections across them like Envoy's priority
+// levels: a tier's health is the fraction of its backends that are
+// healthy, scaled up by the overprovisioning factor, and whatever
+// load the healthier tiers can't take spills to the next one.
+//
+// Below the panic threshold (a percentage of backends that are
+// healthy, across every tier) health is ignored and connections
+// are spread across all of the backends.
+//
+// DRAINING and REMOVED backends aren't part of any tier.
+// It isn't safe for concurrent use.
+type hostSet struct {
+	overprovisioning float64
+	panicThreshold   float64
+	panic            bool
+	// Sorted from the highest priority (the lowest number).
+	tiers        []*tier
+	all          []*backend.Backend
+	allMaxWeight uint32
+	log          *logger.Logger
+}
+
+type tier struct {
+	priority   int
+	backends   map[string]*backend.Backend
+	healthy    []*backend.Backend
+	maxWeight  uint32
+	load       float64
+	spillovers uint64
+}
+
+func newHostSet() *hostSet {
+	return &hostSet{overprovisioning: defaultOverprovisioning, log: logger.With()}
+}
+
+// update records a change in b's state.
+func (hs *hostSet) update(b *backend.Backend) {
+	t := hs.tier(b.Priority())
+	_, known := t.backends[b.Addr()]
+	switch b.State() {
+	case backend.HEALTHY, backend.UNHEALTHY:
+		t.backends[b.Addr()] = b
+	default:
+		// The backend may since have been replaced by another at its address.
+		if t.backends[b.Addr()] == b {
+			delete(t.backends, b.Addr())
+		}
+	}
+
+	wasHealthy := false
+	for _, h := range t.healthy {
+		if h == b {
+			wasHealthy = true
+		}
+	}
+	t.healthy = t.healthy[:0]
+	t.maxWeight = 1
+	for _, h := range t.backends {
+		if h.State() == backend.HEALTHY {
+			t.healthy = append(t.healthy, h)
+			if h.Weight() > t.maxWeight {
+				t.maxWeight = h.Weight()
+			}
+		}
+	}
+	isHealthy := b.State() == backend.HEALTHY
+
+	switch {
+	case isHealthy && !wasHealthy:
+		hs.log.Debugw("loadbalancer: added backend", "backend", b.Addr(), "state", b.State().String())
+	case !isHealthy && (wasHealthy || known):
+		hs.log.Debugw("loadbalancer: removed backend", "backend", b.Addr(), "state", b.State().String())
+	}
+
+	if len(t.backends) == 0 {
+		hs.removeTier(t)
+	}
+	hs.rebalance()
+}
+
+func (hs *hostSet) tier(priority int) *tier {
+	for _, t := range hs.tiers {
+		if t.priority == priority {
+			return t
+		}
+	}
+	t := &tier{priority: priority, backends: make(map[string]*backend.Backend)}
+	hs.tiers = append(hs.tiers, t)
+	sort.Slice(hs.tiers, func(i, j int) bool {
+		return hs.tiers[i].priority < hs.tiers[j].priority
+	})
+	return t
+}
+
+func (hs *hostSet) removeTier(t *tier) {
+	for i := range hs.tiers {
+		if hs.tiers[i] == t {
+			hs.tiers = append(hs.tiers[:i], hs.tiers[i+1:]...)
+			return
+		}
+	}
+}
+
+// rebalance recomputes the share of connections each tier is
+// sent, and whether the load balancer is in panic mode.
+func (hs *hostSet) rebalance() {
+	hs.all = hs.all[:0]
+	hs.allMaxWeight = 1
+	healthy := 0
+	for _, t := range hs.tiers {
+		for _, b := range t.backends {
+			hs.all = append(hs.all, b)
+			if b.Weight() > hs.allMaxWeight {
+				hs.allMaxWeight = b.Weight()
+			}
+		}
+		healthy += len(t.healthy)
+	}
+	panicking := len(hs.all) > 0 && float64(healthy*100) < hs.panicThreshold*float64(len(hs.all))
+	if panicking && !hs.panic {
+		hs.log.Warnw("loadbalancer: entering panic mode; ignoring health", "healthy", healthy, "total", len(hs.all))
+	} else if !panicking && hs.panic {
+		hs.log.Infow("loadbalancer: leaving panic mode", "healthy", healthy, "total", len(hs.all))
+	}
+	hs.panic = panicking
+
+	health := make([]float64, len(hs.tiers))
+	totalHealth := 0.0
+	for i, t := range hs.tiers {
+		health[i] = math.Min(1, hs.overprovisioning*float64(len(t.healthy))/float64(len(t.backends)))
+		totalHealth += health[i]
+	}
+	totalHealth = math.Min(1, totalHealth)
+
+	remaining := 1.0
+	for i, t := range hs.tiers {
+		load := 0.0
+		if totalHealth > 0 {
+			load = math.Min(remaining, health[i]/totalHealth)
+		}
+		remaining -= load
+		if len(hs.tiers) > 1 && math.Abs(load-t.load) >= 0.005 {
+			hs.log.Infow("loadbalancer: priority load changed", "priority", t.priority, "load_percent", int(load*100+0.5))
+		}
+		t.load = load
+	}
+}
+
+// pick chooses a tier in proportion to its load, returning its
+// healthy backends and their largest weight, or no backends if
+// there aren't any healthy ones. In panic mode it returns all
+// of the backends instead.
+func (hs *hostSet) pick() ([]*backend.Backend, uint32) {
+	if hs.panic {
+		return hs.all, hs.allMaxWeight
+	}
+	r := rand.Float64()
+	var chosen int = -1
+	for i, t := range hs.tiers {
+		if t.load == 0 {
+			continue
+		}
+		chosen = i
+		r -= t.load
+		if r < 0 {
+			break
+		}
+	}
+	if chosen < 0 {
+		return nil, 0
+	}
+	if chosen > 0 {
+		atomic.AddUint64(&hs.tiers[chosen].spillovers, 1)
+	}
+	t := hs.tiers[chosen]
+	return t.healthy, t.maxWeight
+}
+
+func (hs *hostSet) stats() []TierStats {
+	stats := make([]TierStats, 0, len(hs.tiers))
+	for _, t := range hs.tiers {
+		stats = append(stats, TierStats{
+			Priority:   t.priority,
+			Healthy:    len(t.healthy),
+			Total:      len(t.backends),
+			Load:       t.load,
+			Spillovers: atomic.LoadUint64(&t.spillovers),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,195 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/jmuia/tcp-proxy/health"
+)
+
+func TestPriorityTiers(t *testing.T) {
+	for _, lb := range []LoadBalancer{NewRandom(), NewP2C()} {
+		registry := backend.NewRegistry(health.HealthCheckConfig{})
+		defer registry.EvictAll()
+		var primary, dr []*backend.Backend
+		for i := 0; i < 5; i++ {
+			b, err := registry.AddEndpoint(backend.Endpoint{Addr: fmt.Sprintf("localhost:%d", 9000+i)})
+			check(t, err)
+			primary = append(primary, b)
+			lb.UpdateBackend(b)
+		}
+		for i := 0; i < 2; i++ {
+			b, err := registry.AddEndpoint(backend.Endpoint{Addr: fmt.Sprintf("localhost:%d", 9100+i), Priority: 1})
+			check(t, err)
+			dr = append(dr, b)
+			lb.UpdateBackend(b)
+		}
+
+		// The DR tier gets nothing while the primary tier is healthy...
+		assertTierLoads(t, lb, 1, 0)
+		assertShare(t, lb, dr, 0)
+
+		// ...some traffic once less than 1/1.4 of the primary tier is...
+		for _, b := range primary[:2] {
+			b.SetState(backend.UNHEALTHY)
+			lb.UpdateBackend(b)
+		}
+		assertTierLoads(t, lb, 0.84, 0.16)
+		assertShare(t, lb, dr, 0.16)
+		if tiers := lb.Tiers(); tiers[0].Healthy != 3 || tiers[0].Total != 5 || tiers[1].Spillovers == 0 {
+			t.Errorf("unexpected tiers %+v", tiers)
+		}
+
+		// ...and everything once the primary tier is down.
+		for _, b := range primary {
+			b.SetState(backend.UNHEALTHY)
+			lb.UpdateBackend(b)
+		}
+		assertTierLoads(t, lb, 0, 1)
+		assertShare(t, lb, dr, 1)
+
+		// Removed backends leave their tier.
+		for _, b := range primary {
+			registry.Remove(b.Addr())
+			lb.UpdateBackend(b)
+		}
+		if tiers := lb.Tiers(); len(tiers) != 1 || tiers[0].Priority != 1 {
+			t.Errorf("expected only the DR tier to remain, got %+v", tiers)
+		}
+	}
+}
+
+func TestOverprovisioning(t *testing.T) {
+	lb := NewRandom()
+	lb.SetOverprovisioning(1)
+	registry := backend.NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	for _, e := range []backend.Endpoint{{Addr: "localhost:9000"}, {Addr: "localhost:9001"}, {Addr: "localhost:9100", Priority: 1}} {
+		b, err := registry.AddEndpoint(e)
+		check(t, err)
+		lb.UpdateBackend(b)
+	}
+	b := registry.Get("localhost:9000")
+	b.SetState(backend.UNHEALTHY)
+	lb.UpdateBackend(b)
+	assertTierLoads(t, lb, 0.5, 0.5)
+}
+
+func assertTierLoads(t *testing.T, lb LoadBalancer, loads ...float64) {
+	t.Helper()
+	tiers := lb.Tiers()
+	if len(tiers) != len(loads) {
+		t.Fatalf("expected %d tiers, got %+v", len(loads), tiers)
+	}
+	for i, load := range loads {
+		if math.Abs(tiers[i].Load-load) > 1e-9 {
+			t.Errorf("expected priority %d to get %v of connections, got %v", tiers[i].Priority, load, tiers[i].Load)
+		}
+	}
+}
+
+// assertShare checks the share of connections sent to backends.
+func assertShare(t *testing.T, lb LoadBalancer, backends []*backend.Backend, share float64) {
+	t.Helper()
+	const picks = 10000
+	hits := 0
+	for i := 0; i < picks; i++ {
+		b, err := lb.NextBackend(nil)
+		check(t, err)
+		for _, want := range backends {
+			if b == want {
+				hits++
+			}
+		}
+	}
+	if got := float64(hits) / picks; math.Abs(got-share) > 0.03 {
+		t.Errorf("expected %v of connections, got %v", share, got)
+	}
+}
+
+func check(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPanicThreshold(t *testing.T) {
+	random := NewRandom()
+	p2c := NewP2C()
+	random.SetPanicThreshold(50)
+	p2c.SetPanicThreshold(50)
+
+	for _, lb := range []LoadBalancer{random, p2c} {
+		registry := backend.NewRegistry(health.HealthCheckConfig{})
+		defer registry.EvictAll()
+		var backends []*backend.Backend
+		for i := 0; i < 4; i++ {
+			b, err := registry.Add(fmt.Sprintf("localhost:%d", 9000+i))
+			check(t, err)
+			backends = append(backends, b)
+			lb.UpdateBackend(b)
+		}
+
+		// Half the backends being healthy is enough...
+		for _, b := range backends[:2] {
+			b.SetState(backend.UNHEALTHY)
+			lb.UpdateBackend(b)
+		}
+		if lb.Panic() {
+			t.Errorf("expected %T not to panic with half its backends healthy", lb)
+		}
+		assertShare(t, lb, backends[:2], 0)
+
+		// ...but with fewer, every backend is sent connections...
+		backends[2].SetState(backend.UNHEALTHY)
+		lb.UpdateBackend(backends[2])
+		if !lb.Panic() {
+			t.Errorf("expected %T to panic with a quarter of its backends healthy", lb)
+		}
+		assertShare(t, lb, backends[:3], 0.75)
+
+		// ...even if none are healthy...
+		backends[3].SetState(backend.UNHEALTHY)
+		lb.UpdateBackend(backends[3])
+		assertShare(t, lb, backends, 1)
+
+		// ...until enough recover.
+		for _, b := range backends[:2] {
+			b.SetState(backend.HEALTHY)
+			lb.UpdateBackend(b)
+		}
+		if lb.Panic() {
+			t.Errorf("expected %T to leave panic mode", lb)
+		}
+		assertShare(t, lb, backends[:2], 1)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	for _, lb := range []LoadBalancer{NewRandom(), NewP2C()} {
+		registry := backend.NewRegistry(health.HealthCheckConfig{})
+		defer registry.EvictAll()
+		heavy, err := registry.AddEndpoint(backend.Endpoint{Addr: "localhost:9000", Weight: 3})
+		check(t, err)
+		light, err := registry.AddEndpoint(backend.Endpoint{Addr: "localhost:9001"})
+		check(t, err)
+		lb.UpdateBackend(heavy)
+		lb.UpdateBackend(light)
+
+		if _, ok := lb.(*Random); ok {
+			assertShare(t, lb, []*backend.Backend{heavy}, 0.75)
+			continue
+		}
+		// P2C evens out the load per unit of weight.
+		for i := 0; i < 400; i++ {
+			b, err := lb.NextBackend(nil)
+			check(t, err)
+			b.IncrActiveConns()
+		}
+		if ratio := float64(heavy.ActiveConns()) / float64(light.ActiveConns()); math.Abs(ratio-3) > 0.2 {
+			t.Errorf("expected P2C to send 3 times the connections to the heavier backend, got %v", ratio)
+		}
+	}
+}
@@ -4,9 +4,33 @@ import (
 	"net"
 
 	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/pkg/errors"
 )
 
+var ErrNoHealthyBackends = errors.New("loadbalancer: no healthy backends available")
+
+// ErrSaturated is returned when every healthy
+// backend is at its connection limits.
+var ErrSaturated = errors.New("loadbalancer: all backends are at their connection limits")
+
 type LoadBalancer interface {
 	NextBackend(c net.Conn) (*backend.Backend, error)
 	UpdateBackend(s *backend.Backend)
+	// Tiers describes the backends' priority tiers,
+	// from the highest priority to the lowest.
+	Tiers() []TierStats
+	// Panic reports whether too few backends are healthy
+	// for health to be taken into account.
+	Panic() bool
+}
+
+// available returns the backends that are below their limits.
+func available(backends []*backend.Backend) []*backend.Backend {
+	var avail []*backend.Backend
+	for _, b := range backends {
+		if b.Available() {
+			avail = append(avail, b)
+		}
+	}
+	return avail
 }
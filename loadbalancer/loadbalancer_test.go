@@ -2,6 +2,9 @@ package loadbalancer
 
 import (
 	"testing"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/jmuia/tcp-proxy/health"
 )
 
 func TestNoHealthyBackendsReturnsError(t *testing.T) {
@@ -27,3 +30,34 @@ func TestNoHealthyBackendsReturnsError(t *testing.T) {
 		t.Errorf("expected error '%s' when Random loadbalancer has no healthy backends, got '%v'", expected, err.Error())
 	}
 }
+
+func TestSkipsSaturatedBackends(t *testing.T) {
+	registry := backend.NewRegistry(health.HealthCheckConfig{})
+	defer registry.EvictAll()
+	registry.SetLimits(backend.Limits{MaxConns: 1})
+	full, _ := registry.Add("localhost:12345")
+	free, _ := registry.Add("localhost:54321")
+	full.BeginDial()
+	full.EndDial(true)
+
+	for _, lb := range []LoadBalancer{NewP2C(), NewRandom()} {
+		lb.UpdateBackend(full)
+		lb.UpdateBackend(free)
+		for i := 0; i < 20; i++ {
+			b, err := lb.NextBackend(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b != free {
+				t.Fatalf("expected %T to skip saturated backend, chose %s", lb, b.Addr())
+			}
+		}
+
+		free.BeginDial()
+		free.EndDial(true)
+		if _, err := lb.NextBackend(nil); err != ErrSaturated {
+			t.Errorf("expected %T to be saturated, got %v", lb, err)
+		}
+		free.DecrActiveConns()
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"net"
 
 	"github.com/jmuia/tcp-proxy/backend"
-	"github.com/pkg/errors"
+	logger "github.com/jmuia/tcp-proxy/logging"
 )
 
 /**
@@ -25,33 +25,81 @@ func NewP2C() *P2C {
 	return &P2C{NewRandom()}
 }
 
+// SetSlowStart ramps up traffic to backends that become healthy.
+func (lb *P2C) SetSlowStart(s SlowStart) {
+	lb.random.SetSlowStart(s)
+}
+
+// SetLogger is like Random's SetLogger.
+func (lb *P2C) SetLogger(log *logger.Logger) {
+	lb.random.SetLogger(log)
+}
+
+// SetOverprovisioning is like Random's SetOverprovisioning.
+func (lb *P2C) SetOverprovisioning(factor float64) {
+	lb.random.SetOverprovisioning(factor)
+}
+
+// SetPanicThreshold is like Random's SetPanicThreshold.
+func (lb *P2C) SetPanicThreshold(percent float64) {
+	lb.random.SetPanicThreshold(percent)
+}
+
 func (lb *P2C) UpdateBackend(s *backend.Backend) {
 	lb.random.UpdateBackend(s)
 }
 
+// Tiers describes the load balancer's priority tiers.
+func (lb *P2C) Tiers() []TierStats {
+	return lb.random.Tiers()
+}
+
+// Panic reports whether the load balancer is ignoring health
+// because too few backends are healthy.
+func (lb *P2C) Panic() bool {
+	return lb.random.Panic()
+}
+
 func (lb *P2C) NextBackend(c net.Conn) (*backend.Backend, error) {
 	lb.random.lock.RLock()
 	defer lb.random.lock.RUnlock()
 
-	switch len(lb.random.backendList) {
+	backends, _ := lb.random.hosts.pick()
+	if len(backends) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+	for _, b := range backends {
+		if !b.Available() {
+			// Choose among the backends that aren't at their limits.
+			backends = available(backends)
+			break
+		}
+	}
+
+	switch len(backends) {
 	case 0:
-		return nil, errors.New("loadbalancer: no healthy backends available")
+		return nil, ErrSaturated
 	case 1:
-		return lb.random.backendList[0], nil
+		return backends[0], nil
 	}
 
 	for {
-		choice1 := rand.Intn(len(lb.random.backendList))
-		choice2 := rand.Intn(len(lb.random.backendList))
+		choice1 := rand.Intn(len(backends))
+		choice2 := rand.Intn(len(backends))
 
 		if choice1 == choice2 {
 			continue
 		}
 
-		srv1 := lb.random.backendList[choice1]
-		srv2 := lb.random.backendList[choice2]
+		srv1 := backends[choice1]
+		srv2 := backends[choice2]
 
-		if srv1.ActiveConns() > srv2.ActiveConns() {
+		// Backends with lower weights, or ramping up
+		// under slow start, look busier than they are.
+		slowStart := lb.random.slowStart
+		load1 := float64(srv1.ActiveConns()+1) / (slowStart.Weight(srv1) * float64(srv1.Weight()))
+		load2 := float64(srv2.ActiveConns()+1) / (slowStart.Weight(srv2) * float64(srv2.Weight()))
+		if load1 > load2 {
 			return srv2, nil
 		}
 		return srv1, nil
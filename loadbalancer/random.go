@@ -7,60 +7,107 @@ import (
 
 	"github.com/jmuia/tcp-proxy/backend"
 	logger "github.com/jmuia/tcp-proxy/logging"
-	"github.com/pkg/errors"
 )
 
+// How many times a backend is chosen before one with a lower
+// weight, or ramping up under slow start, is accepted regardless.
+const maxWeightedPicks = 16
+
 type Random struct {
-	lock        sync.RWMutex
-	backendList []*backend.Backend
-	backendMap  map[string]int
+	lock      sync.RWMutex
+	hosts     *hostSet
+	slowStart SlowStart
 }
 
 func NewRandom() *Random {
 	return &Random{
-		lock:        sync.RWMutex{},
-		backendList: make([]*backend.Backend, 0),
-		backendMap:  make(map[string]int),
+		lock:  sync.RWMutex{},
+		hosts: newHostSet(),
 	}
 }
 
+// SetLogger sets the logger that changes to the
+// backends and their priority tiers are logged to.
+func (lb *Random) SetLogger(log *logger.Logger) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	lb.hosts.log = log
+}
+
+// SetSlowStart ramps up traffic to backends that become healthy.
+func (lb *Random) SetSlowStart(s SlowStart) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	lb.slowStart = s
+}
+
+// SetOverprovisioning sets how much a priority tier's healthy
+// fraction is scaled up before its load spills to the next tier
+// (1.4 by default, so spilling starts once it drops below ~71%).
+func (lb *Random) SetOverprovisioning(factor float64) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	lb.hosts.overprovisioning = factor
+	lb.hosts.rebalance()
+}
+
+// SetPanicThreshold sets the percentage of backends that must be
+// healthy for health to be taken into account. Below it, every
+// registered backend is sent connections. 0 disables panic mode.
+func (lb *Random) SetPanicThreshold(percent float64) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	lb.hosts.panicThreshold = percent
+	lb.hosts.rebalance()
+}
+
 func (lb *Random) UpdateBackend(s *backend.Backend) {
 	lb.lock.Lock()
 	defer lb.lock.Unlock()
+	// UNHEALTHY, DRAINING and REMOVED backends aren't
+	// sent new connections, outside of panic mode.
+	lb.hosts.update(s)
+}
 
-	idx, exists := lb.backendMap[s.Addr()]
+// Tiers describes the load balancer's priority tiers.
+func (lb *Random) Tiers() []TierStats {
+	lb.lock.RLock()
+	defer lb.lock.RUnlock()
+	return lb.hosts.stats()
+}
 
-	switch s.State() {
-	case backend.UNHEALTHY:
-		if exists {
-			logger.Infof("loadbalancer: Removed %s as %s", s.Addr(), s.State().String())
-			lb.remove(idx)
-			delete(lb.backendMap, s.Addr())
-		}
-	case backend.HEALTHY:
-		if !exists {
-			logger.Infof("loadbalancer: Added %s as %s", s.Addr(), s.State().String())
-			lb.backendList = append(lb.backendList, s)
-			lb.backendMap[s.Addr()] = len(lb.backendList) - 1
-		}
-	}
+// Panic reports whether the load balancer is ignoring health
+// because too few backends are healthy.
+func (lb *Random) Panic() bool {
+	lb.lock.RLock()
+	defer lb.lock.RUnlock()
+	return lb.hosts.panic
 }
 
 func (lb *Random) NextBackend(c net.Conn) (*backend.Backend, error) {
 	lb.lock.RLock()
 	defer lb.lock.RUnlock()
-	switch len(lb.backendList) {
-	case 0:
-		return nil, errors.New("loadbalancer: no healthy backends available")
-	case 1:
-		return lb.backendList[0], nil
-	default:
-		return lb.backendList[rand.Intn(len(lb.backendList))], nil
+	backends, maxWeight := lb.hosts.pick()
+	if len(backends) == 0 {
+		return nil, ErrNoHealthyBackends
 	}
-}
 
-func (lb *Random) remove(index int) {
-	lb.backendList[index] = lb.backendList[len(lb.backendList)-1]
-	lb.backendList[len(lb.backendList)-1] = nil
-	lb.backendList = lb.backendList[:len(lb.backendList)-1]
+	// Backends are accepted in proportion to their weight,
+	// reduced while they ramp up under slow start.
+	var b *backend.Backend
+	for i := 0; i < maxWeightedPicks; i++ {
+		b = backends[rand.Intn(len(backends))]
+		if !b.Available() {
+			// Choose among the backends that aren't at their limits.
+			backends = available(backends)
+			if len(backends) == 0 {
+				return nil, ErrSaturated
+			}
+			b = backends[rand.Intn(len(backends))]
+		}
+		if lb.slowStart.accept(b, maxWeight) {
+			break
+		}
+	}
+	return b, nil
 }
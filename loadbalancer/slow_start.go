@@ -0,0 +1,59 @@
+package loadbalancer
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+)
+
+const (
+	defaultSlowStartMinWeight  = 0.1
+	defaultSlowStartAggression = 1.0
+)
+
+// SlowStart ramps up the share of connections sent to a backend
+// over Window after it becomes healthy again. Its weight starts
+// at MinWeight (0.1 by default) of a full share and grows along
+// (elapsed/Window)^(1/Aggression): linearly with an Aggression
+// of 1 (the default), and faster at first with a larger one.
+type SlowStart struct {
+	Window     time.Duration
+	MinWeight  float64
+	Aggression float64
+}
+
+// Weight returns the backend's share of connections
+// relative to a backend that isn't ramping up, in (0, 1].
+func (s SlowStart) Weight(b *backend.Backend) float64 {
+	if s.Window <= 0 {
+		return 1
+	}
+	since := b.HealthySince()
+	if since.IsZero() {
+		return 1
+	}
+	elapsed := time.Since(since)
+	if elapsed >= s.Window {
+		return 1
+	}
+
+	minWeight := s.MinWeight
+	if minWeight <= 0 || minWeight > 1 {
+		minWeight = defaultSlowStartMinWeight
+	}
+	aggression := s.Aggression
+	if aggression <= 0 {
+		aggression = defaultSlowStartAggression
+	}
+	progress := float64(elapsed) / float64(s.Window)
+	return math.Max(minWeight, math.Pow(progress, 1/aggression))
+}
+
+// accept randomly accepts a choice of b in proportion to its
+// weight, relative to maxWeight, and its slow start weight.
+func (s SlowStart) accept(b *backend.Backend, maxWeight uint32) bool {
+	weight := s.Weight(b) * float64(b.Weight()) / float64(maxWeight)
+	return weight >= 1 || rand.Float64() < weight
+}
@@ -0,0 +1,82 @@
+package loadbalancer
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+)
+
+func TestSlowStartWeight(t *testing.T) {
+	b := backend.NewBackend("localhost:12345", backend.HEALTHY)
+	s := SlowStart{Window: 200 * time.Millisecond, MinWeight: 0.25}
+
+	// Backends that have been healthy since they were registered
+	// get a full share.
+	if w := s.Weight(b); w != 1 {
+		t.Errorf("expected weight of 1 for a new backend, was %v", w)
+	}
+
+	// A backend that just became healthy starts at the minimum...
+	b.SetState(backend.UNHEALTHY)
+	b.SetState(backend.HEALTHY)
+	if w := s.Weight(b); w != 0.25 {
+		t.Errorf("expected weight of 0.25 after becoming healthy, was %v", w)
+	}
+
+	// ...ramps up linearly...
+	time.Sleep(100 * time.Millisecond)
+	if w := s.Weight(b); math.Abs(w-0.5) > 0.15 {
+		t.Errorf("expected weight near 0.5 halfway through slow start, was %v", w)
+	}
+
+	// ...or faster, with a more aggressive curve...
+	aggressive := SlowStart{Window: s.Window, MinWeight: s.MinWeight, Aggression: 4}
+	if w := aggressive.Weight(b); w < 0.75 {
+		t.Errorf("expected aggressive weight over 0.75 halfway through slow start, was %v", w)
+	}
+
+	// ...and ends with a full share.
+	time.Sleep(100 * time.Millisecond)
+	if w := s.Weight(b); w != 1 {
+		t.Errorf("expected weight of 1 after slow start, was %v", w)
+	}
+}
+
+func TestSlowStartShare(t *testing.T) {
+	slowStart := SlowStart{Window: 1 * time.Minute, MinWeight: 0.1}
+	random := NewRandom()
+	p2c := NewP2C()
+	random.SetSlowStart(slowStart)
+	p2c.SetSlowStart(slowStart)
+
+	for _, lb := range []LoadBalancer{random, p2c} {
+		established := backend.NewBackend("localhost:12345", backend.HEALTHY)
+		ramping := backend.NewBackend("localhost:54321", backend.UNHEALTHY)
+		ramping.SetState(backend.HEALTHY)
+
+		lb.UpdateBackend(established)
+		lb.UpdateBackend(ramping)
+
+		picks := 0
+		for i := 0; i < 1000; i++ {
+			b, err := lb.NextBackend(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b == ramping {
+				picks++
+			}
+			// P2C balances by active connections.
+			b.IncrActiveConns()
+		}
+		// A weight of 0.1 is about 9% of connections.
+		if picks > 200 {
+			t.Errorf("expected %T to send a small share to a ramping backend, sent %d/1000", lb, picks)
+		}
+		if picks == 0 {
+			t.Errorf("expected %T to send some connections to a ramping backend", lb)
+		}
+	}
+}
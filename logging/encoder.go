@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Field is a key/value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Entry is a single log record.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// Encoder appends an entry to buf. Encoders
+// for streams end each entry with a newline.
+type Encoder interface {
+	Encode(buf []byte, e *Entry) []byte
+}
+
+func NewEncoder(format Format) Encoder {
+	if format == JSON_FORMAT {
+		return JSONEncoder{}
+	}
+	return TextEncoder{}
+}
+
+// TextEncoder writes entries as a timestamp, level and message
+// followed by logfmt-style key=value fields.
+type TextEncoder struct{}
+
+func (TextEncoder) Encode(buf []byte, e *Entry) []byte {
+	buf = e.Time.AppendFormat(buf, timeFormat)
+	buf = append(buf, ' ')
+	buf = append(buf, e.Level.String()...)
+	buf = append(buf, ' ')
+	buf = appendText(buf, e)
+	return append(buf, '\n')
+}
+
+// appendText appends the entry's message and fields.
+func appendText(buf []byte, e *Entry) []byte {
+	buf = append(buf, e.Message...)
+	for _, f := range e.Fields {
+		buf = append(buf, ' ')
+		buf = append(buf, f.Key...)
+		buf = append(buf, '=')
+		buf = appendTextValue(buf, text(f.Value))
+	}
+	return buf
+}
+
+func appendTextValue(buf []byte, s string) []byte {
+	if s == "" || strings.ContainsAny(s, " =\"\\") || strings.IndexFunc(s, needsQuote) >= 0 {
+		return strconv.AppendQuote(buf, s)
+	}
+	return append(buf, s...)
+}
+
+func needsQuote(r rune) bool {
+	return r < ' ' || r == utf8.RuneError
+}
+
+// JSONEncoder writes entries as one JSON object per line,
+// with fields as top-level keys.
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(buf []byte, e *Entry) []byte {
+	buf = append(buf, `{"time":"`...)
+	buf = e.Time.AppendFormat(buf, timeFormat)
+	buf = append(buf, `","level":"`...)
+	buf = append(buf, e.Level.String()...)
+	buf = append(buf, `",`...)
+	buf = appendJSONFields(buf, e)
+	return append(buf, "}\n"...)
+}
+
+// appendJSONFields appends the entry's message and
+// fields as the members of a JSON object.
+func appendJSONFields(buf []byte, e *Entry) []byte {
+	buf = append(buf, `"msg":`...)
+	buf = appendJSONString(buf, e.Message)
+	for _, f := range e.Fields {
+		buf = append(buf, ',')
+		buf = appendJSONString(buf, f.Key)
+		buf = append(buf, ':')
+		buf = appendJSONValue(buf, f.Value)
+	}
+	return buf
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	b, _ := json.Marshal(s)
+	return append(buf, b...)
+}
+
+func appendJSONValue(buf []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case error, fmt.Stringer:
+		return appendJSONString(buf, text(v))
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return appendJSONString(buf, text(v))
+	}
+	return append(buf, b...)
+}
+
+// text formats a field's value as a string.
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,201 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const backupTimeFormat = "20060102-150405.000"
+
+// FileConfig is a log file that's rotated once it's larger than
+// MaxSize bytes or older than MaxAge, if set. Rotated files are
+// renamed with a timestamp suffix and only the newest MaxBackups
+// are kept, or all of them if 0.
+type FileConfig struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+}
+
+// File is a log file that rotates itself, and that can be reopened
+// after another tool, such as logrotate, has moved it aside.
+type File struct {
+	cfg    FileConfig
+	lock   sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	closed bool
+}
+
+// Files that are open, to be reopened by ReopenFiles.
+var (
+	filesLock sync.Mutex
+	files     = make(map[*File]struct{})
+)
+
+func OpenFile(cfg FileConfig) (*File, error) {
+	f := &File{cfg: cfg}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	filesLock.Lock()
+	files[f] = struct{}{}
+	filesLock.Unlock()
+	return f, nil
+}
+
+// ReopenFiles reopens every open File at its path,
+// returning the first error.
+func ReopenFiles() error {
+	filesLock.Lock()
+	defer filesLock.Unlock()
+	var err error
+	for f := range files {
+		if reopenErr := f.Reopen(); err == nil {
+			err = reopenErr
+		}
+	}
+	return err
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to open log file")
+	}
+	f.f, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p to the file, first rotating it if it's too
+// large or old. If rotating fails, the current file is kept.
+func (f *File) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.ensureOpen(); err != nil {
+		return 0, err
+	}
+	if f.due(len(p)) {
+		if err := f.rotate(); err != nil && f.f == nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// ensureOpen opens the file again if rotating or
+// reopening it failed to, unless it's been closed.
+func (f *File) ensureOpen() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.f == nil {
+		return f.open()
+	}
+	return nil
+}
+
+// due reports whether the file should be rotated
+// before n more bytes are written to it.
+func (f *File) due(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.cfg.MaxSize > 0 && f.size+int64(n) > f.cfg.MaxSize {
+		return true
+	}
+	return f.cfg.MaxAge > 0 && time.Since(f.opened) >= f.cfg.MaxAge
+}
+
+func (f *File) rotate() error {
+	backup := f.cfg.Path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(f.cfg.Path, backup); err != nil {
+		return errors.Wrap(err, "failed to rotate log file")
+	}
+	f.f.Close()
+	f.f = nil
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.prune()
+}
+
+// prune removes all but the newest MaxBackups rotated files.
+func (f *File) prune() error {
+	if f.cfg.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > f.cfg.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return errors.Wrap(err, "failed to remove old log file")
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the file's rotated files, oldest first.
+func (f *File) backups() ([]string, error) {
+	matches, err := filepath.Glob(f.cfg.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	backups := matches[:0]
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, f.cfg.Path+".")
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// Reopen closes the file and opens the one now at its path.
+func (f *File) Reopen() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.f != nil {
+		f.f.Close()
+		f.f = nil
+	}
+	return f.open()
+}
+
+func (f *File) Close() error {
+	filesLock.Lock()
+	delete(files, f)
+	filesLock.Unlock()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
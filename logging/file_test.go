@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileRotatesBySize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.log")
+
+	f, err := OpenFile(FileConfig{Path: path, MaxSize: 10, MaxBackups: 2})
+	check(t, err)
+	defer f.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		check(t, err)
+		// Backups are named to the millisecond.
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if content := readFile(t, path); content != "fourth\n" {
+		t.Errorf("expected the newest entry in the log file, got %q", content)
+	}
+	backups, err := f.backups()
+	check(t, err)
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups to be kept, got %v", backups)
+	}
+	if readFile(t, backups[0]) != "second\n" || readFile(t, backups[1]) != "third\n" {
+		t.Errorf("expected the newest backups to be kept, got %v", backups)
+	}
+}
+
+func TestFileRotatesByAge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.log")
+
+	f, err := OpenFile(FileConfig{Path: path, MaxAge: 20 * time.Millisecond})
+	check(t, err)
+	defer f.Close()
+	f.Write([]byte("old\n"))
+	f.Write([]byte("new\n"))
+	if content := readFile(t, path); content != "old\nnew\n" {
+		t.Errorf("expected the file not to rotate yet, got %q", content)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	f.Write([]byte("newer\n"))
+	if content := readFile(t, path); content != "newer\n" {
+		t.Errorf("expected the file to rotate, got %q", content)
+	}
+}
+
+func TestReopenFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.log")
+
+	f, err := OpenFile(FileConfig{Path: path})
+	check(t, err)
+	defer f.Close()
+	log := New(f, TEXT_FORMAT, INFO_LEVEL)
+	log.Info("before")
+
+	// Moved aside, as logrotate would.
+	check(t, os.Rename(path, path+".1"))
+	log.Info("moved")
+	check(t, ReopenFiles())
+	log.Info("after")
+
+	if content := readFile(t, path+".1"); !strings.Contains(content, "before") || !strings.Contains(content, "moved") {
+		t.Errorf("expected entries before reopening in the moved file, got %q", content)
+	}
+	if content := readFile(t, path); !strings.HasSuffix(strings.TrimSpace(content), " INFO after") || strings.Contains(content, "moved") {
+		t.Errorf("expected only entries after reopening in the new file, got %q", content)
+	}
+
+	check(t, f.Close())
+	if _, err := f.Write([]byte("closed\n")); err == nil {
+		t.Error("expected writing a closed file to fail")
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "logging")
+	check(t, err)
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	check(t, err)
+	return string(b)
+}
+
+func check(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
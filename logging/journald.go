@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// JournaldEncoder writes entries in the systemd journal's native
+// protocol. Each field is a journal field of the same name in
+// upper case, prefixed with F_ if that would clash with one of
+// the journal's own fields. Entries must fit in one datagram to
+// the journal.
+type JournaldEncoder struct {
+	Tag string
+}
+
+func (enc JournaldEncoder) Encode(buf []byte, e *Entry) []byte {
+	buf = appendJournalField(buf, "MESSAGE", e.Message)
+	buf = appendJournalField(buf, "PRIORITY", strconv.Itoa(e.Level.severity()))
+	if enc.Tag != "" {
+		buf = appendJournalField(buf, "SYSLOG_IDENTIFIER", enc.Tag)
+	}
+	for _, f := range e.Fields {
+		buf = appendJournalField(buf, journalFieldName(f.Key), text(f.Value))
+	}
+	return buf
+}
+
+// appendJournalField appends KEY=value, or, for values with
+// newlines, KEY then the value prefixed by its length.
+func appendJournalField(buf []byte, key, value string) []byte {
+	buf = append(buf, key...)
+	if !strings.Contains(value, "\n") {
+		buf = append(buf, '=')
+		buf = append(buf, value...)
+		return append(buf, '\n')
+	}
+	buf = append(buf, '\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf = append(buf, size[:]...)
+	buf = append(buf, value...)
+	return append(buf, '\n')
+}
+
+// reservedJournalFields are the journal's well-known fields,
+// which user fields mustn't override.
+var reservedJournalFields = map[string]bool{
+	"MESSAGE":            true,
+	"MESSAGE_ID":         true,
+	"PRIORITY":           true,
+	"CODE_FILE":          true,
+	"CODE_LINE":          true,
+	"CODE_FUNC":          true,
+	"ERRNO":              true,
+	"INVOCATION_ID":      true,
+	"USER_INVOCATION_ID": true,
+	"SYSLOG_FACILITY":    true,
+	"SYSLOG_IDENTIFIER":  true,
+	"SYSLOG_PID":         true,
+	"SYSLOG_TIMESTAMP":   true,
+	"SYSLOG_RAW":         true,
+	"DOCUMENTATION":      true,
+	"TID":                true,
+}
+
+// journalFieldName makes key a valid journal field name: upper
+// case letters, digits and underscores, starting with a letter.
+// Names of the journal's own fields, and trusted fields starting
+// with an underscore, are prefixed with F_.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	if name == "" || name[0] < 'A' || name[0] > 'Z' || reservedJournalFields[name] {
+		name = "F_" + name
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
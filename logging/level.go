@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type Level uint32
+
+const (
+	DEBUG_LEVEL Level = 1
+	INFO_LEVEL  Level = 2
+	WARN_LEVEL  Level = 3
+	ERROR_LEVEL Level = 4
+)
+
+func (l Level) String() string {
+	strings := [...]string{"DEBUG", "INFO", "WARN", "ERROR"}
+	switch l {
+	case DEBUG_LEVEL, INFO_LEVEL, WARN_LEVEL, ERROR_LEVEL:
+		return strings[l-1]
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch s {
+	case "DEBUG":
+		return DEBUG_LEVEL, nil
+	case "INFO":
+		return INFO_LEVEL, nil
+	case "WARN":
+		return WARN_LEVEL, nil
+	case "ERROR":
+		return ERROR_LEVEL, nil
+	default:
+		return 0, errors.New(fmt.Sprintf("invalid log level %s", s))
+	}
+}
+
+type Format uint32
+
+const (
+	TEXT_FORMAT Format = 1
+	JSON_FORMAT Format = 2
+)
+
+func (f Format) String() string {
+	strings := [...]string{"TEXT", "JSON"}
+	switch f {
+	case TEXT_FORMAT, JSON_FORMAT:
+		return strings[f-1]
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "TEXT":
+		return TEXT_FORMAT, nil
+	case "JSON":
+		return JSON_FORMAT, nil
+	default:
+		return 0, errors.New(fmt.Sprintf("invalid log format %s", s))
+	}
+}
+
+// severity is the level's syslog severity.
+func (l Level) severity() int {
+	switch l {
+	case DEBUG_LEVEL:
+		return 7
+	case INFO_LEVEL:
+		return 6
+	case WARN_LEVEL:
+		return 4
+	default:
+		return 3
+	}
+}
+
+type Sink uint32
+
+const (
+	// DEFAULT_SINK writes to the log file if one
+	// is configured, otherwise to stderr.
+	DEFAULT_SINK  Sink = 0
+	STDERR_SINK   Sink = 1
+	FILE_SINK     Sink = 2
+	SYSLOG_SINK   Sink = 3
+	JOURNALD_SINK Sink = 4
+)
+
+func (s Sink) String() string {
+	strings := [...]string{"STDERR", "FILE", "SYSLOG", "JOURNALD"}
+	switch s {
+	case STDERR_SINK, FILE_SINK, SYSLOG_SINK, JOURNALD_SINK:
+		return strings[s-1]
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func ParseSink(s string) (Sink, error) {
+	switch s {
+	case "STDERR":
+		return STDERR_SINK, nil
+	case "FILE":
+		return FILE_SINK, nil
+	case "SYSLOG":
+		return SYSLOG_SINK, nil
+	case "JOURNALD":
+		return JOURNALD_SINK, nil
+	default:
+		return 0, errors.New(fmt.Sprintf("invalid log sink %s", s))
+	}
+}
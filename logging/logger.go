@@ -1,22 +1,164 @@
+// Package logging is a leveled, structured logger. Entries carry
+// key/value fields and are written as text or JSON.
 package logging
 
 import (
-	"log"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func Error(v ...interface{}) {
-	log.Println(append([]interface{}{"ERROR"}, v...)...)
+// Config is the application log's configuration.
+// The log is written to Sink, or if that's unset, to File.Path if
+// it's set or else stderr. Tag names the application to syslog
+// and journald; journald ignores Format.
+type Config struct {
+	Level    Level
+	Format   Format
+	Sink     Sink
+	File     FileConfig
+	Syslog   SyslogConfig
+	Journald JournaldConfig
+	Tag      string
 }
 
-func Warn(v ...interface{}) {
-	log.Println(append([]interface{}{"WARN"}, v...)...)
+// Logger writes entries at or above its level, with its fields,
+// to its output. Loggers derived with With share their output.
+type Logger struct {
+	out    *output
+	fields []Field
 }
 
-func Info(v ...interface{}) {
-	log.Println(append([]interface{}{"INFO"}, v...)...)
+type output struct {
+	level uint32
+
+	lock sync.Mutex
+	w    io.Writer
+	enc  Encoder
+	buf  []byte
+}
+
+func New(w io.Writer, format Format, level Level) *Logger {
+	return &Logger{out: &output{level: uint32(level), w: w, enc: NewEncoder(format)}}
+}
+
+var std = New(os.Stderr, TEXT_FORMAT, INFO_LEVEL)
+
+// Configure changes the application log's level, format, and sink,
+// including that of loggers already derived from it.
+func Configure(cfg Config) error {
+	w, enc, err := openSink(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Level != 0 {
+		SetLevel(cfg.Level)
+	}
+	std.out.lock.Lock()
+	defer std.out.lock.Unlock()
+	std.out.w = w
+	if enc != nil {
+		std.out.enc = enc
+	}
+	return nil
+}
+
+func SetLevel(level Level) {
+	atomic.StoreUint32(&std.out.level, uint32(level))
+}
+
+func SetOutput(w io.Writer) {
+	std.out.lock.Lock()
+	defer std.out.lock.Unlock()
+	std.out.w = w
 }
 
-func Infof(format string, v ...interface{}) {
-	format = "INFO " + format + "\n"
-	log.Printf(format, v...)
+// With returns a logger that adds the key/value pairs
+// in kv to every entry.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]Field, len(l.fields), len(l.fields)+(len(kv)+1)/2)
+	copy(fields, l.fields)
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var value interface{} = "MISSING"
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+	}
+	return &Logger{out: l.out, fields: fields}
 }
+
+// Enabled reports whether entries at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= Level(atomic.LoadUint32(&l.out.level))
+}
+
+func (l *Logger) Debug(v ...interface{})                 { l.log(DEBUG_LEVEL, v) }
+func (l *Logger) Debugf(format string, v ...interface{}) { l.logf(DEBUG_LEVEL, format, v) }
+func (l *Logger) Info(v ...interface{})                  { l.log(INFO_LEVEL, v) }
+func (l *Logger) Infof(format string, v ...interface{})  { l.logf(INFO_LEVEL, format, v) }
+func (l *Logger) Warn(v ...interface{})                  { l.log(WARN_LEVEL, v) }
+func (l *Logger) Warnf(format string, v ...interface{})  { l.logf(WARN_LEVEL, format, v) }
+func (l *Logger) Error(v ...interface{})                 { l.log(ERROR_LEVEL, v) }
+func (l *Logger) Errorf(format string, v ...interface{}) { l.logf(ERROR_LEVEL, format, v) }
+
+// Debugw and the like write msg with the key/value pairs in kv
+// added to the logger's fields.
+func (l *Logger) Debugw(msg string, kv ...interface{}) { l.logw(DEBUG_LEVEL, msg, kv) }
+func (l *Logger) Infow(msg string, kv ...interface{})  { l.logw(INFO_LEVEL, msg, kv) }
+func (l *Logger) Warnw(msg string, kv ...interface{})  { l.logw(WARN_LEVEL, msg, kv) }
+func (l *Logger) Errorw(msg string, kv ...interface{}) { l.logw(ERROR_LEVEL, msg, kv) }
+
+func (l *Logger) log(level Level, v []interface{}) {
+	if l.Enabled(level) {
+		l.write(level, strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+	}
+}
+
+func (l *Logger) logf(level Level, format string, v []interface{}) {
+	if l.Enabled(level) {
+		l.write(level, fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) logw(level Level, msg string, kv []interface{}) {
+	if l.Enabled(level) {
+		l.With(kv...).write(level, msg)
+	}
+}
+
+func (l *Logger) write(level Level, msg string) {
+	e := Entry{Time: time.Now(), Level: level, Message: msg, Fields: l.fields}
+	l.out.lock.Lock()
+	defer l.out.lock.Unlock()
+	l.out.buf = l.out.enc.Encode(l.out.buf[:0], &e)
+	l.out.w.Write(l.out.buf)
+}
+
+// With returns a logger that adds the key/value pairs in kv
+// to every entry written to the application log.
+func With(kv ...interface{}) *Logger {
+	return std.With(kv...)
+}
+
+func Enabled(level Level) bool {
+	return std.Enabled(level)
+}
+
+func Debug(v ...interface{})                 { std.log(DEBUG_LEVEL, v) }
+func Debugf(format string, v ...interface{}) { std.logf(DEBUG_LEVEL, format, v) }
+func Info(v ...interface{})                  { std.log(INFO_LEVEL, v) }
+func Infof(format string, v ...interface{})  { std.logf(INFO_LEVEL, format, v) }
+func Warn(v ...interface{})                  { std.log(WARN_LEVEL, v) }
+func Warnf(format string, v ...interface{})  { std.logf(WARN_LEVEL, format, v) }
+func Error(v ...interface{})                 { std.log(ERROR_LEVEL, v) }
+func Errorf(format string, v ...interface{}) { std.logf(ERROR_LEVEL, format, v) }
+func Debugw(msg string, kv ...interface{})   { std.logw(DEBUG_LEVEL, msg, kv) }
+func Infow(msg string, kv ...interface{})    { std.logw(INFO_LEVEL, msg, kv) }
+func Warnw(msg string, kv ...interface{})    { std.logw(WARN_LEVEL, msg, kv) }
+func Errorw(msg string, kv ...interface{})   { std.logw(ERROR_LEVEL, msg, kv) }
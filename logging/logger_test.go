@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(&buf, TEXT_FORMAT, WARN_LEVEL)
+	log.Debug("debug")
+	log.Infof("info %d", 1)
+	log.Warn("warn", 2)
+	log.Errorw("error", "n", 3)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries at WARN or above, got %q", lines)
+	}
+	if !strings.HasSuffix(lines[0], " WARN warn 2") {
+		t.Errorf("unexpected entry %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], " ERROR error n=3") {
+		t.Errorf("unexpected entry %q", lines[1])
+	}
+	if log.Enabled(INFO_LEVEL) || !log.Enabled(ERROR_LEVEL) {
+		t.Error("expected only WARN and above to be enabled")
+	}
+}
+
+func TestTextFields(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(&buf, TEXT_FORMAT, DEBUG_LEVEL).With("frontend", "default")
+	log.Infow("closed", "reason", "idle timeout", "bytes", 10, "error", errors.New(`read "x"`), "empty", "")
+
+	entry := strings.TrimSpace(buf.String())
+	expected := ` INFO closed frontend=default reason="idle timeout" bytes=10 error="read \"x\"" empty=""`
+	if !strings.HasSuffix(entry, expected) {
+		t.Errorf("expected entry ending %q, got %q", expected, entry)
+	}
+	if _, err := time.Parse(timeFormat, strings.Fields(entry)[0]); err != nil {
+		t.Errorf("expected entry to start with a timestamp: %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(&buf, JSON_FORMAT, INFO_LEVEL).With("frontend", "default")
+	log.With("pool", "api").Warnw("rejected \"client\"", "active", 3, "timeout", 2*time.Second, "error", errors.New("denied"))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON entry, got %q: %v", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"level":    "WARN",
+		"msg":      `rejected "client"`,
+		"frontend": "default",
+		"pool":     "api",
+		"active":   float64(3),
+		"timeout":  "2s",
+		"error":    "denied",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, entry[key])
+		}
+	}
+	if _, ok := entry["time"]; !ok {
+		t.Error("expected entry to have a time")
+	}
+}
+
+func TestWithDoesNotShareFields(t *testing.T) {
+	var buf bytes.Buffer
+	parent := New(&buf, TEXT_FORMAT, INFO_LEVEL).With("a", 1)
+	parent.With("b", 2)
+	parent.With("c", 3).Info("x")
+	if entry := strings.TrimSpace(buf.String()); !strings.HasSuffix(entry, " x a=1 c=3") {
+		t.Errorf("unexpected entry %q", entry)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{DEBUG_LEVEL, INFO_LEVEL, WARN_LEVEL, ERROR_LEVEL} {
+		parsed, err := ParseLevel(level.String())
+		if err != nil || parsed != level {
+			t.Errorf("expected %s to parse, got %v, %v", level, parsed, err)
+		}
+	}
+	if _, err := ParseLevel("TRACE"); err == nil {
+		t.Error("expected TRACE to be invalid")
+	}
+}
@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultSyslogAddr     = "unix:/dev/log"
+	DefaultJournaldSocket = "/run/systemd/journal/socket"
+)
+
+// SyslogConfig is where syslog messages are sent. Addr is
+// udp:HOST:PORT, tcp:HOST:PORT, or unix:PATH for a datagram
+// socket. Facility is a syslog facility code; 0 is user (1).
+type SyslogConfig struct {
+	Addr     string
+	Facility int
+}
+
+type JournaldConfig struct {
+	Socket string
+}
+
+// sink is where cfg says to write the log: its Sink,
+// or else File.Path if it's set, or else stderr.
+func (cfg Config) sink() Sink {
+	if cfg.Sink != DEFAULT_SINK {
+		return cfg.Sink
+	}
+	if cfg.File.Path != "" {
+		return FILE_SINK
+	}
+	return STDERR_SINK
+}
+
+// openSink returns the writer and encoder for cfg's sink.
+// The encoder is nil if the current one should be kept.
+func openSink(cfg Config) (io.Writer, Encoder, error) {
+	var enc Encoder
+	if cfg.Format != 0 {
+		enc = NewEncoder(cfg.Format)
+	}
+	switch cfg.sink() {
+	case STDERR_SINK:
+		return os.Stderr, enc, nil
+	case FILE_SINK:
+		if cfg.File.Path == "" {
+			return nil, nil, errors.New("the FILE log sink requires a path")
+		}
+		f, err := OpenFile(cfg.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, enc, nil
+	case SYSLOG_SINK:
+		addr := cfg.Syslog.Addr
+		if addr == "" {
+			addr = DefaultSyslogAddr
+		}
+		network, addr, err := splitSyslogAddr(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, err := dialWriter(network, addr)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to connect to syslog")
+		}
+		return w, newSyslogEncoder(cfg), nil
+	case JOURNALD_SINK:
+		socket := cfg.Journald.Socket
+		if socket == "" {
+			socket = DefaultJournaldSocket
+		}
+		w, err := dialWriter("unixgram", socket)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to connect to journald")
+		}
+		return w, JournaldEncoder{Tag: cfg.Tag}, nil
+	default:
+		return nil, nil, errors.New(fmt.Sprintf("invalid log sink %s", cfg.Sink))
+	}
+}
+
+func splitSyslogAddr(addr string) (string, string, error) {
+	parts := strings.SplitN(addr, ":", 2)
+	if len(parts) == 2 {
+		switch parts[0] {
+		case "udp", "tcp":
+			return parts[0], parts[1], nil
+		case "unix":
+			return "unixgram", parts[1], nil
+		}
+	}
+	return "", "", errors.New(fmt.Sprintf("invalid syslog address %s", addr))
+}
+
+const writeTimeout = 1 * time.Second
+
+// netWriter writes each entry to a connection, dialing again
+// once if that fails, e.g. because the daemon restarted. On
+// TCP, entries are prefixed with their length (RFC 6587).
+// Writes are serialized by the logger.
+type netWriter struct {
+	network string
+	addr    string
+	conn    net.Conn
+	frame   []byte
+}
+
+func dialWriter(network, addr string) (*netWriter, error) {
+	w := &netWriter{network: network, addr: addr}
+	if err := w.dial(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *netWriter) dial() error {
+	conn, err := net.DialTimeout(w.network, w.addr, writeTimeout)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+func (w *netWriter) Write(p []byte) (int, error) {
+	msg := p
+	if w.network == "tcp" {
+		w.frame = strconv.AppendInt(w.frame[:0], int64(len(p)), 10)
+		w.frame = append(w.frame, ' ')
+		w.frame = append(w.frame, p...)
+		msg = w.frame
+	}
+	err := w.write(msg)
+	if err != nil {
+		err = w.write(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *netWriter) write(msg []byte) error {
+	if w.conn == nil {
+		if err := w.dial(); err != nil {
+			return err
+		}
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := w.conn.Write(msg); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	check(t, err)
+	defer conn.Close()
+
+	log := openLogger(t, Config{
+		Level:  DEBUG_LEVEL,
+		Format: TEXT_FORMAT,
+		Sink:   SYSLOG_SINK,
+		Syslog: SyslogConfig{Addr: "udp:" + conn.LocalAddr().String(), Facility: 16},
+		Tag:    "tcp-proxy",
+	})
+	log.Warnw("backend unhealthy", "backend", "localhost:9000")
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	check(t, err)
+	msg := string(buf[:n])
+
+	// local0 (16) * 8 + warning (4).
+	if !strings.HasPrefix(msg, "<132>1 ") {
+		t.Errorf("expected a local0.warning RFC 5424 message, got %q", msg)
+	}
+	expected := fmt.Sprintf(" tcp-proxy %d - - backend unhealthy backend=localhost:9000", os.Getpid())
+	if !strings.HasSuffix(msg, expected) {
+		t.Errorf("expected message ending %q, got %q", expected, msg)
+	}
+	if _, err := time.Parse(syslogTimeFormat, strings.Fields(msg)[1]); err != nil {
+		t.Errorf("expected message to have a timestamp: %v", err)
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	check(t, err)
+	defer ln.Close()
+
+	log := openLogger(t, Config{
+		Level:  DEBUG_LEVEL,
+		Format: JSON_FORMAT,
+		Sink:   SYSLOG_SINK,
+		Syslog: SyslogConfig{Addr: "tcp:" + ln.Addr().String()},
+	})
+	conn, err := ln.Accept()
+	check(t, err)
+	defer conn.Close()
+	log.Debugw("dialed", "ms", 2)
+	log.Errorw("dial failed", "error", "refused")
+
+	// Messages are framed by their length.
+	r := bufio.NewReader(conn)
+	var msgs []string
+	for i := 0; i < 2; i++ {
+		size, err := r.ReadString(' ')
+		check(t, err)
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		check(t, err)
+		msg := make([]byte, n)
+		_, err = io.ReadFull(r, msg)
+		check(t, err)
+		msgs = append(msgs, string(msg))
+	}
+
+	// user (1) * 8 + debug (7), and + error (3).
+	for i, expected := range []struct {
+		prefix string
+		fields map[string]interface{}
+	}{
+		{"<15>1 ", map[string]interface{}{"msg": "dialed", "ms": float64(2)}},
+		{"<11>1 ", map[string]interface{}{"msg": "dial failed", "error": "refused"}},
+	} {
+		if !strings.HasPrefix(msgs[i], expected.prefix) {
+			t.Errorf("expected message starting %q, got %q", expected.prefix, msgs[i])
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(msgs[i][strings.Index(msgs[i], "{"):]), &fields); err != nil {
+			t.Fatalf("expected a JSON message, got %q: %v", msgs[i], err)
+		}
+		for key, value := range expected.fields {
+			if fields[key] != value {
+				t.Errorf("expected %s to be %v, got %v", key, value, fields[key])
+			}
+		}
+	}
+}
+
+func TestJournald(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "journal.socket")
+	conn, err := net.ListenPacket("unixgram", socket)
+	check(t, err)
+	defer conn.Close()
+
+	log := openLogger(t, Config{
+		Level:    INFO_LEVEL,
+		Sink:     JOURNALD_SINK,
+		Journald: JournaldConfig{Socket: socket},
+		Tag:      "tcp-proxy",
+	})
+	log.With("frontend", "default").Errorw("proxy failed", "backend-addr", "localhost:9000", "error", "line 1\nline 2")
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	check(t, err)
+	fields := parseJournalFields(t, buf[:n])
+
+	expected := map[string]string{
+		"MESSAGE":           "proxy failed",
+		"PRIORITY":          "3",
+		"SYSLOG_IDENTIFIER": "tcp-proxy",
+		"FRONTEND":          "default",
+		"BACKEND_ADDR":      "localhost:9000",
+		"ERROR":             "line 1\nline 2",
+	}
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Errorf("expected %s to be %q, got %q", key, value, fields[key])
+		}
+	}
+
+	// User fields don't override the journal's own.
+	log.Infow("priority load changed", "priority", 0, "message", "spoofed")
+	n, _, err = conn.ReadFrom(buf)
+	check(t, err)
+	count := 0
+	for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("PRIORITY=")) {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected PRIORITY once, got %d times in %q", count, buf[:n])
+	}
+	fields = parseJournalFields(t, buf[:n])
+	expected = map[string]string{
+		"MESSAGE":    "priority load changed",
+		"PRIORITY":   "6",
+		"F_PRIORITY": "0",
+		"F_MESSAGE":  "spoofed",
+	}
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Errorf("expected %s to be %q, got %q", key, value, fields[key])
+		}
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	for key, expected := range map[string]string{
+		"pool":              "POOL",
+		"dial_ms":           "DIAL_MS",
+		"client.ip":         "CLIENT_IP",
+		"_hidden":           "F__HIDDEN",
+		"2xx":               "F_2XX",
+		"":                  "F_",
+		"rétry":             "R_TRY",
+		"priority":          "F_PRIORITY",
+		"message":           "F_MESSAGE",
+		"Syslog-Identifier": "F_SYSLOG_IDENTIFIER",
+	} {
+		if name := journalFieldName(key); name != expected {
+			t.Errorf("expected %q to be named %s, got %s", key, expected, name)
+		}
+	}
+}
+
+func openLogger(t *testing.T, cfg Config) *Logger {
+	w, enc, err := openSink(cfg)
+	check(t, err)
+	return &Logger{out: &output{level: uint32(cfg.Level), w: w, enc: enc}}
+}
+
+// parseJournalFields decodes a journal native protocol datagram.
+func parseJournalFields(t *testing.T, b []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(b) > 0 {
+		line := b[:bytes.IndexByte(b, '\n')]
+		b = b[len(line)+1:]
+		if i := bytes.IndexByte(line, '='); i >= 0 {
+			fields[string(line[:i])] = string(line[i+1:])
+			continue
+		}
+		size := binary.LittleEndian.Uint64(b)
+		fields[string(line)] = string(b[8 : 8+size])
+		if b[8+size] != '\n' {
+			t.Fatalf("expected field %s to end with a newline", line)
+		}
+		b = b[8+size+1:]
+	}
+	return fields
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"os"
+	"strconv"
+)
+
+const syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// SyslogEncoder writes entries as RFC 5424 syslog messages.
+// The message and its fields follow the header as text,
+// or as a JSON object for the JSON format.
+type SyslogEncoder struct {
+	Format   Format
+	Facility int
+	Hostname string
+	Tag      string
+	PID      int
+}
+
+func newSyslogEncoder(cfg Config) SyslogEncoder {
+	facility := cfg.Syslog.Facility
+	if facility == 0 {
+		facility = 1
+	}
+	hostname, _ := os.Hostname()
+	return SyslogEncoder{
+		Format:   cfg.Format,
+		Facility: facility,
+		Hostname: hostname,
+		Tag:      cfg.Tag,
+		PID:      os.Getpid(),
+	}
+}
+
+func (enc SyslogEncoder) Encode(buf []byte, e *Entry) []byte {
+	buf = append(buf, '<')
+	buf = strconv.AppendInt(buf, int64(enc.Facility*8+e.Level.severity()), 10)
+	buf = append(buf, ">1 "...)
+	buf = e.Time.AppendFormat(buf, syslogTimeFormat)
+	buf = append(buf, ' ')
+	buf = appendHeaderField(buf, enc.Hostname, 255)
+	buf = append(buf, ' ')
+	buf = appendHeaderField(buf, enc.Tag, 48)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(enc.PID), 10)
+	// No message ID or structured data.
+	buf = append(buf, " - - "...)
+	if enc.Format == JSON_FORMAT {
+		buf = append(buf, '{')
+		buf = appendJSONFields(buf, e)
+		return append(buf, '}')
+	}
+	return appendText(buf, e)
+}
+
+// appendHeaderField appends s, truncated to max bytes and with any
+// spaces or non-printing characters replaced, or - if it's empty.
+func appendHeaderField(buf []byte, s string, max int) []byte {
+	if s == "" {
+		return append(buf, '-')
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c > ' ' && c <= '~' {
+			buf = append(buf, c)
+		} else {
+			buf = append(buf, '_')
+		}
+	}
+	return buf
+}
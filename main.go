@@ -3,17 +3,27 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/jmuia/tcp-proxy/admin"
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/jmuia/tcp-proxy/discovery"
 	"github.com/jmuia/tcp-proxy/health"
 	"github.com/jmuia/tcp-proxy/loadbalancer"
 	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/jmuia/tcp-proxy/metrics"
 	"github.com/jmuia/tcp-proxy/proxy"
+	"github.com/jmuia/tcp-proxy/ratelimit"
+	"github.com/pkg/errors"
 )
 
+var adminAddr = flag.String("admin", "", "address to serve the admin API on, e.g. localhost:4040")
+
 func main() {
 	cfg := cli()
 	tcpProxy, err := proxy.NewTCPProxy(*cfg)
@@ -24,6 +34,15 @@ func main() {
 
 	handleExitSignal(tcpProxy)
 	handleStatsSignal(tcpProxy)
+	handleReloadSignal(tcpProxy)
+	handleReopenSignal()
+
+	if *adminAddr != "" {
+		go func() {
+			err := admin.ListenAndServe(*adminAddr, tcpProxy)
+			logger.Error(errors.Wrap(err, "admin API failed"))
+		}()
+	}
 
 	err = tcpProxy.Run()
 	if err != nil {
@@ -40,7 +59,20 @@ func cli() *proxy.Config {
 		flag.PrintDefaults()
 		fmt.Println()
 
+		fmt.Println("Positional backends make up the default pool of the default")
+		fmt.Println("frontend. Pools, SNI routes and authorization rules apply to")
+		fmt.Println("the default frontend unless prefixed with FRONTEND/.")
+		fmt.Println("Backends may be dns:NAME:PORT, for each of NAME's addresses, or")
+		fmt.Println("srv:_SERVICE._PROTO.NAME, for each target of its SRV records.")
+		fmt.Println("A BACKEND@N suffix puts a backend in priority tier N (default 0);")
+		fmt.Println("lower tiers only get traffic when higher ones are mostly down.")
+		fmt.Println()
+
 		fmt.Println("Metrics: send SIGINFO (ctrl-t) or SIGUSR1")
+		fmt.Println("Reload access control lists: send SIGHUP")
+		fmt.Println("Reopen log files: send SIGUSR2")
+		fmt.Println("Admin API (-admin): GET /stats, POST /drain?backend=ADDR,")
+		fmt.Println("  POST /remove?backend=ADDR[&timeout=DURATION]")
 		fmt.Println()
 
 		fmt.Println("Example:")
@@ -48,24 +80,202 @@ func cli() *proxy.Config {
 		fmt.Println("\t-laddr localhost:4000 \\")
 		fmt.Println("\t-timeout 3s \\")
 		fmt.Println("\t-lb random \\")
+		fmt.Println("\t-pool api=localhost:9001,localhost:9002 \\")
+		fmt.Println("\t-sni api.example.com=api \\")
+		fmt.Println("\t-frontend db=localhost:5432,lb=RANDOM,idle-timeout=1h \\")
+		fmt.Println("\t-pool db/default=localhost:15432 \\")
+		fmt.Println("\t-frontend dns=udp:localhost:5353 \\")
+		fmt.Println("\t-pool dns/default=localhost:53 \\")
+		fmt.Println("\t-pool app=unix:/var/run/app.sock \\")
+		fmt.Println("\t-pool web=localhost:7001,dr.example.com:7001@1 \\")
+		fmt.Println("\t-discovery-file workers=/etc/tcp-proxy/workers.json \\")
 		fmt.Println("\tlocalhost:8001 \\")
 		fmt.Println("\tlocalhost:8002")
 	}
 
-	flag.StringVar(&cfg.Laddr, "laddr", ":4000", "address to listen on")
-	flag.DurationVar(&cfg.Timeout, "timeout", 3*time.Second, "backend dial timeout")
+	// Settings shared by every frontend.
+	base := proxy.FrontendConfig{Name: "default"}
+
+	flag.StringVar(&base.Laddr, "laddr", ":4000", "address for the default frontend to listen on ([udp:]ADDR or unix:PATH)")
+	flag.DurationVar(&base.Timeout, "timeout", 3*time.Second, "backend dial timeout")
+	socketMode := flag.Uint("socket-mode", 0, "permissions for unix: listener sockets, e.g. 0660")
+	flag.DurationVar(&base.IdleTimeout, "idle-timeout", 0, "close connections after this long without traffic (UDP sessions default to 30s)")
+	flag.DurationVar(&base.FirstByteTimeout, "first-byte-timeout", 0, "close connections whose client sends nothing for this long")
+	flag.DurationVar(&base.MaxLifetime, "max-lifetime", 0, "close connections once they've been open this long")
+	flag.DurationVar(&base.HalfCloseTimeout, "half-close-timeout", 0, "close half-closed connections after this long without traffic from the side still sending (default 1m)")
+	flag.Float64Var(&base.RateLimit.Rate, "rate-limit", 0, "new connections per second across all clients")
+	flag.IntVar(&base.RateLimit.Burst, "rate-limit-burst", 0, "connections allowed at once over -rate-limit (defaults to the rate)")
+	flag.Float64Var(&base.RateLimit.SourceRate, "source-rate-limit", 0, "new connections per second from each source")
+	flag.IntVar(&base.RateLimit.SourceBurst, "source-rate-limit-burst", 0, "connections allowed at once over -source-rate-limit (defaults to the rate)")
+	flag.IntVar(&base.RateLimit.MaxConnsPerSource, "source-max-conns", 0, "concurrent connections allowed from each source")
+	flag.IntVar(&base.RateLimit.IPv4Prefix, "source-ipv4-prefix", 32, "prefix length grouping IPv4 clients into one source")
+	flag.IntVar(&base.RateLimit.IPv6Prefix, "source-ipv6-prefix", 128, "prefix length grouping IPv6 clients into one source")
+	flag.Var(newRateLimitPolicyVar(&base.RateLimit.Policy, ratelimit.CLOSE_POLICY), "rate-limit-policy", "treatment of connections over a limit (CLOSE|DELAY)")
+	flag.DurationVar(&base.RateLimit.MaxDelay, "rate-limit-max-delay", 1*time.Second, "longest a connection is delayed before it's closed")
+	flag.DurationVar(&base.HandshakeTimeout, "handshake-timeout", 3*time.Second, "client TLS handshake timeout")
+
+	flag.Var(newLbTypeVar(&base.Lb.Type, loadbalancer.P2C_TYPE), "lb", "load balancer algorithm (RANDOM|P2C)")
+	flag.DurationVar(&base.Lb.SlowStart.Window, "slow-start", 0, "ramp up traffic to backends over this long after they become healthy")
+	flag.Float64Var(&base.Lb.SlowStart.MinWeight, "slow-start-min-weight", 0.1, "share of traffic a backend starts at during slow start")
+	flag.Float64Var(&base.Lb.SlowStart.Aggression, "slow-start-aggression", 1.0, "slow start curve; 1 is linear, higher ramps up faster at first")
+	flag.Float64Var(&base.Lb.PanicThreshold, "panic-threshold", 0, "percentage of a pool's backends that must be healthy; below it, health is ignored (0 disables)")
+	flag.Float64Var(&base.Lb.Overprovisioning, "overprovisioning", 1.4, "factor scaling a priority tier's healthy fraction before traffic spills to the next tier")
+
+	frontends := pairsValue{}
+	flag.Var(&frontends, "frontend", "additional frontend NAME=ADDR[,OPTION=VALUE]..., where ADDR is [udp:]LADDR or unix:PATH and each OPTION overrides one of -lb, -slow-start, -timeout, -idle-timeout, -first-byte-timeout, -max-lifetime, -half-close-timeout or -handshake-timeout (repeatable)")
+	pools := pairsValue{}
+	flag.Var(&pools, "pool", "named backend pool [FRONTEND/]NAME=BACKEND[,BACKEND...] (repeatable)")
+	discoveryFiles := pairsValue{}
+	flag.Var(&discoveryFiles, "discovery-file", "pool whose backends are read from a JSON or YAML file, watched for changes [FRONTEND/]NAME=PATH (repeatable)")
+	catalogs := pairsValue{}
+	flag.Var(&catalogs, "catalog", "pool whose backends are a service's instances in a Consul-style catalog [FRONTEND/]NAME=URL, e.g. web=http://localhost:8500/v1/health/service/web (repeatable)")
+	routes := pairsValue{}
+	flag.Var(&routes, "sni", "route a TLS server name to a pool [FRONTEND/]PATTERN=NAME (repeatable)")
+
+	flag.StringVar(&base.TLS.CertFile, "tls-cert", "", "terminate TLS using this certificate file")
+	flag.StringVar(&base.TLS.KeyFile, "tls-key", "", "terminate TLS using this key file")
+	flag.StringVar(&base.TLS.ClientCAFile, "tls-client-ca", "", "verify client certificates against this CA file")
+	flag.BoolVar(&base.TLS.RequireClientCert, "tls-require-client-cert", false, "require clients to present a certificate")
+	authz := pairsValue{}
+	flag.Var(&authz, "authz", "authorize client certificates for a pool [FRONTEND/]NAME=(subject|cn|dns|uri):PATTERN (repeatable)")
 
-	flag.Var(newLbTypeVar(&cfg.Lb.Type, loadbalancer.P2C_TYPE), "lb", "load balancer algorithm (RANDOM|P2C)")
+	var limits proxy.PoolConfig
+	flag.Uint64Var(&limits.MaxConnsPerBackend, "backend-max-conns", 0, "connections allowed to each backend")
+	flag.Uint64Var(&limits.MaxPendingPerBackend, "backend-max-pending", 0, "dials allowed in progress to each backend")
+	flag.DurationVar(&limits.QueueTimeout, "queue-timeout", 0, "how long clients wait when every backend is at its limits (closed immediately if 0)")
+	flag.DurationVar(&limits.DiscoveryInterval, "discovery-interval", discovery.DefaultPollInterval, "how often discovery files are reread and dns:/srv: backends re-resolved (sooner if their TTLs are shorter)")
+	flag.DurationVar(&limits.ReconcileInterval, "reconcile-interval", backend.DefaultReconcileInterval, "least time between changes to a pool's discovered backends")
+	dnsServer := flag.String("dns-server", "", "resolve dns:/srv: backends by querying this HOST:PORT directly, respecting TTLs (default system resolver)")
+
+	var logCfg logger.Config
+	flag.Var(newLogLevelVar(&logCfg.Level, logger.INFO_LEVEL), "log-level", "least severe log entries written (DEBUG|INFO|WARN|ERROR); connections are logged at DEBUG")
+	flag.Var(newLogFormatVar(&logCfg.Format, logger.TEXT_FORMAT), "log-format", "log entry format (TEXT|JSON)")
+	flag.Var(newLogSinkVar(&logCfg.Sink, logger.DEFAULT_SINK), "log-sink", "where log entries are written (STDERR|FILE|SYSLOG|JOURNALD); FILE if -log-file is set, else STDERR")
+	flag.StringVar(&logCfg.File.Path, "log-file", "", "append log entries to this file instead of stderr")
+	logMaxSize := flag.Int64("log-max-size", 0, "rotate log files once they're larger than this many megabytes (never if 0)")
+	flag.DurationVar(&logCfg.File.MaxAge, "log-max-age", 0, "rotate log files once they've been open this long (never if 0)")
+	flag.IntVar(&logCfg.File.MaxBackups, "log-max-backups", 0, "rotated log files to keep (all if 0)")
+	flag.StringVar(&logCfg.Syslog.Addr, "syslog-addr", logger.DefaultSyslogAddr, "syslog server for the SYSLOG log sink (udp:HOST:PORT, tcp:HOST:PORT or unix:PATH)")
+	flag.IntVar(&logCfg.Syslog.Facility, "syslog-facility", 1, "syslog facility code, e.g. 16 for local0")
+	flag.StringVar(&logCfg.Journald.Socket, "journald-socket", logger.DefaultJournaldSocket, "systemd journal socket for the JOURNALD log sink")
+	flag.StringVar(&logCfg.Tag, "log-tag", "tcp-proxy", "application name given to syslog and journald")
+	flag.StringVar(&cfg.AccessLog.File.Path, "access-log", "", "append a record of each connection, once it closes, to this file (- for stdout)")
+	flag.Var(newLogFormatVar(&cfg.AccessLog.Format, logger.TEXT_FORMAT), "access-log-format", "access log record format (TEXT|JSON)")
+
+	flag.StringVar(&cfg.Statsd.Addr, "statsd", "", "send metrics to StatsD at this HOST:PORT over UDP")
+	flag.StringVar(&cfg.Statsd.Prefix, "statsd-prefix", "", "prefix for metric names sent to StatsD")
+	flag.DurationVar(&cfg.Statsd.Interval, "statsd-interval", metrics.DefaultStatsdInterval, "how often metrics are sent to StatsD")
+	flag.BoolVar(&cfg.Statsd.DogStatsD, "dogstatsd", false, "send frontend, pool, tier and backend name segments to StatsD as DogStatsD tags")
+
+	acls := pairsValue{}
+	flag.Var(&acls, "acl", "allow/deny rules file for a frontend FRONTEND=PATH, reloaded on SIGHUP and matched against the connection's source address, as the PROXY protocol isn't supported (repeatable)")
 
 	flag.Parse()
+	logCfg.File.MaxSize = *logMaxSize << 20
+	// The access log rotates like the application log.
+	path := cfg.AccessLog.File.Path
+	cfg.AccessLog.File = logCfg.File
+	cfg.AccessLog.File.Path = path
+	if err := logger.Configure(logCfg); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	base.SocketMode = os.FileMode(*socketMode)
+	base.Network, base.Laddr = splitNetwork(base.Laddr)
+	cfg.Frontends = append(cfg.Frontends, base)
+	for _, f := range frontends {
+		frontend, err := parseFrontend(base, f[0], f[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		cfg.Frontends = append(cfg.Frontends, frontend)
+	}
+
+	// Positional backends make up the default pool.
+	if flag.NArg() > 0 {
+		cfg.Frontends[0].Pools = append(cfg.Frontends[0].Pools, proxy.PoolConfig{
+			Name:     "default",
+			Backends: flag.Args(),
+		})
+	}
+
+	for _, p := range pools {
+		frontend, name := findFrontend(cfg.Frontends, "-pool", p[0])
+		frontend.Pools = append(frontend.Pools, proxy.PoolConfig{
+			Name:     name,
+			Backends: strings.Split(p[1], ","),
+		})
+	}
+
+	for _, d := range discoveryFiles {
+		frontend, name := findFrontend(cfg.Frontends, "-discovery-file", d[0])
+		frontend.Pools = append(frontend.Pools, proxy.PoolConfig{
+			Name:          name,
+			DiscoveryFile: d[1],
+		})
+	}
+
+	for _, c := range catalogs {
+		frontend, name := findFrontend(cfg.Frontends, "-catalog", c[0])
+		frontend.Pools = append(frontend.Pools, proxy.PoolConfig{
+			Name:    name,
+			Catalog: c[1],
+		})
+	}
+
+	for _, route := range routes {
+		frontend, pattern := findFrontend(cfg.Frontends, "-sni", route[0])
+		p := findPool(frontend, "-sni", route[1])
+		p.SNI = append(p.SNI, pattern)
+	}
+
+	for _, rule := range authz {
+		frontend, name := findFrontend(cfg.Frontends, "-authz", rule[0])
+		p := findPool(frontend, "-authz", name)
+		p.Authorize = append(p.Authorize, rule[1])
+	}
+
+	for _, a := range acls {
+		frontend := frontendNamed(cfg.Frontends, "-acl", a[0])
+		frontend.ACL.File = a[1]
+	}
+
+	for i := range cfg.Frontends {
+		for j := range cfg.Frontends[i].Pools {
+			p := &cfg.Frontends[i].Pools[j]
+			p.MaxConnsPerBackend = limits.MaxConnsPerBackend
+			p.MaxPendingPerBackend = limits.MaxPendingPerBackend
+			p.QueueTimeout = limits.QueueTimeout
+			p.DiscoveryInterval = limits.DiscoveryInterval
+			p.ReconcileInterval = limits.ReconcileInterval
+			if *dnsServer != "" {
+				p.Resolver = discovery.DNSResolver{Server: *dnsServer}
+			}
+		}
+	}
 
-	if flag.NArg() < 1 {
+	// Frontends without any backends are left out.
+	active := cfg.Frontends[:0]
+	for _, frontend := range cfg.Frontends {
+		if len(frontend.Pools) == 0 {
+			continue
+		}
+		for _, p := range frontend.Pools {
+			if p.Name == "default" {
+				frontend.DefaultPool = p.Name
+			}
+		}
+		active = append(active, frontend)
+	}
+	cfg.Frontends = active
+
+	if len(cfg.Frontends) == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	cfg.Backends = flag.Args()
-
 	cfg.GracePeriod = 5 * time.Second
 
 	cfg.Health = health.HealthCheckConfig{
@@ -98,6 +308,180 @@ func (v *lbTypeValue) Set(s string) error {
 	return nil
 }
 
+type rateLimitPolicyValue ratelimit.Policy
+
+func newRateLimitPolicyVar(p *ratelimit.Policy, value ratelimit.Policy) *rateLimitPolicyValue {
+	*p = value
+	return (*rateLimitPolicyValue)(p)
+}
+
+func (v *rateLimitPolicyValue) String() string {
+	return (*ratelimit.Policy)(v).String()
+}
+
+func (v *rateLimitPolicyValue) Set(s string) error {
+	p, err := ratelimit.ParsePolicy(s)
+	if err != nil {
+		return err
+	}
+	*v = rateLimitPolicyValue(p)
+	return nil
+}
+
+type logLevelValue logger.Level
+
+func newLogLevelVar(p *logger.Level, value logger.Level) *logLevelValue {
+	*p = value
+	return (*logLevelValue)(p)
+}
+
+func (v *logLevelValue) String() string {
+	return (*logger.Level)(v).String()
+}
+
+func (v *logLevelValue) Set(s string) error {
+	l, err := logger.ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*v = logLevelValue(l)
+	return nil
+}
+
+type logSinkValue logger.Sink
+
+func newLogSinkVar(p *logger.Sink, value logger.Sink) *logSinkValue {
+	*p = value
+	return (*logSinkValue)(p)
+}
+
+func (v *logSinkValue) String() string {
+	return (*logger.Sink)(v).String()
+}
+
+func (v *logSinkValue) Set(s string) error {
+	sink, err := logger.ParseSink(s)
+	if err != nil {
+		return err
+	}
+	*v = logSinkValue(sink)
+	return nil
+}
+
+type logFormatValue logger.Format
+
+func newLogFormatVar(p *logger.Format, value logger.Format) *logFormatValue {
+	*p = value
+	return (*logFormatValue)(p)
+}
+
+func (v *logFormatValue) String() string {
+	return (*logger.Format)(v).String()
+}
+
+func (v *logFormatValue) Set(s string) error {
+	f, err := logger.ParseFormat(s)
+	if err != nil {
+		return err
+	}
+	*v = logFormatValue(f)
+	return nil
+}
+
+type pairsValue [][2]string
+
+func (v *pairsValue) String() string {
+	return fmt.Sprint(*v)
+}
+
+func (v *pairsValue) Set(s string) error {
+	key, value, err := splitPair(s)
+	if err != nil {
+		return err
+	}
+	*v = append(*v, [2]string{key, value})
+	return nil
+}
+
+// splitNetwork separates an optional udp: prefix from addr.
+func splitNetwork(addr string) (string, string) {
+	if strings.HasPrefix(addr, "udp:") {
+		return "udp", strings.TrimPrefix(addr, "udp:")
+	}
+	return "tcp", addr
+}
+
+// parseFrontend configures the frontend of a -frontend NAME=SPEC flag,
+// where SPEC is its address followed by comma-separated OPTION=VALUE
+// overrides of base's settings, named like the flags that set them.
+func parseFrontend(base proxy.FrontendConfig, name string, spec string) (proxy.FrontendConfig, error) {
+	frontend := base
+	frontend.Name = name
+	options := strings.Split(spec, ",")
+	frontend.Network, frontend.Laddr = splitNetwork(options[0])
+
+	overrides := flag.NewFlagSet("-frontend "+name, flag.ContinueOnError)
+	overrides.SetOutput(ioutil.Discard)
+	overrides.Var((*lbTypeValue)(&frontend.Lb.Type), "lb", "")
+	overrides.DurationVar(&frontend.Timeout, "timeout", frontend.Timeout, "")
+	overrides.DurationVar(&frontend.IdleTimeout, "idle-timeout", frontend.IdleTimeout, "")
+	overrides.DurationVar(&frontend.FirstByteTimeout, "first-byte-timeout", frontend.FirstByteTimeout, "")
+	overrides.DurationVar(&frontend.MaxLifetime, "max-lifetime", frontend.MaxLifetime, "")
+	overrides.DurationVar(&frontend.HalfCloseTimeout, "half-close-timeout", frontend.HalfCloseTimeout, "")
+	overrides.DurationVar(&frontend.HandshakeTimeout, "handshake-timeout", frontend.HandshakeTimeout, "")
+	overrides.DurationVar(&frontend.Lb.SlowStart.Window, "slow-start", frontend.Lb.SlowStart.Window, "")
+	for _, option := range options[1:] {
+		key, value, err := splitPair(option)
+		if err == nil {
+			err = overrides.Set(key, value)
+		}
+		if err != nil {
+			return proxy.FrontendConfig{}, errors.Wrapf(err, "-frontend %s", name)
+		}
+	}
+	return frontend, nil
+}
+
+// findFrontend resolves a [FRONTEND/]KEY flag
+// key to its frontend and the unprefixed key.
+func findFrontend(frontends []proxy.FrontendConfig, flagName string, key string) (*proxy.FrontendConfig, string) {
+	name := "default"
+	if parts := strings.SplitN(key, "/", 2); len(parts) == 2 {
+		name, key = parts[0], parts[1]
+	}
+	return frontendNamed(frontends, flagName+" "+key, name), key
+}
+
+func frontendNamed(frontends []proxy.FrontendConfig, flagName string, name string) *proxy.FrontendConfig {
+	for i := range frontends {
+		if frontends[i].Name == name {
+			return &frontends[i]
+		}
+	}
+	fmt.Printf("%s: unknown frontend %s\n", flagName, name)
+	os.Exit(1)
+	return nil
+}
+
+func findPool(frontend *proxy.FrontendConfig, flagName string, name string) *proxy.PoolConfig {
+	for i := range frontend.Pools {
+		if frontend.Pools[i].Name == name {
+			return &frontend.Pools[i]
+		}
+	}
+	fmt.Printf("%s: unknown pool %s in frontend %s\n", flagName, name, frontend.Name)
+	os.Exit(1)
+	return nil
+}
+
+func splitPair(s string) (string, string, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New(fmt.Sprintf("expected KEY=VALUE, got %q", s))
+	}
+	return parts[0], parts[1], nil
+}
+
 func sorted(m map[string]interface{}) []string {
 	var keys []string
 	for k := range m {
@@ -108,7 +492,7 @@ func sorted(m map[string]interface{}) []string {
 }
 
 func handleExitSignal(tcpProxy *proxy.TCPProxy) {
-	exitc := make(chan os.Signal)
+	exitc := make(chan os.Signal, 1)
 	signal.Notify(exitc, exitSignals...)
 	go func() {
 		for range exitc {
@@ -118,8 +502,43 @@ func handleExitSignal(tcpProxy *proxy.TCPProxy) {
 	}()
 }
 
+func handleReloadSignal(tcpProxy *proxy.TCPProxy) {
+	// Notify with no signals would relay all of them.
+	if len(reloadSignals) == 0 {
+		return
+	}
+	reloadc := make(chan os.Signal, 1)
+	signal.Notify(reloadc, reloadSignals...)
+	go func() {
+		for range reloadc {
+			logger.Info("reloading access control lists")
+			err := tcpProxy.Reload()
+			if err != nil {
+				logger.Error(err)
+			}
+		}
+	}()
+}
+
+func handleReopenSignal() {
+	// Notify with no signals would relay all of them.
+	if len(reopenSignals) == 0 {
+		return
+	}
+	reopenc := make(chan os.Signal, 1)
+	signal.Notify(reopenc, reopenSignals...)
+	go func() {
+		for range reopenc {
+			if err := logger.ReopenFiles(); err != nil {
+				logger.Error(err)
+			}
+			logger.Info("reopened log files")
+		}
+	}()
+}
+
 func handleStatsSignal(tcpProxy *proxy.TCPProxy) {
-	statsc := make(chan os.Signal)
+	statsc := make(chan os.Signal, 1)
 	signal.Notify(statsc, statsSignals...)
 	go func() {
 		for range statsc {
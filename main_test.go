@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	"github.com/jmuia/tcp-proxy/proxy"
+)
+
+func TestParseFrontend(t *testing.T) {
+	base := proxy.FrontendConfig{Name: "default", Timeout: 3 * time.Second}
+	base.Lb.Type = loadbalancer.P2C_TYPE
+
+	db, err := parseFrontend(base, "db", "localhost:5432,lb=RANDOM,idle-timeout=1h,slow-start=30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns, err := parseFrontend(base, "dns", "udp:localhost:5353,timeout=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Name != "db" || db.Network != "tcp" || db.Laddr != "localhost:5432" {
+		t.Errorf("expected db to listen on tcp localhost:5432, got %+v", db)
+	}
+	if db.Lb.Type != loadbalancer.RANDOM_TYPE || db.IdleTimeout != time.Hour || db.Lb.SlowStart.Window != 30*time.Second {
+		t.Errorf("expected db's overrides to apply, got %+v", db)
+	}
+	if db.Timeout != 3*time.Second {
+		t.Errorf("expected db to keep the default timeout, got %s", db.Timeout)
+	}
+
+	if dns.Name != "dns" || dns.Network != "udp" || dns.Laddr != "localhost:5353" {
+		t.Errorf("expected dns to listen on udp localhost:5353, got %+v", dns)
+	}
+	if dns.Lb.Type != loadbalancer.P2C_TYPE || dns.IdleTimeout != 0 || dns.Timeout != time.Second {
+		t.Errorf("expected dns's overrides to apply, got %+v", dns)
+	}
+
+	// Overrides don't leak into the base settings.
+	if base.Lb.Type != loadbalancer.P2C_TYPE || base.Timeout != 3*time.Second {
+		t.Errorf("expected base settings to be unchanged, got %+v", base)
+	}
+
+	for _, spec := range []string{
+		"localhost:5432,lb=fastest",
+		"localhost:5432,timeout=soon",
+		"localhost:5432,weight=2",
+		"localhost:5432,timeout",
+	} {
+		if _, err := parseFrontend(base, "db", spec); err == nil {
+			t.Errorf("expected %q to be invalid", spec)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// How often a meter's moving averages are updated.
+const tickInterval = 5 * time.Second
+
+// Meter counts events and measures their rate per second, as
+// exponentially-weighted moving averages over the last 1, 5 and 15
+// minutes, like Unix load averages, and as a mean since it started.
+type Meter interface {
+	Count() uint64
+	Mark(n uint64)
+	Rate1() float64
+	Rate5() float64
+	Rate15() float64
+	RateMean() float64
+}
+
+func NewMeter() Meter {
+	return newMeter(time.Now)
+}
+
+func newMeter(now func() time.Time) *meter {
+	start := now()
+	return &meter{
+		now:      now,
+		start:    start,
+		lastTick: start,
+		m1:       newEWMA(1 * time.Minute),
+		m5:       newEWMA(5 * time.Minute),
+		m15:      newEWMA(15 * time.Minute),
+	}
+}
+
+// meter updates its averages when it's marked or read,
+// catching up on any intervals that have passed since.
+type meter struct {
+	lock      sync.Mutex
+	now       func() time.Time
+	count     uint64
+	uncounted uint64
+	start     time.Time
+	lastTick  time.Time
+	m1        *ewma
+	m5        *ewma
+	m15       *ewma
+}
+
+func (m *meter) Count() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.count
+}
+
+func (m *meter) Mark(n uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tick()
+	m.count += n
+	m.uncounted += n
+}
+
+func (m *meter) Rate1() float64 {
+	return m.rate(m.m1)
+}
+
+func (m *meter) Rate5() float64 {
+	return m.rate(m.m5)
+}
+
+func (m *meter) Rate15() float64 {
+	return m.rate(m.m15)
+}
+
+func (m *meter) RateMean() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	elapsed := m.now().Sub(m.start)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(m.count) / elapsed.Seconds()
+}
+
+func (m *meter) rate(e *ewma) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tick()
+	return e.rate
+}
+
+// tick updates the averages for each interval that's passed.
+// Events since the last update count towards the first.
+func (m *meter) tick() {
+	ticks := int(m.now().Sub(m.lastTick) / tickInterval)
+	if ticks <= 0 {
+		return
+	}
+	m.lastTick = m.lastTick.Add(time.Duration(ticks) * tickInterval)
+	for _, e := range []*ewma{m.m1, m.m5, m.m15} {
+		e.tick(m.uncounted, ticks)
+	}
+	m.uncounted = 0
+}
+
+type ewma struct {
+	alpha  float64
+	rate   float64
+	primed bool
+}
+
+func newEWMA(window time.Duration) *ewma {
+	return &ewma{alpha: 1 - math.Exp(-tickInterval.Seconds()/window.Seconds())}
+}
+
+// tick adds n events over one interval, then ticks-1 idle ones.
+func (e *ewma) tick(n uint64, ticks int) {
+	instant := float64(n) / tickInterval.Seconds()
+	if e.primed {
+		e.rate += e.alpha * (instant - e.rate)
+	} else {
+		e.rate, e.primed = instant, true
+	}
+	e.rate *= math.Pow(1-e.alpha, float64(ticks-1))
+}
+
+// Rates returns a meter's rates by the suffix
+// they're reported with, e.g. rate_1m.
+func Rates(m Meter) map[string]float64 {
+	return map[string]float64{
+		"rate_1m":   m.Rate1(),
+		"rate_5m":   m.Rate5(),
+		"rate_15m":  m.Rate15(),
+		"rate_mean": m.RateMean(),
+	}
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeter(t *testing.T) {
+	now := time.Unix(0, 0)
+	m := newMeter(func() time.Time { return now })
+
+	// 10 events a second for a minute.
+	for i := 0; i < 12; i++ {
+		m.Mark(50)
+		now = now.Add(tickInterval)
+	}
+	if m.Count() != 600 {
+		t.Errorf("expected a count of 600, got %d", m.Count())
+	}
+	assertRate(t, "1m", m.Rate1(), 10)
+	assertRate(t, "5m", m.Rate5(), 10)
+	assertRate(t, "15m", m.Rate15(), 10)
+	assertRate(t, "mean", m.RateMean(), 10)
+
+	// Then nothing for a minute: the 1-minute rate decays by 1/e,
+	// and the longer averages less.
+	now = now.Add(1 * time.Minute)
+	assertRate(t, "1m", m.Rate1(), 10/math.E)
+	if m.Rate5() <= m.Rate1() || m.Rate15() <= m.Rate5() {
+		t.Errorf("expected longer averages to decay more slowly, got %f %f %f", m.Rate1(), m.Rate5(), m.Rate15())
+	}
+	assertRate(t, "mean", m.RateMean(), 5)
+}
+
+func TestMeterCatchesUp(t *testing.T) {
+	now := time.Unix(0, 0)
+	m := newMeter(func() time.Time { return now })
+
+	// Events that aren't read until long after
+	// count towards the interval they happened in.
+	m.Mark(50)
+	now = now.Add(10 * tickInterval)
+	assertRate(t, "1m", m.Rate1(), 10*math.Pow(math.Exp(-5.0/60), 9))
+
+	// Less than an interval has passed.
+	now = now.Add(tickInterval / 2)
+	m.Mark(100)
+	assertRate(t, "1m", m.Rate1(), 10*math.Pow(math.Exp(-5.0/60), 9))
+}
+
+func assertRate(t *testing.T, name string, actual float64, expected float64) {
+	t.Helper()
+	if math.Abs(actual-expected) > 0.01 {
+		t.Errorf("expected %s rate %f, got %f", name, expected, actual)
+	}
+}
@@ -9,11 +9,14 @@ import (
 
 type Registry interface {
 	Register(name string, metric Metric)
+	Unregister(name string)
 	LoadOrRegisterCounter(name string, counter Counter) (Counter, error)
 	LoadOrRegisterGauge(name string, gauge Gauge) (Gauge, error)
+	LoadOrRegisterMeter(name string, meter Meter) (Meter, error)
 	All() map[string]Metric
 	Counters() map[string]Counter
 	Gauges() map[string]Gauge
+	Meters() map[string]Meter
 	Clear()
 }
 
@@ -29,6 +32,10 @@ func (r *registry) Register(name string, metric Metric) {
 	r.metrics.Store(name, metric)
 }
 
+func (r *registry) Unregister(name string) {
+	r.metrics.Delete(name)
+}
+
 func (r *registry) LoadOrRegisterCounter(name string, counter Counter) (Counter, error) {
 	m, _ := r.metrics.LoadOrStore(name, counter)
 	c, ok := m.(Counter)
@@ -47,6 +54,15 @@ func (r *registry) LoadOrRegisterGauge(name string, gauge Gauge) (Gauge, error)
 	return g, nil
 }
 
+func (r *registry) LoadOrRegisterMeter(name string, meter Meter) (Meter, error) {
+	m, _ := r.metrics.LoadOrStore(name, meter)
+	mt, ok := m.(Meter)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("metric named %s is not a Meter", name))
+	}
+	return mt, nil
+}
+
 func (r *registry) All() map[string]Metric {
 	metrics := make(map[string]Metric, 0)
 	// Range calls the function sequentially.
@@ -83,6 +99,19 @@ func (r *registry) Gauges() map[string]Gauge {
 	return gauges
 }
 
+func (r *registry) Meters() map[string]Meter {
+	meters := make(map[string]Meter, 0)
+	// Range calls the function sequentially.
+	r.metrics.Range(func(key, value interface{}) bool {
+		m, ok := value.(Meter)
+		if ok {
+			meters[key.(string)] = m
+		}
+		return true
+	})
+	return meters
+}
+
 func (r *registry) Clear() {
 	r.metrics.Range(func(key, value interface{}) bool {
 		r.metrics.Delete(key)
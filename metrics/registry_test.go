@@ -74,3 +74,44 @@ func TestRegistry(t *testing.T) {
 		t.Errorf("variableGauge expected to be 1, was %d", registryVariableGaugeValue)
 	}
 }
+
+func TestRegistryMeters(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("counter", NewCounter())
+
+	meter, err := registry.LoadOrRegisterMeter("meter", NewMeter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meter.Mark(2)
+	loaded, err := registry.LoadOrRegisterMeter("meter", NewMeter())
+	if err != nil || loaded.Count() != 2 {
+		t.Errorf("expected the registered meter to be loaded, got %v, %v", loaded, err)
+	}
+	if _, err := registry.LoadOrRegisterMeter("counter", NewMeter()); err == nil {
+		t.Error("expected loading a counter as a meter to fail")
+	}
+
+	meters := registry.Meters()
+	if len(meters) != 1 || meters["meter"] != meter {
+		t.Errorf("expected only meter in registry's meters, got %v", meters)
+	}
+	if _, ok := registry.Counters()["meter"]; ok {
+		t.Error("expected meters not to be counters")
+	}
+}
+
+func TestRegistryUnregister(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("counter", NewCounter())
+	registry.Register("gauge", NewUint64Gauge(func() uint64 { return 1 }))
+
+	registry.Unregister("counter")
+	// Unregistering an unknown metric is a no-op.
+	registry.Unregister("missing")
+
+	all := registry.All()
+	if _, ok := all["counter"]; ok || len(all) != 1 {
+		t.Errorf("expected only gauge in registry, got %v", all)
+	}
+}
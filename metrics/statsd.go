@@ -0,0 +1,265 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultStatsdInterval = 10 * time.Second
+	// Small enough not to be fragmented on most networks.
+	maxPacketSize = 1432
+)
+
+// StatsdConfig is where, and how often, a registry's metrics are
+// sent to StatsD. Names are prefixed with Prefix, if set. If
+// DogStatsD is set, the segment after each of TagKeys found in a
+// name is turned into a tag (see SegmentTags).
+type StatsdConfig struct {
+	Addr      string
+	Prefix    string
+	Interval  time.Duration
+	DogStatsD bool
+	TagKeys   []string
+}
+
+func (c StatsdConfig) Enabled() bool {
+	return c.Addr != ""
+}
+
+// StatsdReporter periodically sends a registry's counters, as the
+// change since they were last sent, and its numeric gauges, as
+// their current values, to StatsD over UDP. Meters are sent as
+// both: their count as a counter, and their rates as gauges.
+type StatsdReporter struct {
+	registry Registry
+	cfg      StatsdConfig
+	conn     net.Conn
+	// Each counter's count when it was last sent.
+	sent map[string]uint64
+	buf  []byte
+
+	lock    sync.Mutex
+	started bool
+	stopped bool
+	stopc   chan struct{}
+	donec   chan struct{}
+}
+
+func NewStatsdReporter(registry Registry, cfg StatsdConfig) (*StatsdReporter, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultStatsdInterval
+	}
+	cfg.Prefix = strings.TrimSuffix(cfg.Prefix, ".")
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to statsd")
+	}
+	return &StatsdReporter{
+		registry: registry,
+		cfg:      cfg,
+		conn:     conn,
+		sent:     make(map[string]uint64),
+		stopc:    make(chan struct{}),
+		donec:    make(chan struct{}),
+	}, nil
+}
+
+// Start sends the registry's metrics every interval until Stop.
+func (r *StatsdReporter) Start() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.started || r.stopped {
+		return
+	}
+	r.started = true
+	go r.run()
+}
+
+func (r *StatsdReporter) run() {
+	defer close(r.donec)
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				logger.Debugw("failed to send metrics to statsd", "error", err)
+			}
+		case <-r.stopc:
+			return
+		}
+	}
+}
+
+// Stop sends the metrics one last time and closes the connection.
+func (r *StatsdReporter) Stop() {
+	r.lock.Lock()
+	if r.stopped {
+		r.lock.Unlock()
+		return
+	}
+	r.stopped = true
+	started := r.started
+	close(r.stopc)
+	r.lock.Unlock()
+
+	if started {
+		<-r.donec
+	}
+	if err := r.Flush(); err != nil {
+		logger.Debugw("failed to send metrics to statsd", "error", err)
+	}
+	r.conn.Close()
+}
+
+// Flush sends the registry's metrics now, packing
+// as many into each datagram as will fit.
+func (r *StatsdReporter) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.buf = r.buf[:0]
+	var err error
+	send := func(name string, value string, kind string) {
+		start := len(r.buf)
+		if start > 0 {
+			r.buf = append(r.buf, '\n')
+		}
+		r.buf = r.appendMetric(r.buf, name, value, kind)
+		if len(r.buf) > maxPacketSize && start > 0 {
+			if _, writeErr := r.conn.Write(r.buf[:start]); err == nil {
+				err = writeErr
+			}
+			r.buf = append(r.buf[:0], r.buf[start+1:]...)
+		}
+	}
+
+	sendCount := func(name string, count uint64) {
+		delta := count - r.sent[name]
+		if count < r.sent[name] {
+			// The counter was replaced.
+			delta = count
+		}
+		r.sent[name] = count
+		if delta > 0 {
+			send(name, strconv.FormatUint(delta, 10), "c")
+		}
+	}
+
+	for name, counter := range r.registry.Counters() {
+		sendCount(name, counter.Count())
+	}
+	for name, meter := range r.registry.Meters() {
+		sendCount(name, meter.Count())
+		for suffix, rate := range Rates(meter) {
+			send(name+"."+suffix, strconv.FormatFloat(rate, 'f', -1, 64), "g")
+		}
+	}
+	for name, gauge := range r.registry.Gauges() {
+		if value, ok := numeric(gauge.Value()); ok {
+			send(name, value, "g")
+		}
+	}
+	if len(r.buf) > 0 {
+		if _, writeErr := r.conn.Write(r.buf); err == nil {
+			err = writeErr
+		}
+	}
+	return err
+}
+
+// appendMetric appends name:value|kind, followed by the
+// name's tags for DogStatsD.
+func (r *StatsdReporter) appendMetric(buf []byte, name, value, kind string) []byte {
+	var tags []string
+	if r.cfg.DogStatsD {
+		name, tags = SegmentTags(name, r.cfg.TagKeys...)
+	}
+	if r.cfg.Prefix != "" {
+		name = r.cfg.Prefix + "." + name
+	}
+	buf = append(buf, sanitize(name, ":|@#")...)
+	buf = append(buf, ':')
+	buf = append(buf, value...)
+	buf = append(buf, '|')
+	buf = append(buf, kind...)
+	for i, tag := range tags {
+		if i == 0 {
+			buf = append(buf, "|#"...)
+		} else {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, sanitize(tag, "|@#,")...)
+	}
+	return buf
+}
+
+// numeric formats a gauge's value, if it's a number.
+func numeric(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case int:
+		return strconv.Itoa(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+// sanitize replaces whitespace and any of chars in s,
+// which would break the StatsD line protocol.
+func sanitize(s string, chars string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || strings.ContainsRune(chars, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// SanitizeSegment replaces the dots in s, such as an address,
+// so that it's a single segment of a dotted metric name.
+func SanitizeSegment(s string) string {
+	return strings.Replace(s, ".", "_", -1)
+}
+
+// SegmentTags removes the segment after each of keys found as a
+// segment of the dotted name, and returns what's left of the name
+// and key:value tags. The keys stay in the name, so metrics of each
+// level (a frontend's requests and the proxy's, say) keep distinct
+// names rather than being summed. Values must be a single segment;
+// see SanitizeSegment.
+func SegmentTags(name string, keys ...string) (string, []string) {
+	segments := strings.Split(name, ".")
+	var kept, tags []string
+	for i := 0; i < len(segments); i++ {
+		if !contains(keys, segments[i]) || i+1 >= len(segments) {
+			kept = append(kept, segments[i])
+			continue
+		}
+		kept = append(kept, segments[i])
+		tags = append(tags, segments[i]+":"+segments[i+1])
+		i++
+	}
+	return strings.Join(kept, "."), tags
+}
+
+func contains(keys []string, s string) bool {
+	for _, key := range keys {
+		if key == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdReporter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	registry := NewRegistry()
+	requests := NewCounter()
+	registry.Register("requests", requests)
+	registry.Register("idle", NewCounter())
+	var active uint64 = 3
+	registry.Register("backend.localhost:9000.active_connections", NewUint64Gauge(func() uint64 { return active }))
+	registry.Register("backend.localhost:9000.state", NewStringGauge(func() string { return "HEALTHY" }))
+
+	r, err := NewStatsdReporter(registry, StatsdConfig{Addr: conn.LocalAddr().String(), Prefix: "proxy."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	// Counters are sent as deltas, and only if they've changed.
+	// Gauges are sent as they are, if they're numbers.
+	requests.Add(5)
+	assertPacket(t, r, conn, "proxy.backend.localhost_9000.active_connections:3|g", "proxy.requests:5|c")
+	requests.Add(2)
+	active = 1
+	assertPacket(t, r, conn, "proxy.backend.localhost_9000.active_connections:1|g", "proxy.requests:2|c")
+	assertPacket(t, r, conn, "proxy.backend.localhost_9000.active_connections:1|g")
+}
+
+func TestStatsdMeters(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	now := time.Unix(0, 0)
+	meter := newMeter(func() time.Time { return now })
+	registry := NewRegistry()
+	registry.Register("requests", meter)
+	r, err := NewStatsdReporter(registry, StatsdConfig{Addr: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	// A meter's count is sent as a counter, and its rates as gauges.
+	meter.Mark(3)
+	assertPacket(t, r, conn, "requests.rate_15m:0|g", "requests.rate_1m:0|g", "requests.rate_5m:0|g", "requests.rate_mean:0|g", "requests:3|c")
+	now = now.Add(tickInterval)
+	assertPacket(t, r, conn, "requests.rate_15m:0.6|g", "requests.rate_1m:0.6|g", "requests.rate_5m:0.6|g", "requests.rate_mean:0.6|g")
+}
+
+func TestDogStatsdTags(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	registry := NewRegistry()
+	registry.Register("backend.10_0_0_1:9000.io.tx", NewCounter())
+	registry.Counters()["backend.10_0_0_1:9000.io.tx"].Add(10)
+	registry.Register("frontend.default.pool.api.tier.0.healthy", NewUint64Gauge(func() uint64 { return 2 }))
+
+	r, err := NewStatsdReporter(registry, StatsdConfig{
+		Addr:      conn.LocalAddr().String(),
+		DogStatsD: true,
+		TagKeys:   []string{"frontend", "pool", "tier", "backend"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	assertPacket(t, r, conn,
+		"backend.io.tx:10|c|#backend:10_0_0_1:9000",
+		"frontend.pool.tier.healthy:2|g|#frontend:default,pool:api,tier:0",
+	)
+}
+
+func TestStatsdReporterSplitsPackets(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	registry := NewRegistry()
+	for i := 0; i < 100; i++ {
+		name := strings.Repeat("x", 50) + string(rune('0'+i/10)) + string(rune('0'+i%10))
+		registry.Register(name, NewUint64Gauge(func() uint64 { return 1 }))
+	}
+	r, err := NewStatsdReporter(registry, StatsdConfig{Addr: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := 0
+	for metrics < 100 {
+		packet := readPacket(t, conn)
+		if len(packet) > maxPacketSize {
+			t.Fatalf("expected packets of at most %d bytes, got %d", maxPacketSize, len(packet))
+		}
+		metrics += len(strings.Split(packet, "\n"))
+	}
+	if metrics != 100 {
+		t.Errorf("expected 100 metrics, got %d", metrics)
+	}
+}
+
+func TestSegmentTags(t *testing.T) {
+	keys := []string{"frontend", "pool", "backend"}
+	for _, test := range []struct {
+		name     string
+		expected string
+		tags     []string
+	}{
+		{"requests", "requests", nil},
+		{"frontend.web.requests", "frontend.requests", []string{"frontend:web"}},
+		{"frontend.web.pool.api.panic", "frontend.pool.panic", []string{"frontend:web", "pool:api"}},
+		{"backend.db_internal:5432.state", "backend.state", []string{"backend:db_internal:5432"}},
+		{"backend." + SanitizeSegment("unix:/run/app.sock") + ".state", "backend.state", []string{"backend:unix:/run/app_sock"}},
+		{"backend.[::1]:80.io.rx", "backend.io.rx", []string{"backend:[::1]:80"}},
+		{"frontend.web", "frontend", []string{"frontend:web"}},
+		{"closed.frontend", "closed.frontend", nil},
+	} {
+		name, tags := SegmentTags(test.name, keys...)
+		if name != test.expected || !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("expected %s to be %s %v, got %s %v", test.name, test.expected, test.tags, name, tags)
+		}
+	}
+}
+
+// assertPacket flushes r and expects one packet with the given lines.
+func assertPacket(t *testing.T, r *StatsdReporter, conn net.PacketConn, expected ...string) {
+	t.Helper()
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(readPacket(t, conn), "\n")
+	sort.Strings(lines)
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	buf := make([]byte, 2*maxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
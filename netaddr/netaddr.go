@@ -0,0 +1,20 @@
+package netaddr
+
+import "strings"
+
+const unixPrefix = "unix:"
+
+// Split returns the network and address to dial or listen on for
+// addr. Addresses of the form unix:/path/to.sock are Unix domain
+// sockets; anything else is an address on defaultNetwork.
+func Split(addr string, defaultNetwork string) (network string, address string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", strings.TrimPrefix(addr, unixPrefix)
+	}
+	return defaultNetwork, addr
+}
+
+// IsUnix reports whether addr is a Unix domain socket address.
+func IsUnix(addr string) bool {
+	return strings.HasPrefix(addr, unixPrefix)
+}
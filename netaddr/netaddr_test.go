@@ -0,0 +1,27 @@
+package netaddr
+
+import "testing"
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"localhost:8000", "tcp", "localhost:8000"},
+		{"[::1]:8000", "tcp", "[::1]:8000"},
+		{"unix:/var/run/app.sock", "unix", "/var/run/app.sock"},
+		{"unix:relative.sock", "unix", "relative.sock"},
+	}
+	for _, c := range cases {
+		network, address := Split(c.addr, "tcp")
+		if network != c.network || address != c.address {
+			t.Errorf("expected Split(%q) to be (%s, %s), was (%s, %s)", c.addr, c.network, c.address, network, address)
+		}
+	}
+
+	network, address := Split("localhost:53", "udp")
+	if network != "udp" || address != "localhost:53" {
+		t.Errorf("expected default network udp, was (%s, %s)", network, address)
+	}
+}
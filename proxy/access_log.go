@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/pkg/errors"
+)
+
+// Why a connection closed, as recorded in the access log.
+const (
+	closedClientEOF  = "client_eof"
+	closedBackendEOF = "backend_eof"
+	closedTimeout    = "timeout"
+	closedError      = "error"
+	closedShutdown   = "shutdown"
+)
+
+// AccessLogConfig is where, and in what format, a record of
+// each proxied connection is written once it closes. File.Path
+// is a file to append to, or - for stdout; empty disables it.
+type AccessLogConfig struct {
+	File   logger.FileConfig
+	Format logger.Format
+}
+
+func (c AccessLogConfig) Enabled() bool {
+	return c.File.Path != ""
+}
+
+// openAccessLog returns the access log, the file it writes
+// to (to be closed on exit), or neither if it's disabled.
+func openAccessLog(cfg AccessLogConfig) (*logger.Logger, io.Closer, error) {
+	if !cfg.Enabled() {
+		return nil, nil, nil
+	}
+	if cfg.File.Path == "-" {
+		return logger.New(os.Stdout, cfg.Format, logger.INFO_LEVEL), nil, nil
+	}
+	f, err := logger.OpenFile(cfg.File)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open access log")
+	}
+	return logger.New(f, cfg.Format, logger.INFO_LEVEL), f, nil
+}
+
+// Connection IDs are a random per-process prefix and a counter.
+var (
+	connIDPrefix = randomUint32()
+	connIDs      uint64
+)
+
+func randomUint32() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint32(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func newConnID() string {
+	return fmt.Sprintf("%08x%08x", connIDPrefix, atomic.AddUint64(&connIDs, 1))
+}
+
+// connRecord is what's known about a client's
+// connection (or UDP session) so far.
+type connRecord struct {
+	id       string
+	start    time.Time
+	client   net.Addr
+	listener net.Addr
+	pool     string
+	backend  string
+	dialTime time.Duration
+	bytes    *proxyIoStats
+	reason   string
+	// Which timeout closed the connection, if any.
+	timeout string
+	err     error
+
+	// The proxied connections, and whether they were
+	// closed because the frontend shut down.
+	src      net.Conn
+	dst      net.Conn
+	shutdown int32
+}
+
+func (f *frontend) newConnRecord(client net.Addr) *connRecord {
+	return &connRecord{
+		id:       newConnID(),
+		start:    time.Now(),
+		client:   client,
+		listener: f.addr(),
+	}
+}
+
+// logAccess writes r to the access log. Clients that fail
+// to be proxied are recorded as closing with an error.
+func (f *frontend) logAccess(r *connRecord, err error) {
+	if f.accessLog == nil {
+		return
+	}
+	if err != nil {
+		r.reason, r.err = closedError, err
+	}
+	var in, out uint64
+	if r.bytes != nil {
+		in = atomic.LoadUint64(&r.bytes.frontend.rx)
+		out = atomic.LoadUint64(&r.bytes.frontend.tx)
+	}
+	kv := []interface{}{
+		"id", r.id,
+		"frontend", f.name(),
+		"client", r.client,
+		"listener", r.listener,
+		"pool", r.pool,
+		"backend", r.backend,
+		"dial_ms", milliseconds(r.dialTime),
+		"duration_ms", milliseconds(time.Since(r.start)),
+		"bytes_in", in,
+		"bytes_out", out,
+		"reason", r.reason,
+	}
+	if r.timeout != "" {
+		kv = append(kv, "timeout", r.timeout)
+	}
+	if r.err != nil {
+		kv = append(kv, "error", r.err)
+	}
+	f.accessLog.Infow("connection", kv...)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Round(time.Microsecond)) / float64(time.Millisecond)
+}
+
+// activeConns tracks a frontend's proxied connections
+// so that they can be closed when it shuts down.
+type activeConns struct {
+	lock    sync.Mutex
+	records map[*connRecord]struct{}
+	closed  bool
+	// Done once each connection has been recorded.
+	wg sync.WaitGroup
+}
+
+func newActiveConns() *activeConns {
+	return &activeConns{records: make(map[*connRecord]struct{})}
+}
+
+// add tracks r's connections, or closes them if the frontend
+// has already shut down. remove must be called once r is logged.
+func (ac *activeConns) add(r *connRecord) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	if ac.closed {
+		r.close()
+		return
+	}
+	ac.records[r] = struct{}{}
+	ac.wg.Add(1)
+}
+
+func (ac *activeConns) remove(r *connRecord) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	if _, tracked := ac.records[r]; tracked {
+		delete(ac.records, r)
+		ac.wg.Done()
+	}
+}
+
+// closeAll closes every connection and waits for them to be logged.
+func (ac *activeConns) closeAll() {
+	ac.lock.Lock()
+	ac.closed = true
+	for r := range ac.records {
+		r.close()
+	}
+	ac.lock.Unlock()
+	ac.wg.Wait()
+}
+
+// close closes the record's connections for shutdown.
+func (r *connRecord) close() {
+	atomic.StoreInt32(&r.shutdown, 1)
+	r.src.Close()
+	r.dst.Close()
+}
+
+func (r *connRecord) wasShutdown() bool {
+	return atomic.LoadInt32(&r.shutdown) == 1
+}
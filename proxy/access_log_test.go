@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	logger "github.com/jmuia/tcp-proxy/logging"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	dir, err := ioutil.TempDir("", "access-log")
+	check(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Laddr:       "localhost:0",
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", Backends: []string{backendListener.Addr().String()}}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+		AccessLog: AccessLogConfig{File: logger.FileConfig{Path: path}, Format: logger.JSON_FORMAT},
+	})
+	check(t, err)
+	check(t, tcpProxy.Start())
+	defer tcpProxy.Shutdown()
+	laddr := tcpProxy.frontends[0].ln.Addr().String()
+
+	connect := func() (net.Conn, net.Conn) {
+		client, err := net.Dial("tcp", laddr)
+		check(t, err)
+		backend, err := backendListener.Accept()
+		check(t, err)
+		return client, backend
+	}
+
+	// The client hangs up first...
+	client, backend := connect()
+	check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+	client.Close()
+	ioutil.ReadAll(backend)
+	backend.Close()
+	waitForRecords(t, path, 1)
+
+	// ...the backend hangs up first...
+	client, backend = connect()
+	check(t, assertSendAndReceiveMessage(backend, client, "hello!"))
+	backend.Close()
+	ioutil.ReadAll(client)
+	client.Close()
+	waitForRecords(t, path, 2)
+
+	// ...or the proxy shuts down.
+	client, backend = connect()
+	defer client.Close()
+	defer backend.Close()
+	tcpProxy.Shutdown()
+	<-tcpProxy.exitc
+
+	records := readRecords(t, path)
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %v", records)
+	}
+	ids := make(map[interface{}]bool)
+	for i, expected := range []struct {
+		reason   string
+		bytesIn  float64
+		bytesOut float64
+	}{
+		{closedClientEOF, 3, 0},
+		{closedBackendEOF, 0, 6},
+		{closedShutdown, 0, 0},
+	} {
+		r := records[i]
+		if r["reason"] != expected.reason || r["bytes_in"] != expected.bytesIn || r["bytes_out"] != expected.bytesOut {
+			t.Errorf("expected record %d to be %s with %v/%v bytes in/out, got %v", i, expected.reason, expected.bytesIn, expected.bytesOut, r)
+		}
+		if r["frontend"] != "default" || r["pool"] != "default" || r["backend"] != backendListener.Addr().String() || r["listener"] != laddr {
+			t.Errorf("unexpected record %v", r)
+		}
+		if _, ok := r["duration_ms"].(float64); !ok {
+			t.Errorf("expected record %d to have a duration, got %v", i, r)
+		}
+		ids[r["id"]] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("expected every connection to have a unique ID, got %v", ids)
+	}
+}
+
+func TestAccessLogFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access-log")
+	check(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+
+	// Nothing listens on the backend's address.
+	backendListener := proxytesting.NewLocalListener(t)
+	backendAddr := backendListener.Addr().String()
+	backendListener.Close()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Laddr:       "localhost:0",
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", Backends: []string{backendAddr}}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+		AccessLog: AccessLogConfig{File: logger.FileConfig{Path: path}, Format: logger.JSON_FORMAT},
+	})
+	check(t, err)
+	check(t, tcpProxy.Start())
+	defer tcpProxy.Shutdown()
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	ioutil.ReadAll(client)
+	waitForRecords(t, path, 1)
+
+	r := readRecords(t, path)[0]
+	if r["reason"] != closedError || r["backend"] != backendAddr || r["error"] == nil {
+		t.Errorf("expected a failure to dial to be recorded as an error, got %v", r)
+	}
+}
+
+func waitForRecords(t *testing.T, path string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(readRecords(t, path)) < n {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if records := readRecords(t, path); len(records) < n {
+		t.Fatalf("expected %d records, got %v", n, records)
+	}
+}
+
+func readRecords(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	check(t, err)
+	defer f.Close()
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r map[string]interface{}
+		check(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	return records
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/acl"
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestACL(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	f, err := ioutil.TempFile("", "acl")
+	check(t, err)
+	defer os.Remove(f.Name())
+	check(t, ioutil.WriteFile(f.Name(), []byte("deny 127.0.0.0/8\ndeny ::1\n"), 0644))
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Laddr:       "localhost:0",
+			Timeout:     1 * time.Second,
+			ACL:         acl.Config{File: f.Name()},
+			Pools:       []PoolConfig{{Name: "default", Backends: []string{backendListener.Addr().String()}}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	// Denied clients are closed before a backend is chosen.
+	denied, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer denied.Close()
+	denied.SetReadDeadline(time.Now().Add(1 * time.Second))
+	_, err = ioutil.ReadAll(denied)
+	check(t, err)
+
+	stats := tcpProxy.Stats()
+	assertMetric(t, stats, "rejected.denied", uint64(1))
+	assertMetric(t, stats, "frontend.default.rejected.denied", uint64(1))
+
+	// An invalid list is ignored on reload.
+	check(t, ioutil.WriteFile(f.Name(), []byte("allow 127.0.0.0/33\n"), 0644))
+	if tcpProxy.Reload() == nil {
+		t.Error("expected invalid access control list to fail to reload")
+	}
+
+	// A valid one takes effect.
+	check(t, ioutil.WriteFile(f.Name(), []byte("allow 127.0.0.0/8\nallow ::1\n"), 0644))
+	check(t, tcpProxy.Reload())
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+}
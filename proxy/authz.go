@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// identityRule authorizes a client certificate by one of its identities.
+// Rules are written as TYPE:PATTERN, where TYPE is one of
+//
+//	subject - the full subject distinguished name
+//	cn      - the subject common name
+//	dns     - a DNS SAN
+//	uri     - a URI SAN, e.g. a SPIFFE ID
+//
+// and * in PATTERN matches any sequence of characters.
+type identityRule struct {
+	kind    string
+	pattern string
+}
+
+func parseIdentityRule(s string) (identityRule, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return identityRule{}, errors.Errorf("invalid identity rule %q", s)
+	}
+	switch parts[0] {
+	case "subject", "cn", "dns", "uri":
+		return identityRule{parts[0], parts[1]}, nil
+	default:
+		return identityRule{}, errors.Errorf("invalid identity type %q in rule %q", parts[0], s)
+	}
+}
+
+func (r identityRule) String() string {
+	return r.kind + ":" + r.pattern
+}
+
+func (r identityRule) matches(cert *x509.Certificate) bool {
+	switch r.kind {
+	case "subject":
+		return globMatch(r.pattern, cert.Subject.String())
+	case "cn":
+		return globMatch(r.pattern, cert.Subject.CommonName)
+	case "dns":
+		for _, name := range cert.DNSNames {
+			if globMatch(r.pattern, name) {
+				return true
+			}
+		}
+	case "uri":
+		for _, uri := range cert.URIs {
+			if globMatch(r.pattern, uri.String()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authorizer allows a client into a pool
+// if its certificate matches any rule.
+type authorizer struct {
+	rules []identityRule
+}
+
+func newAuthorizer(rules []string) (*authorizer, error) {
+	a := &authorizer{make([]identityRule, 0, len(rules))}
+	for _, s := range rules {
+		rule, err := parseIdentityRule(s)
+		if err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, rule)
+	}
+	return a, nil
+}
+
+func (a *authorizer) enabled() bool {
+	return len(a.rules) > 0
+}
+
+// authorize returns an error describing why
+// the certificate was refused, if it was.
+func (a *authorizer) authorize(certs []*x509.Certificate) error {
+	if !a.enabled() {
+		return nil
+	}
+	if len(certs) == 0 {
+		return errors.New("no client certificate")
+	}
+	for _, rule := range a.rules {
+		if rule.matches(certs[0]) {
+			return nil
+		}
+	}
+	return errors.Errorf("certificate %q matches no identity rule", certs[0].Subject.String())
+}
+
+// globMatch reports whether s matches pattern, where
+// * matches any sequence of characters, including none.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
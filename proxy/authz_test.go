@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		matches bool
+	}{
+		{"db-client", "db-client", true},
+		{"db-client", "db-client2", false},
+		{"*", "", true},
+		{"*.internal", "db.internal", true},
+		{"*.internal", "internal", false},
+		{"spiffe://example.org/ns/prod/*", "spiffe://example.org/ns/prod/sa/db", true},
+		{"spiffe://example.org/ns/prod/*", "spiffe://example.org/ns/dev/sa/db", false},
+		{"spiffe://*/sa/db", "spiffe://example.org/ns/prod/sa/db", true},
+		{"a*b*a", "aba", true},
+		{"ab*ba", "aba", false},
+	}
+	for _, c := range cases {
+		if globMatch(c.pattern, c.s) != c.matches {
+			t.Errorf("expected globMatch(%q, %q) to be %v", c.pattern, c.s, c.matches)
+		}
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://example.org/ns/prod/sa/db")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "db-client", Organization: []string{"example"}},
+		DNSNames: []string{"db-client.internal"},
+		URIs:     []*url.URL{spiffeID},
+	}
+
+	allowed := [][]string{
+		{"cn:db-client"},
+		{"subject:CN=db-client,O=example"},
+		{"dns:*.internal"},
+		{"uri:spiffe://example.org/ns/prod/*"},
+		{"cn:web", "uri:spiffe://example.org/*"},
+	}
+	for _, rules := range allowed {
+		a, err := newAuthorizer(rules)
+		check(t, err)
+		if err := a.authorize([]*x509.Certificate{cert}); err != nil {
+			t.Errorf("expected %v to authorize certificate: %v", rules, err)
+		}
+	}
+
+	denied := [][]string{
+		{"cn:web"},
+		{"uri:spiffe://example.org/ns/dev/*"},
+	}
+	for _, rules := range denied {
+		a, err := newAuthorizer(rules)
+		check(t, err)
+		if err := a.authorize([]*x509.Certificate{cert}); err == nil {
+			t.Errorf("expected %v to refuse certificate", rules)
+		}
+	}
+
+	a, err := newAuthorizer([]string{"cn:db-client"})
+	check(t, err)
+	if err := a.authorize(nil); err == nil {
+		t.Error("expected clients without certificates to be refused")
+	}
+
+	_, err = newAuthorizer([]string{"email:*"})
+	if err == nil {
+		t.Error("expected an error for an unknown identity type")
+	}
+}
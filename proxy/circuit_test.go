@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/metrics"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestCircuitBreakerFailsFast(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	tcpProxy := newSimpleTCPProxy(t, []string{backendListener.Addr().String()})
+	pool := tcpProxy.frontends[0].pools[0]
+	pool.cfg.MaxConnsPerBackend = 1
+	pool.registry.SetLimits(pool.limits())
+
+	err := tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+
+	// The backend is at its limit, so the next client is closed.
+	rejected, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(1 * time.Second))
+	_, err = ioutil.ReadAll(rejected)
+	check(t, err)
+
+	stats := tcpProxy.Stats()
+	backendMetricPrefix := "frontend.default.pool.default.backend." + metrics.SanitizeSegment(backendListener.Addr().String()) + "."
+	assertMetric(t, stats, "rejected.circuit_open", uint64(1))
+	assertMetric(t, stats, backendMetricPrefix+"circuit", "OPEN")
+	assertMetric(t, stats, backendMetricPrefix+"circuit_trips", uint64(1))
+
+	// The circuit closes once the connection does.
+	client.Close()
+	backend.Close()
+	time.Sleep(50 * time.Millisecond)
+	stats = tcpProxy.Stats()
+	assertMetric(t, stats, backendMetricPrefix+"circuit", "CLOSED")
+	assertMetric(t, stats, backendMetricPrefix+"active_connections", uint64(0))
+}
+
+func TestCircuitBreakerQueues(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	tcpProxy := newSimpleTCPProxy(t, []string{backendListener.Addr().String()})
+	pool := tcpProxy.frontends[0].pools[0]
+	pool.cfg.MaxConnsPerBackend = 1
+	pool.cfg.QueueTimeout = 2 * time.Second
+	pool.registry.SetLimits(pool.limits())
+
+	err := tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+
+	// The next client waits for the first one to finish.
+	queued, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer queued.Close()
+	time.Sleep(100 * time.Millisecond)
+	client.Close()
+	backend.Close()
+
+	backend, err = backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(queued, backend, "finally!"))
+	assertMetric(t, tcpProxy.Stats(), "rejected.circuit_open", nil)
+}
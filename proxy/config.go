@@ -1,17 +1,102 @@
 package proxy
 
 import (
+	"os"
 	"time"
 
+	"github.com/jmuia/tcp-proxy/acl"
+	"github.com/jmuia/tcp-proxy/discovery"
 	"github.com/jmuia/tcp-proxy/health"
 	"github.com/jmuia/tcp-proxy/loadbalancer"
+	"github.com/jmuia/tcp-proxy/metrics"
+	"github.com/jmuia/tcp-proxy/ratelimit"
 )
 
 type Config struct {
-	Laddr       string
-	Timeout     time.Duration
-	Backends    []string
+	Frontends   []FrontendConfig
 	Health      health.HealthCheckConfig
-	Lb          loadbalancer.Config
 	GracePeriod time.Duration
+	AccessLog   AccessLogConfig
+	Statsd      metrics.StatsdConfig
+}
+
+// FrontendConfig is a named listener and the pools
+// of backends its connections are proxied to.
+//
+// Network is tcp (the default), udp or unix. UDP clients are
+// tracked as sessions by source address. A Laddr of the form
+// unix:/path/to.sock listens on a Unix domain socket created
+// with SocketMode permissions, if set.
+//
+// Connections and UDP sessions are closed after IdleTimeout
+// without traffic in either direction. Connections are also
+// closed if the client doesn't send anything within
+// FirstByteTimeout, or once they're older than MaxLifetime.
+// Zero disables a timeout, except for UDP sessions, whose
+// IdleTimeout defaults to 30s. Connections with timeouts
+// are copied in userspace rather than spliced. Once one side
+// of a connection has half-closed it, it's closed after
+// HalfCloseTimeout (1m by default) without traffic from the
+// other side.
+//
+// RateLimit limits how often, and how many, clients connect.
+// ACL restricts which client IPs may connect at all.
+type FrontendConfig struct {
+	Name             string
+	Network          string
+	Laddr            string
+	SocketMode       os.FileMode
+	IdleTimeout      time.Duration
+	FirstByteTimeout time.Duration
+	MaxLifetime      time.Duration
+	HalfCloseTimeout time.Duration
+	RateLimit        ratelimit.Config
+	ACL              acl.Config
+	Timeout          time.Duration
+	HandshakeTimeout time.Duration
+	TLS              TLSConfig
+	Pools            []PoolConfig
+	DefaultPool      string
+	Lb               loadbalancer.Config
+}
+
+// PoolConfig is a named group of backends, which may be
+// unix:/path/to.sock addresses. Connections whose
+// TLS ClientHello requests a server name matching one of SNI
+// are routed to the pool. Patterns are either exact names or
+// wildcards of the form *.example.com.
+//
+// If the frontend terminates TLS, Authorize restricts the pool
+// to clients whose certificates match an identity rule.
+//
+// Each backend is sent at most MaxConnsPerBackend connections, of
+// which at most MaxPendingPerBackend may be dialing, if set. Once
+// every backend is at its limits, clients are closed, or wait up
+// to QueueTimeout for a backend to free up.
+//
+// Backends may also be dns:NAME:PORT or srv:_SERVICE._PROTO.NAME
+// names, which are resolved with Resolver (the system's by default)
+// into a backend per address every DiscoveryInterval, or sooner if
+// their TTLs are shorter.
+//
+// Backends may also be discovered from DiscoveryFile, a JSON or
+// YAML file of endpoints that's reread as it changes, and every
+// DiscoveryInterval, and from the service instances at Catalog, a
+// Consul-style health endpoint URL (see discovery.CatalogWatcher).
+// The pool's backends are the union of every source's, changed at
+// most once per ReconcileInterval (backend.DefaultReconcileInterval
+// if 0).
+type PoolConfig struct {
+	Name                 string
+	Backends             []string
+	Resolver             discovery.Resolver
+	DiscoveryFile        string
+	DiscoveryInterval    time.Duration
+	Catalog              string
+	ReconcileInterval    time.Duration
+	SNI                  []string
+	Authorize            []string
+	MaxConnsPerBackend   uint64
+	MaxPendingPerBackend uint64
+	QueueTimeout         time.Duration
 }
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+const copyBufferSize = 32 * 1024
+
+// How many bytes are spliced between progress reports.
+const spliceChunkSize = 64 * 1024
+
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// copyConn copies from src to dst until EOF or an error,
+// returning the number of bytes copied. TCP pairs are
+// spliced in the kernel where supported (see canSplice);
+// anything else is copied through a pooled buffer.
+//
+// If progress isn't nil, it's called with the bytes copied
+// as they're written: after each buffer or, when splicing,
+// each spliceChunkSize bytes and whatever's left at the end.
+func copyConn(dst net.Conn, src net.Conn, progress func(int64)) (int64, error) {
+	var written int64
+
+	// Forward bytes that were peeked at, then
+	// copy directly from the underlying conn.
+	if pc, ok := src.(*peekedConn); ok {
+		n, err := pc.writeBuffered(dst)
+		written += n
+		if n > 0 && progress != nil {
+			progress(n)
+		}
+		if err != nil {
+			return written, err
+		}
+		src = pc.Conn
+	}
+
+	if canSplice(dst, src) {
+		rf := dst.(io.ReaderFrom)
+		if progress == nil {
+			n, err := rf.ReadFrom(src)
+			return written + n, err
+		}
+		// A LimitedReader is still spliced.
+		for {
+			n, err := rf.ReadFrom(&io.LimitedReader{R: src, N: spliceChunkSize})
+			written += n
+			if n > 0 {
+				progress(n)
+			}
+			if err != nil || n < spliceChunkSize {
+				return written, err
+			}
+		}
+	}
+
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	// Hide ReaderFrom and WriterTo so io.CopyBuffer uses buf
+	// rather than allocating a buffer of its own.
+	var w io.Writer = writerOnly{dst}
+	if progress != nil {
+		w = progressWriter{dst, progress}
+	}
+	n, err := io.CopyBuffer(w, readerOnly{src}, *buf)
+	return written + n, err
+}
+
+type readerOnly struct {
+	io.Reader
+}
+
+type writerOnly struct {
+	io.Writer
+}
+
+// progressWriter reports each write's bytes to progress.
+type progressWriter struct {
+	w        io.Writer
+	progress func(int64)
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	if n > 0 {
+		w.progress(int64(n))
+	}
+	return n, err
+}
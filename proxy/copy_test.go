@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// opaqueConn hides the concrete type of a
+// conn, forcing copies onto the buffered path.
+type opaqueConn struct {
+	net.Conn
+}
+
+func TestCopyConn(t *testing.T) {
+	copies := map[string]func(dst net.Conn, src net.Conn, progress func(int64)) (int64, error){
+		"splice": copyConn,
+		"buffered": func(dst net.Conn, src net.Conn, progress func(int64)) (int64, error) {
+			return copyConn(opaqueConn{dst}, opaqueConn{src}, progress)
+		},
+	}
+
+	for name, copy := range copies {
+		src, srcPeer := newTCPPair(t)
+		dst, dstPeer := newTCPPair(t)
+
+		// Peek at the first bytes, as SNI routing does.
+		peeked := newPeekedConn(src)
+		go func() {
+			io.WriteString(srcPeer, "hello, world!")
+			srcPeer.Close()
+		}()
+		_, err := peeked.r.Peek(len("hello"))
+		check(t, err)
+
+		var reported int64
+		n, err := copy(dst, peeked, func(n int64) {
+			reported += n
+		})
+		check(t, err)
+		dst.Close()
+		if n != int64(len("hello, world!")) {
+			t.Errorf("%s: expected to copy %d bytes, copied %d", name, len("hello, world!"), n)
+		}
+		if reported != n {
+			t.Errorf("%s: expected progress to report %d bytes, reported %d", name, n, reported)
+		}
+
+		received, err := ioutil.ReadAll(dstPeer)
+		check(t, err)
+		if string(received) != "hello, world!" {
+			t.Errorf("%s: expected %q, received %q", name, "hello, world!", received)
+		}
+
+		src.Close()
+		dstPeer.Close()
+	}
+}
+
+func TestCopyConnReportsProgress(t *testing.T) {
+	src, srcPeer := newTCPPair(t)
+	defer src.Close()
+	dst, dstPeer := newTCPPair(t)
+	defer dstPeer.Close()
+
+	size := 3*spliceChunkSize + 10
+	go func() {
+		srcPeer.Write(make([]byte, size))
+		srcPeer.Close()
+	}()
+	go io.Copy(ioutil.Discard, dstPeer)
+
+	// Spliced copies are reported a chunk at a time.
+	var reported int64
+	var reports int
+	n, err := copyConn(dst, src, func(n int64) {
+		reported += n
+		reports++
+	})
+	check(t, err)
+	dst.Close()
+	if n != int64(size) || reported != n {
+		t.Errorf("expected to copy and report %d bytes, copied %d and reported %d", size, n, reported)
+	}
+	if reports < 4 {
+		t.Errorf("expected at least 4 progress reports, got %d", reports)
+	}
+}
+
+// BenchmarkCopyConn compares the previous io.Copy with copyConn's
+// splice and pooled buffer paths. Run with -benchtime to copy more
+// data; cpu-ms/GB includes the sending and receiving goroutines.
+func BenchmarkCopyConn(b *testing.B) {
+	b.Run("io.Copy", func(b *testing.B) {
+		benchmarkCopy(b, func(dst net.Conn, src net.Conn) (int64, error) {
+			return io.Copy(dst, src)
+		})
+	})
+	b.Run("io.Copy/opaque", func(b *testing.B) {
+		benchmarkCopy(b, func(dst net.Conn, src net.Conn) (int64, error) {
+			return io.Copy(opaqueConn{dst}, opaqueConn{src})
+		})
+	})
+	b.Run("copyConn/splice", func(b *testing.B) {
+		benchmarkCopy(b, func(dst net.Conn, src net.Conn) (int64, error) {
+			return copyConn(dst, src, nil)
+		})
+	})
+	b.Run("copyConn/buffered", func(b *testing.B) {
+		benchmarkCopy(b, func(dst net.Conn, src net.Conn) (int64, error) {
+			return copyConn(opaqueConn{dst}, opaqueConn{src}, nil)
+		})
+	})
+}
+
+func benchmarkCopy(b *testing.B, copy func(dst net.Conn, src net.Conn) (int64, error)) {
+	const chunk = 64 * 1024
+
+	src, srcPeer := newTCPPair(b)
+	defer src.Close()
+	dst, dstPeer := newTCPPair(b)
+	defer dstPeer.Close()
+
+	go func() {
+		buf := make([]byte, chunk)
+		for i := 0; i < b.N; i++ {
+			srcPeer.Write(buf)
+		}
+		srcPeer.Close()
+	}()
+	donec := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, dstPeer)
+		close(donec)
+	}()
+
+	b.SetBytes(chunk)
+	b.ResetTimer()
+	start := cpuTime()
+
+	n, err := copy(dst, src)
+	dst.Close()
+	<-donec
+
+	b.StopTimer()
+	cpu := cpuTime() - start
+	if err != nil {
+		b.Fatal(err)
+	}
+	if n != int64(b.N)*chunk {
+		b.Fatalf("expected to copy %d bytes, copied %d", int64(b.N)*chunk, n)
+	}
+	if cpu > 0 {
+		b.ReportMetric(float64(cpu.Nanoseconds())/1e6/(float64(n)/1e9), "cpu-ms/GB")
+	}
+}
+
+// newTCPPair returns both ends of a loopback TCP connection.
+func newTCPPair(tb testing.TB) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return server, client
+}
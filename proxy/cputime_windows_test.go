@@ -0,0 +1,10 @@
+package proxy
+
+import (
+	"time"
+)
+
+// cpuTime isn't measured on Windows.
+func cpuTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	"github.com/jmuia/tcp-proxy/metrics"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestDiscoveryFile(t *testing.T) {
+	oldListener := proxytesting.NewLocalListener(t)
+	defer oldListener.Close()
+	newListener := proxytesting.NewLocalListener(t)
+	defer newListener.Close()
+
+	dir, err := ioutil.TempDir("", "discovery")
+	check(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "endpoints.json")
+	check(t, ioutil.WriteFile(path, []byte(`["`+oldListener.Addr().String()+`"]`), 0644))
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Laddr:       "localhost:0",
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", DiscoveryFile: path, DiscoveryInterval: 20 * time.Millisecond, ReconcileInterval: 10 * time.Millisecond}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+	assertMetric(t, tcpProxy.Stats(), "frontend.default.pool.default.backend."+metrics.SanitizeSegment(oldListener.Addr().String())+".state", "HEALTHY")
+
+	// Backends follow the file, without a reload.
+	check(t, ioutil.WriteFile(path, []byte(`["`+newListener.Addr().String()+`"]`), 0644))
+	time.Sleep(200 * time.Millisecond)
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := newListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+	assertMetric(t, tcpProxy.Stats(), "frontend.default.pool.default.backend."+metrics.SanitizeSegment(newListener.Addr().String())+".active_connections", uint64(1))
+
+	// The removed backend's metrics go with it.
+	oldPrefix := "frontend.default.pool.default.backend." + metrics.SanitizeSegment(oldListener.Addr().String()) + "."
+	waitForMetric(t, tcpProxy, oldPrefix+"state", nil)
+
+	// But not while it still has connections.
+	newPrefix := "frontend.default.pool.default.backend." + metrics.SanitizeSegment(newListener.Addr().String()) + "."
+	check(t, ioutil.WriteFile(path, []byte(`["`+oldListener.Addr().String()+`"]`), 0644))
+	waitForMetric(t, tcpProxy, newPrefix+"state", "REMOVED")
+	time.Sleep(2 * untrackPollInterval)
+	assertMetric(t, tcpProxy.Stats(), newPrefix+"active_connections", uint64(1))
+	client.Close()
+	backend.Close()
+	waitForMetric(t, tcpProxy, newPrefix+"state", nil)
+	waitForMetric(t, tcpProxy, newPrefix+"io.rx", nil)
+}
+
+// hostsResolver resolves names from a table.
+type hostsResolver map[string][]string
+
+func (r hostsResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	if addrs, exists := r[host]; exists {
+		return addrs, 0, nil
+	}
+	return nil, 0, &net.DNSError{Err: "no such host", Name: host}
+}
+
+func (r hostsResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	return nil, 0, &net.DNSError{Err: "no such host", Name: name}
+}
+
+func TestDNSBackends(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+	_, port, err := net.SplitHostPort(backendListener.Addr().String())
+	check(t, err)
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:    "default",
+			Laddr:   "localhost:0",
+			Timeout: 1 * time.Second,
+			Pools: []PoolConfig{{
+				Name:     "default",
+				Backends: []string{"dns:app.test:" + port},
+				Resolver: hostsResolver{"app.test": {"127.0.0.1"}},
+			}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	// The name is expanded into a backend per address.
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+	assertMetric(t, tcpProxy.Stats(), "frontend.default.pool.default.backend.127_0_0_1:"+port+".active_connections", uint64(1))
+}
+
+func TestCatalog(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+	host, port, err := net.SplitHostPort(backendListener.Addr().String())
+	check(t, err)
+
+	catalog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/web" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		fmt.Fprintf(w, `[{"Node": {"Address": %q}, "Service": {"Port": %s, "Weights": {"Passing": 1}}}]`, host, port)
+	}))
+	defer catalog.Close()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Laddr:       "localhost:0",
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", Catalog: catalog.URL + "/v1/health/service/web"}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/metrics"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestDrain(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+	addr := backendListener.Addr().String()
+
+	tcpProxy := newSimpleTCPProxy(t, []string{addr})
+	err := tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+
+	check(t, tcpProxy.Drain(addr))
+	if tcpProxy.Drain("localhost:1") == nil {
+		t.Error("expected draining an unknown backend to fail")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assertMetric(t, tcpProxy.Stats(), "frontend.default.pool.default.backend."+metrics.SanitizeSegment(addr)+".state", "DRAINING")
+
+	// Existing connections are unaffected...
+	check(t, assertSendAndReceiveMessage(client, backend, "still here"))
+
+	// ...but new ones aren't sent to the backend.
+	rejected, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(1 * time.Second))
+	_, err = ioutil.ReadAll(rejected)
+	check(t, err)
+
+	// The backend is removed once its connection closes.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		client.Close()
+		backend.Close()
+	}()
+	drained, err := tcpProxy.RemoveBackend(addr, 5*time.Second)
+	check(t, err)
+	if !drained {
+		t.Error("expected backend to drain before it was removed")
+	}
+	assertMetric(t, tcpProxy.Stats(), "frontend.default.pool.default.backend."+metrics.SanitizeSegment(addr)+".state", "REMOVED")
+}
@@ -0,0 +1,567 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/acl"
+	"github.com/jmuia/tcp-proxy/health"
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/jmuia/tcp-proxy/metrics"
+	"github.com/jmuia/tcp-proxy/netaddr"
+	"github.com/jmuia/tcp-proxy/ratelimit"
+	"github.com/pkg/errors"
+)
+
+// frontend is a listener and the backend pools
+// that connections accepted on it are proxied to.
+type frontend struct {
+	cfg       FrontendConfig
+	ln        net.Listener
+	pc        net.PacketConn
+	sessions  *udpSessions
+	pools     []*pool
+	router    *router
+	tlsConfig *tls.Config
+	limiter   *ratelimit.Limiter
+	// Holds the current *acl.List, if any.
+	access atomic.Value
+	stats  *frontendStats
+	log    *logger.Logger
+	// Records each connection once it closes, if enabled.
+	accessLog *logger.Logger
+	conns     *activeConns
+}
+
+func newFrontend(cfg FrontendConfig, healthCfg health.HealthCheckConfig, stats *proxyStats, accessLog *logger.Logger) (*frontend, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("frontend for " + cfg.Laddr + " has no name")
+	}
+	if cfg.Network == "" || cfg.Network == "tcp" {
+		cfg.Network, cfg.Laddr = netaddr.Split(cfg.Laddr, "tcp")
+	}
+	switch cfg.Network {
+	case "tcp", "udp", "unix":
+	default:
+		return nil, errors.New("frontend " + cfg.Name + " has unsupported network " + cfg.Network)
+	}
+	if cfg.Network == "udp" && cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultUDPIdleTimeout
+	}
+	if cfg.HalfCloseTimeout <= 0 {
+		cfg.HalfCloseTimeout = defaultHalfCloseTimeout
+	}
+
+	log := logger.With("frontend", cfg.Name)
+	pools := make([]*pool, 0, len(cfg.Pools))
+	for _, poolCfg := range cfg.Pools {
+		p, err := newPool(poolCfg, cfg.Network, cfg.Lb, healthCfg, log)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, p)
+	}
+
+	router, err := newRouter(pools, cfg.DefaultPool)
+	if err != nil {
+		return nil, errors.Wrapf(err, "frontend %s", cfg.Name)
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.TLS.enabled() {
+		tlsConfig, err = newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, errors.Wrapf(err, "frontend %s", cfg.Name)
+		}
+	}
+	for _, p := range pools {
+		if p.authorizer.enabled() && (tlsConfig == nil || tlsConfig.ClientCAs == nil) {
+			return nil, errors.New("pool " + p.name() + " authorizes clients but client certificates aren't verified")
+		}
+	}
+	if cfg.Network == "udp" && (tlsConfig != nil || router.sniEnabled()) {
+		return nil, errors.New("frontend " + cfg.Name + " can't use TLS or SNI routing over UDP")
+	}
+
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit.Enabled() {
+		if cfg.Network == "udp" && cfg.RateLimit.Policy == ratelimit.DELAY_POLICY {
+			return nil, errors.New("frontend " + cfg.Name + " can't delay UDP sessions")
+		}
+		limiter = ratelimit.NewLimiter(cfg.RateLimit)
+	}
+
+	f := &frontend{
+		cfg:       cfg,
+		pools:     pools,
+		router:    router,
+		tlsConfig: tlsConfig,
+		limiter:   limiter,
+		stats:     newFrontendStats(cfg.Name, stats),
+		log:       log,
+		accessLog: accessLog,
+		conns:     newActiveConns(),
+	}
+	err = f.reloadACL()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *frontend) name() string {
+	return f.cfg.Name
+}
+
+// listen binds the frontend's address and registers its backends.
+func (f *frontend) listen() error {
+	var err error
+	switch f.cfg.Network {
+	case "udp":
+		f.pc, err = net.ListenPacket("udp", f.cfg.Laddr)
+		if err == nil {
+			f.sessions = newUDPSessions()
+			f.stats.sessionsGauge(f.sessions)
+		}
+	case "unix":
+		f.ln, err = listenUnix(f.cfg.Laddr, f.cfg.SocketMode)
+	default:
+		f.ln, err = net.Listen("tcp", f.cfg.Laddr)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", f.cfg.Laddr)
+	}
+
+	f.log.Infow("listening", "network", f.cfg.Network, "addr", f.addr())
+
+	for _, p := range f.pools {
+		err = p.addBackends(f.stats)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *frontend) addr() net.Addr {
+	if f.pc != nil {
+		return f.pc.LocalAddr()
+	}
+	return f.ln.Addr()
+}
+
+// serve handles clients until shutdownc is closed,
+// returning an error if the listener fails.
+func (f *frontend) serve(shutdownc <-chan struct{}) error {
+	if f.pc != nil {
+		return f.servePackets(shutdownc)
+	}
+	return f.acceptConns(shutdownc)
+}
+
+// stopListening closes the listener, reporting
+// whether the frontend had been listening.
+func (f *frontend) stopListening() bool {
+	switch {
+	case f.pc != nil:
+		f.pc.Close()
+		return true
+	case f.ln != nil:
+		f.ln.Close()
+		return true
+	}
+	return false
+}
+
+// close ends any connections and sessions
+// and removes the frontend's backends.
+func (f *frontend) close() {
+	if f.limiter != nil {
+		f.limiter.Close()
+	}
+	f.conns.closeAll()
+	if f.sessions != nil {
+		f.sessions.closeAll()
+	}
+	for _, p := range f.pools {
+		p.close()
+	}
+}
+
+// reloadACL loads the frontend's access control list,
+// keeping the current one if the new one is invalid.
+func (f *frontend) reloadACL() error {
+	if !f.cfg.ACL.Enabled() {
+		return nil
+	}
+	list, err := acl.Load(f.cfg.ACL)
+	if err != nil {
+		return errors.Wrapf(err, "invalid access control list for frontend %s", f.name())
+	}
+	f.access.Store(list)
+	f.log.Infow("loaded access control list", "rules", list.Len())
+	return nil
+}
+
+// checkAccess applies the access control list to a client.
+// Clients without an IP, on Unix sockets, are always allowed.
+func (f *frontend) checkAccess(client net.Addr) error {
+	list, _ := f.access.Load().(*acl.List)
+	ip := clientIP(client)
+	if list == nil || ip == nil {
+		return nil
+	}
+	allowed, rule := list.Check(ip)
+	if !allowed {
+		return &rejection{"denied", errors.New("denied by " + rule)}
+	}
+	f.log.Debugw("allowed", "client", client, "rule", rule)
+	return nil
+}
+
+// deadlineListener is implemented by
+// *net.TCPListener and *net.UnixListener.
+type deadlineListener interface {
+	net.Listener
+	SetDeadline(t time.Time) error
+}
+
+func (f *frontend) acceptTimeout(timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	err := f.ln.(deadlineListener).SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+	return f.ln.Accept()
+}
+
+func (f *frontend) acceptConns(shutdownc <-chan struct{}) error {
+	// TODO: use a worker pool to limit concurrency.
+	// TODO: don't accept connections if there aren't any
+	//       healthy backends to proxy to.
+	for {
+		select {
+		case <-shutdownc:
+			return nil
+		default:
+			// Accept() is blocking. Adds a timeout
+			// to ensure we're still checking for
+			// shutdown messages if the proxy is idle.
+			src, err := f.acceptTimeout(3 * time.Second)
+			if isTimeout(err) {
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to accept on %s", f.name())
+			}
+			r := f.newConnRecord(src.RemoteAddr())
+			f.log.Debugw("accepted connection", "id", r.id, "client", src.RemoteAddr())
+			f.stats.incrRequests()
+			err = f.checkAccess(src.RemoteAddr())
+			if err != nil {
+				f.logFailure(src.RemoteAddr(), err)
+				f.logAccess(r, err)
+				src.Close()
+				continue
+			}
+			go f.handleConn(src, r)
+		}
+	}
+}
+
+func (f *frontend) handleConn(src net.Conn, r *connRecord) {
+	release, err := f.limit(src.RemoteAddr())
+	if err != nil {
+		f.logFailure(src.RemoteAddr(), err)
+		f.logAccess(r, err)
+		src.Close()
+		return
+	}
+	defer release()
+
+	src, pool, err := f.routeConn(src)
+	if err != nil {
+		f.logFailure(src.RemoteAddr(), err)
+		f.logAccess(r, err)
+		src.Close()
+		return
+	}
+	r.pool = pool.name()
+
+	backend, err := pool.nextBackend(src)
+	if err != nil {
+		f.logFailure(src.RemoteAddr(), err)
+		f.logAccess(r, err)
+		src.Close()
+		return
+	}
+	r.backend = backend.Addr()
+
+	network, address := netaddr.Split(backend.Addr(), "tcp")
+	f.stats.incrBackendRequests(pool, backend.Addr())
+	dialStart := time.Now()
+	dst, err := net.DialTimeout(network, address, f.cfg.Timeout)
+	r.dialTime = time.Since(dialStart)
+	backend.EndDial(err == nil)
+	if err != nil {
+		pool.release()
+		// TODO: attempt a different backend.
+		f.log.Errorw("error dialing backend", "id", r.id, "client", src.RemoteAddr(), "backend", backend.Addr(), "error", err)
+		f.stats.incrErrors()
+		f.stats.incrBackendErrors(pool, backend.Addr())
+		f.logAccess(r, err)
+		src.Close()
+		return
+	}
+	defer func() {
+		backend.DecrActiveConns()
+		pool.release()
+	}()
+
+	f.log.Debugw("opened connection", "id", r.id, "client", src.RemoteAddr(), "backend", backend.Addr(), "active", backend.ActiveConns())
+
+	// proxyConn will close the connections.
+	r.src, r.dst = src, dst
+	f.conns.add(r)
+	defer f.conns.remove(r)
+	err = f.proxyConn(r, f.stats.frontendIoMeters(), f.stats.backendIoMeters(pool, backend.Addr()))
+	if err != nil {
+		f.log.Errorw("error proxying connection", "id", r.id, "client", src.RemoteAddr(), "backend", backend.Addr(), "error", err)
+		f.stats.incrErrors()
+		f.stats.incrBackendErrors(pool, backend.Addr())
+	}
+	f.logAccess(r, err)
+}
+
+// limit admits a new client within the frontend's rate limits,
+// returning a func to call once the client is done with.
+func (f *frontend) limit(client net.Addr) (func(), error) {
+	if f.limiter == nil {
+		return func() {}, nil
+	}
+	release, err := f.limiter.Acquire(clientIP(client))
+	if e, ok := err.(*ratelimit.ExceededError); ok {
+		return nil, &rejection{e.Reason, err}
+	}
+	if err == ratelimit.ErrClosed {
+		return nil, &rejection{closedShutdown, err}
+	}
+	return release, err
+}
+
+// logFailure logs and counts why a client wasn't proxied.
+func (f *frontend) logFailure(client net.Addr, err error) {
+	if r, ok := err.(*rejection); ok {
+		f.log.Warnw("rejected", "client", client, "reason", r.reason, "error", r.err)
+		f.stats.incrRejected(r.reason)
+		return
+	}
+	f.log.Errorw("failed to proxy client", "client", client, "error", err)
+	f.stats.incrErrors()
+}
+
+// clientIP returns the IP address of a client, or nil
+// if it isn't connected over IP (e.g. a Unix socket).
+func clientIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// routeConn picks the pool to proxy src to. If any pool is routed
+// by SNI, the TLS ClientHello is peeked at and the returned conn
+// must be used in place of src so the peeked bytes are forwarded.
+func (f *frontend) routeConn(src net.Conn) (net.Conn, *pool, error) {
+	if f.tlsConfig != nil {
+		return f.terminateTLS(src)
+	}
+
+	if !f.router.sniEnabled() {
+		pool, err := f.router.route("")
+		return src, pool, err
+	}
+
+	conn := newPeekedConn(src)
+	if f.cfg.HandshakeTimeout > 0 {
+		src.SetReadDeadline(time.Now().Add(f.cfg.HandshakeTimeout))
+	}
+	serverName, err := conn.peekServerName()
+	src.SetReadDeadline(time.Time{})
+
+	switch {
+	case err == errNotTLS:
+	case err == errClientHelloTooLarge:
+		f.log.Warnw("TLS ClientHello too large to route by server name", "client", src.RemoteAddr())
+	case isTimeout(err):
+		f.log.Warnw("timed out reading TLS ClientHello", "client", src.RemoteAddr())
+	case err != nil:
+		return conn, nil, errors.Wrapf(err, "error reading TLS ClientHello from %v", src.RemoteAddr())
+	}
+
+	pool, err := f.router.route(serverName)
+	if err == nil {
+		f.log.Debugw("routing", "client", src.RemoteAddr(), "server_name", serverName, "pool", pool.name())
+	}
+	return conn, pool, err
+}
+
+// terminateTLS completes the TLS handshake with src, routes by
+// the server name it requested and authorizes its certificate.
+func (f *frontend) terminateTLS(src net.Conn) (net.Conn, *pool, error) {
+	conn := tls.Server(src, f.tlsConfig)
+	if f.cfg.HandshakeTimeout > 0 {
+		src.SetReadDeadline(time.Now().Add(f.cfg.HandshakeTimeout))
+	}
+	err := conn.Handshake()
+	src.SetReadDeadline(time.Time{})
+	if err != nil {
+		return conn, nil, &rejection{"tls_handshake", err}
+	}
+
+	state := conn.ConnectionState()
+	pool, err := f.router.route(state.ServerName)
+	if err != nil {
+		return conn, nil, err
+	}
+
+	err = pool.authorizer.authorize(state.PeerCertificates)
+	if err != nil {
+		return conn, nil, &rejection{"unauthorized", errors.Wrapf(err, "pool %s", pool.name())}
+	}
+
+	f.log.Debugw("routing", "client", src.RemoteAddr(), "server_name", state.ServerName, "pool", pool.name())
+	return conn, pool, nil
+}
+
+// copyResult is the outcome of copying in one direction.
+type copyResult struct {
+	dst net.Conn
+	src net.Conn
+	err error
+}
+
+// proxyConn copies between r's connections until they close,
+// recording why they did, and marks the bytes copied on the
+// frontend's and backend's meters as it goes.
+func (f *frontend) proxyConn(r *connRecord, frontendIo *ioMeters, backendIo *ioMeters) error {
+	src, dst := r.src, r.dst
+	donec := make(chan copyResult, 2)
+	// Set once either side has half-closed the connection.
+	var halfClosed int32
+
+	copy := func(dst net.Conn, src net.Conn, tx *uint64, rx *uint64, txMeter metrics.Meter, rxMeter metrics.Meter) {
+		// Bytes are counted and metered as they're copied.
+		progress := func(n int64) {
+			atomic.AddUint64(tx, uint64(n))
+			atomic.AddUint64(rx, uint64(n))
+			txMeter.Mark(uint64(n))
+			rxMeter.Mark(uint64(n))
+		}
+		var bytes int64
+		var err error
+		for {
+			var n int64
+			n, err = copyConn(dst, src, progress)
+			bytes += n
+			// Once half-closed, reads have a deadline that's
+			// pushed back as long as data is still flowing.
+			if !isTimeout(err) || n == 0 || atomic.LoadInt32(&halfClosed) == 0 {
+				break
+			}
+			src.SetReadDeadline(time.Now().Add(f.cfg.HalfCloseTimeout))
+		}
+		if wc, ok := src.(*watchedConn); ok {
+			src = wc.Conn
+		}
+		f.log.Debugw("proxied", "bytes", bytes, "from", src.RemoteAddr(), "to", dst.RemoteAddr())
+		donec <- copyResult{dst, src, err}
+	}
+
+	// Reads are watched for traffic if any timeouts are
+	// configured, and the watchdog closes both connections
+	// once one expires.
+	watchdog := f.watchConn(src, dst)
+	srcReader, dstReader := src, dst
+	if watchdog != nil {
+		srcReader = &watchedConn{src, watchdog, true}
+		dstReader = &watchedConn{dst, watchdog, false}
+	}
+
+	stats := newProxyIoStats()
+	r.bytes = stats
+	go copy(dst, srcReader, &stats.backend.tx, &stats.frontend.rx, backendIo.tx, frontendIo.rx)
+	go copy(src, dstReader, &stats.frontend.tx, &stats.backend.rx, frontendIo.tx, backendIo.rx)
+
+	// Await an error or EOF from either goroutine.
+	// On EOF, the write side of the peer is shut down so it sees
+	// EOF too, while the other direction keeps running until it
+	// finishes or goes quiet for the half-close timeout. On error, both
+	// connections are closed, causing the other (likely blocked)
+	// goroutine to continue executing, and its error is ignored.
+	var err error
+	first := <-donec
+	r.reason = closedBackendEOF
+	if first.dst == dst {
+		r.reason = closedClientEOF
+	}
+	if first.err != nil || closeWrite(first.dst) != nil {
+		if first.err != nil {
+			err = errors.Wrapf(first.err, "error proxying data from %v to %v", first.src.RemoteAddr(), first.dst.RemoteAddr())
+		}
+		src.Close()
+		dst.Close()
+	} else {
+		atomic.StoreInt32(&halfClosed, 1)
+		first.dst.SetReadDeadline(time.Now().Add(f.cfg.HalfCloseTimeout))
+	}
+
+	// TODO: maybe select here as safeguard against blocking.
+	second := <-donec
+	if err == nil && isTimeout(second.err) && atomic.LoadInt32(&halfClosed) == 1 {
+		f.log.Debugw("closed connection", "id", r.id, "client", src.RemoteAddr(), "backend", dst.RemoteAddr(), "reason", closedHalfClose)
+		f.stats.incrClosed(closedHalfClose)
+		r.reason, r.timeout = closedTimeout, closedHalfClose
+	} else if err == nil && second.err != nil {
+		err = errors.Wrapf(second.err, "error proxying data from %v to %v", second.src.RemoteAddr(), second.dst.RemoteAddr())
+	}
+	src.Close()
+	dst.Close()
+
+	if err != nil {
+		r.reason = closedError
+	}
+	if watchdog != nil {
+		if reason := watchdog.stop(); reason != "" {
+			// Errors were caused by the watchdog closing the connections.
+			f.log.Debugw("closed connection", "id", r.id, "client", src.RemoteAddr(), "backend", dst.RemoteAddr(), "reason", reason)
+			f.stats.incrClosed(reason)
+			r.reason, r.timeout = closedTimeout, reason
+			err = nil
+		}
+	}
+	if r.wasShutdown() {
+		r.reason = closedShutdown
+		err = nil
+	}
+	return err
+}
+
+// closeWrite shuts down the writing side of conn, if it supports it.
+func closeWrite(conn net.Conn) error {
+	if pc, ok := conn.(*peekedConn); ok {
+		conn = pc.Conn
+	}
+	cw, ok := conn.(interface {
+		CloseWrite() error
+	})
+	if !ok {
+		return errors.New("half-close not supported")
+	}
+	return cw.CloseWrite()
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestPanicMode(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Laddr:       "localhost:0",
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", Backends: []string{backendListener.Addr().String()}}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE, PanicThreshold: 50},
+		}},
+	})
+	check(t, err)
+	pool := tcpProxy.frontends[0].pools[0]
+
+	err = tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+	assertMetric(t, tcpProxy.Stats(), "frontend.default.pool.default.panic", uint64(0))
+
+	// A backend whose health checks fail is still sent connections.
+	b := pool.registry.Get(backendListener.Addr().String())
+	b.SetState(backend.UNHEALTHY)
+	pool.lb.UpdateBackend(b)
+	assertMetric(t, tcpProxy.Stats(), "frontend.default.pool.default.panic", uint64(1))
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	conn, err := backendListener.Accept()
+	check(t, err)
+	defer conn.Close()
+	check(t, assertSendAndReceiveMessage(client, conn, "hi!"))
+}
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/jmuia/tcp-proxy/discovery"
+	"github.com/jmuia/tcp-proxy/health"
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/jmuia/tcp-proxy/netaddr"
+	"github.com/pkg/errors"
+)
+
+// How many times a backend is chosen before giving up on
+// a pool whose backends are being reserved concurrently.
+const maxReserveAttempts = 3
+
+// How often a removed backend is checked for its connections
+// to close, before its metrics are unregistered.
+const untrackPollInterval = 100 * time.Millisecond
+
+// pool is a named group of backends with
+// its own registry and load balancer.
+type pool struct {
+	cfg        PoolConfig
+	endpoints  []backend.Endpoint
+	lb         loadbalancer.LoadBalancer
+	registry   *backend.Registry
+	authorizer *authorizer
+	log        *logger.Logger
+	reconciler *backend.Reconciler
+	// The backends' priority tiers.
+	priorities map[int]bool
+	// Closed and replaced whenever a backend's
+	// connection is released, waking queued clients.
+	lock  sync.Mutex
+	freed chan struct{}
+}
+
+func newPool(cfg PoolConfig, network string, lbCfg loadbalancer.Config, healthCfg health.HealthCheckConfig, log *logger.Logger) (*pool, error) {
+	log = log.With("pool", cfg.Name)
+	endpoints := make([]backend.Endpoint, 0, len(cfg.Backends))
+	for _, b := range cfg.Backends {
+		e, err := backend.ParseEndpoint(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid backend for pool %s", cfg.Name)
+		}
+		if network == "udp" && netaddr.IsUnix(e.Addr) {
+			return nil, errors.New("pool " + cfg.Name + " can't proxy UDP to " + e.Addr)
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	lb, err := newLoadBalancer(lbCfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizer, err := newAuthorizer(cfg.Authorize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid authorization for pool %s", cfg.Name)
+	}
+
+	p := &pool{
+		cfg:        cfg,
+		endpoints:  endpoints,
+		lb:         lb,
+		registry:   backend.NewRegistry(healthCfg),
+		authorizer: authorizer,
+		log:        log,
+		priorities: make(map[int]bool),
+		freed:      make(chan struct{}),
+	}
+	p.registry.SetLimits(p.limits())
+	p.registry.SetLogger(p.log)
+	if network == "udp" {
+		p.registry.SetHealthCheckFactory(func(addr string, timeout time.Duration) health.HealthCheck {
+			return health.NewUDPHealthCheck(addr, timeout)
+		})
+	}
+	p.registry.RegisterUpdateListener(func(backend *backend.Backend) {
+		p.log.Infow("backend state changed", "backend", backend.Addr(), "state", backend.State().String())
+	})
+	p.registry.RegisterUpdateListener(func(backend *backend.Backend) {
+		p.lb.UpdateBackend(backend)
+	})
+	return p, nil
+}
+
+func newLoadBalancer(cfg loadbalancer.Config, log *logger.Logger) (loadbalancer.LoadBalancer, error) {
+	switch cfg.Type {
+	case loadbalancer.RANDOM_TYPE:
+		lb := loadbalancer.NewRandom()
+		lb.SetLogger(log)
+		lb.SetSlowStart(cfg.SlowStart)
+		if cfg.Overprovisioning > 0 {
+			lb.SetOverprovisioning(cfg.Overprovisioning)
+		}
+		lb.SetPanicThreshold(cfg.PanicThreshold)
+		return lb, nil
+	case loadbalancer.P2C_TYPE:
+		lb := loadbalancer.NewP2C()
+		lb.SetLogger(log)
+		lb.SetSlowStart(cfg.SlowStart)
+		if cfg.Overprovisioning > 0 {
+			lb.SetOverprovisioning(cfg.Overprovisioning)
+		}
+		lb.SetPanicThreshold(cfg.PanicThreshold)
+		return lb, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unexpected load balancer type %s", cfg.Type))
+	}
+}
+
+func (p *pool) name() string {
+	return p.cfg.Name
+}
+
+func (p *pool) limits() backend.Limits {
+	return backend.Limits{
+		MaxConns:   p.cfg.MaxConnsPerBackend,
+		MaxPending: p.cfg.MaxPendingPerBackend,
+	}
+}
+
+// addBackends starts discovering the pool's backends from
+// its static backends, dns: and srv: names, discovery file
+// and catalog, merged into its registry.
+func (p *pool) addBackends(stats *frontendStats) error {
+	stats.panicGauge(p)
+	sources, err := p.discoverers()
+	if err != nil {
+		return err
+	}
+	p.reconciler = backend.NewReconciler(p.registry, sources...)
+	if p.cfg.ReconcileInterval > 0 {
+		p.reconciler.SetInterval(p.cfg.ReconcileInterval)
+	}
+	p.reconciler.OnAdd(func(b *backend.Backend) {
+		p.track(stats, b)
+	})
+	p.reconciler.OnRemove(func(b *backend.Backend) {
+		go p.untrack(stats, b)
+	})
+	return errors.Wrapf(p.reconciler.Start(), "failed to discover backends for pool %s", p.name())
+}
+
+// discoverers returns the sources of the pool's backends.
+func (p *pool) discoverers() ([]backend.Discoverer, error) {
+	var static backend.Static
+	var names []backend.Endpoint
+	for _, e := range p.endpoints {
+		if discovery.IsDNS(e.Addr) {
+			names = append(names, e)
+		} else {
+			static = append(static, e)
+		}
+	}
+
+	var sources []backend.Discoverer
+	if len(static) > 0 {
+		sources = append(sources, static)
+	}
+	if len(names) > 0 {
+		sources = append(sources, discovery.NewDNSWatcher(names, p.cfg.Resolver, p.cfg.DiscoveryInterval))
+	}
+	if p.cfg.DiscoveryFile != "" {
+		sources = append(sources, discovery.NewFileWatcher(p.cfg.DiscoveryFile, p.cfg.DiscoveryInterval))
+	}
+	if p.cfg.Catalog != "" {
+		catalog, err := discovery.NewCatalogWatcher(p.cfg.Catalog, 0)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid catalog for pool %s", p.name())
+		}
+		sources = append(sources, catalog)
+	}
+	return sources, nil
+}
+
+// track makes a newly registered backend available
+// and registers its metrics.
+func (p *pool) track(stats *frontendStats, b *backend.Backend) {
+	// Registry updates are published asynchronously;
+	// make the backend available before accepting connections.
+	p.lb.UpdateBackend(b)
+	stats.backendActiveConnsGauge(p, b)
+	stats.backendHealthGauge(p, b)
+	if p.limits() != (backend.Limits{}) {
+		stats.backendCircuitGauges(p, b)
+	}
+	p.priorities[b.Priority()] = true
+	if len(p.priorities) > 1 {
+		for priority := range p.priorities {
+			stats.tierGauges(p, priority)
+		}
+	}
+}
+
+// untrack unregisters a removed backend's metrics once its
+// connections and dials are done, so they don't recreate them,
+// unless the backend has been added to the pool again since.
+func (p *pool) untrack(stats *frontendStats, b *backend.Backend) {
+	for b.ActiveConns() > 0 || b.PendingDials() > 0 {
+		time.Sleep(untrackPollInterval)
+	}
+	if p.registry.Get(b.Addr()) == nil {
+		stats.unregisterBackend(p, b.Addr())
+	}
+}
+
+// close stops discovering backends and removes the pool's backends.
+func (p *pool) close() {
+	if p.reconciler != nil {
+		p.reconciler.Stop()
+	}
+	p.registry.EvictAll()
+}
+
+// nextBackend picks a backend and reserves a dial to it with
+// BeginDial. If every backend is at its limits, the client is
+// rejected or, with a QueueTimeout, waits for one to free up.
+func (p *pool) nextBackend(c net.Conn) (*backend.Backend, error) {
+	var timer *time.Timer
+	for attempts := 0; ; attempts++ {
+		p.lock.Lock()
+		freed := p.freed
+		p.lock.Unlock()
+
+		b, err := p.lb.NextBackend(c)
+		if err == nil && b.BeginDial() {
+			return b, nil
+		}
+		if err != nil && err != loadbalancer.ErrSaturated {
+			return nil, err
+		}
+		if err == nil && attempts < maxReserveAttempts {
+			// Another client reserved the backend's last
+			// slot since it was chosen; choose again.
+			continue
+		}
+
+		if p.cfg.QueueTimeout <= 0 {
+			return nil, &rejection{"circuit_open", errors.New("all backends in pool " + p.name() + " are at their connection limits")}
+		}
+		if timer == nil {
+			timer = time.NewTimer(p.cfg.QueueTimeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-freed:
+			attempts = 0
+		case <-timer.C:
+			return nil, &rejection{"circuit_open", errors.New(fmt.Sprintf("timed out after %v queued for pool %s", p.cfg.QueueTimeout, p.name()))}
+		}
+	}
+}
+
+// release wakes clients queued for a backend in the
+// pool, after a dial or connection to it has ended.
+func (p *pool) release() {
+	if p.cfg.QueueTimeout <= 0 {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	close(p.freed)
+	p.freed = make(chan struct{})
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestPrioritySpillover(t *testing.T) {
+	primaryListener := proxytesting.NewLocalListener(t)
+	defer primaryListener.Close()
+	drListener := proxytesting.NewLocalListener(t)
+	defer drListener.Close()
+
+	tcpProxy := newSimpleTCPProxy(t, []string{
+		primaryListener.Addr().String(),
+		drListener.Addr().String() + "@1",
+	})
+	err := tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	tierPrefix := "frontend.default.pool.default.tier."
+	stats := tcpProxy.Stats()
+	assertMetric(t, stats, tierPrefix+"0.load_percent", uint64(100))
+	assertMetric(t, stats, tierPrefix+"1.load_percent", uint64(0))
+
+	// With the primary site down, the DR site takes over.
+	pool := tcpProxy.frontends[0].pools[0]
+	primary := pool.registry.Get(primaryListener.Addr().String())
+	primary.SetState(backend.UNHEALTHY)
+	pool.lb.UpdateBackend(primary)
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	dr, err := drListener.Accept()
+	check(t, err)
+	defer dr.Close()
+	check(t, assertSendAndReceiveMessage(client, dr, "hi!"))
+
+	stats = tcpProxy.Stats()
+	assertMetric(t, stats, tierPrefix+"0.healthy", uint64(0))
+	assertMetric(t, stats, tierPrefix+"0.total", uint64(1))
+	assertMetric(t, stats, tierPrefix+"1.load_percent", uint64(100))
+	assertMetric(t, stats, tierPrefix+"1.spillovers", uint64(1))
+}
@@ -1,15 +1,15 @@
 package proxy
 
 import (
-	"fmt"
 	"io"
 	"math/rand"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/jmuia/tcp-proxy/backend"
-	"github.com/jmuia/tcp-proxy/loadbalancer"
 	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/jmuia/tcp-proxy/metrics"
 	"github.com/pkg/errors"
 )
 
@@ -19,31 +19,69 @@ func init() {
 
 type TCPProxy struct {
 	cfg       Config
-	ln        net.Listener
 	state     State
-	lb        loadbalancer.LoadBalancer
-	registry  *backend.Registry
+	frontends []*frontend
 	stats     *proxyStats
+	accessLog io.Closer
+	reporter  *metrics.StatsdReporter
 	shutdownc chan struct{}
 	exitc     chan error
+	acceptors sync.WaitGroup
 }
 
 func NewTCPProxy(cfg Config) (*TCPProxy, error) {
-	var lb loadbalancer.LoadBalancer
-	switch cfg.Lb.Type {
-	case loadbalancer.RANDOM_TYPE:
-		lb = loadbalancer.NewRandom()
-	case loadbalancer.P2C_TYPE:
-		lb = loadbalancer.NewP2C()
-	default:
-		return nil, errors.New(fmt.Sprintf("unexpected load balancer type %s", cfg.Lb.Type))
+	stats := newProxyStats()
+	accessLog, accessLogFile, err := openAccessLog(cfg.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+	var reporter *metrics.StatsdReporter
+	if cfg.Statsd.Enabled() {
+		statsdCfg := cfg.Statsd
+		if statsdCfg.TagKeys == nil {
+			statsdCfg.TagKeys = statsdTagKeys
+		}
+		reporter, err = metrics.NewStatsdReporter(stats.registry, statsdCfg)
+		if err != nil {
+			if accessLogFile != nil {
+				accessLogFile.Close()
+			}
+			return nil, err
+		}
+	}
+	closeOnError := func() {
+		if accessLogFile != nil {
+			accessLogFile.Close()
+		}
+		if reporter != nil {
+			reporter.Stop()
+		}
+	}
+
+	names := make(map[string]bool)
+	frontends := make([]*frontend, 0, len(cfg.Frontends))
+	for _, frontendCfg := range cfg.Frontends {
+		if names[frontendCfg.Name] {
+			closeOnError()
+			return nil, errors.New("duplicate frontend name " + frontendCfg.Name)
+		}
+		names[frontendCfg.Name] = true
+
+		f, err := newFrontend(frontendCfg, cfg.Health, stats, accessLog)
+		if err != nil {
+			closeOnError()
+			return nil, err
+		}
+		frontends = append(frontends, f)
 	}
 
 	return &TCPProxy{
 		cfg:       cfg,
 		state:     NEW,
-		lb:        lb,
-		stats:     newProxyStats(),
+		frontends: frontends,
+		stats:     stats,
+		accessLog: accessLogFile,
+		reporter:  reporter,
 		shutdownc: make(chan struct{}),
 		exitc:     make(chan error, 1),
 	}, nil
@@ -51,36 +89,18 @@ func NewTCPProxy(cfg Config) (*TCPProxy, error) {
 
 func (t *TCPProxy) Start() error {
 	logger.Info("starting proxy...")
-	logger.Infof("config: %+v", t.cfg)
+	logger.Debugf("config: %+v", t.cfg)
 	swapped := AtomicCompareAndSwap(&t.state, NEW, STARTING)
 	if !swapped {
 		return errors.New("attempted to start proxy when not in NEW state")
 	}
 
-	var err error
-	t.ln, err = net.Listen("tcp", t.cfg.Laddr)
-	if err != nil {
-		t.Shutdown()
-		return errors.Wrapf(err, "failed to listen on %s", t.cfg.Laddr)
-	}
-
-	logger.Info("listening on ", t.ln.Addr())
-
-	t.registry = backend.NewRegistry(t.cfg.Health)
-	t.registry.RegisterUpdateListener(func(backend *backend.Backend) {
-		logger.Infof("%s now %s", backend.Addr(), backend.State().String())
-	})
-	t.registry.RegisterUpdateListener(func(backend *backend.Backend) {
-		t.lb.UpdateBackend(backend)
-	})
-	for _, b := range t.cfg.Backends {
-		backend, err := t.registry.Add(b)
+	for _, f := range t.frontends {
+		err := f.listen()
 		if err != nil {
 			t.Shutdown()
-			return errors.Wrapf(err, "failed to register %s", b)
+			return err
 		}
-		t.stats.backendActiveConnsGauge(backend)
-		t.stats.backendHealthGauge(backend)
 	}
 
 	swapped = AtomicCompareAndSwap(&t.state, STARTING, RUNNING)
@@ -89,13 +109,37 @@ func (t *TCPProxy) Start() error {
 		return errors.New("attempted to run proxy when not in STARTING state")
 	}
 
-	go t.acceptConns()
+	for _, f := range t.frontends {
+		t.acceptors.Add(1)
+		go func(f *frontend) {
+			defer t.acceptors.Done()
+			err := f.serve(t.shutdownc)
+			if err != nil {
+				// Only the first error is reported.
+				select {
+				case t.exitc <- err:
+				default:
+				}
+				t.Shutdown()
+			}
+		}(f)
+	}
+
+	if t.reporter != nil {
+		t.reporter.Start()
+	}
+
+	// Exit once every frontend has stopped accepting.
+	go func() {
+		t.acceptors.Wait()
+		t.exit()
+	}()
 	return nil
 }
 
 func (t *TCPProxy) Shutdown() {
 	prev := AtomicSwap(&t.state, STOPPED)
-	logger.Infof("shutting down in state %s", prev.String())
+	logger.Infow("shutting down", "state", prev.String())
 	switch prev {
 	case NEW, STARTING:
 		close(t.shutdownc)
@@ -113,132 +157,133 @@ func (t *TCPProxy) Run() error {
 	return <-t.exitc
 }
 
-func (t *TCPProxy) Stats() map[string]interface{} {
-	stats := make(map[string]interface{})
-	for name, counter := range t.stats.registry.Counters() {
-		stats[name] = counter.Count()
-	}
-	for name, gauge := range t.stats.registry.Gauges() {
-		stats[name] = gauge.Value()
+// Reload re-reads each frontend's access control list. Frontends
+// whose list is invalid keep their current one, and the first
+// error is returned.
+func (t *TCPProxy) Reload() error {
+	var err error
+	for _, f := range t.frontends {
+		reloadErr := f.reloadACL()
+		if err == nil {
+			err = reloadErr
+		}
 	}
-	return stats
+	return err
 }
 
-func (t *TCPProxy) exit() {
-	if t.ln != nil {
-		t.ln.Close()
-		// Poor man's graceful shutdown.
-		// TODO: proper connection counting.
-		if t.cfg.GracePeriod > 0 {
-			time.Sleep(t.cfg.GracePeriod)
+// Drain stops sending new connections to the backend at addr,
+// in every pool it belongs to, without closing existing ones.
+func (t *TCPProxy) Drain(addr string) error {
+	registries := t.registriesWith(addr)
+	if len(registries) == 0 {
+		return &backend.UnknownBackendError{Addr: addr}
+	}
+	for _, r := range registries {
+		err := r.Drain(addr)
+		if err != nil {
+			return err
 		}
 	}
-	t.registry.EvictAll()
-	close(t.exitc)
+	logger.Infow("draining backend", "backend", addr)
+	return nil
 }
 
-func (t *TCPProxy) acceptTimeout(timeout time.Duration) (net.Conn, error) {
-	deadline := time.Now().Add(timeout)
-	err := t.ln.(*net.TCPListener).SetDeadline(deadline)
-	if err != nil {
-		return nil, err
+// RemoveBackend drains the backend at addr and removes it once
+// its connections close or the timeout passes. It reports
+// whether every connection had closed.
+func (t *TCPProxy) RemoveBackend(addr string, timeout time.Duration) (bool, error) {
+	registries := t.registriesWith(addr)
+	if len(registries) == 0 {
+		return false, &backend.UnknownBackendError{Addr: addr}
 	}
-	return t.ln.Accept()
+
+	type result struct {
+		drained bool
+		err     error
+	}
+	results := make(chan result, len(registries))
+	for _, r := range registries {
+		go func(r *backend.Registry) {
+			drained, err := r.DrainAndRemove(addr, timeout)
+			results <- result{drained, err}
+		}(r)
+	}
+
+	drained := true
+	var err error
+	for range registries {
+		res := <-results
+		drained = drained && res.drained
+		if err == nil {
+			err = res.err
+		}
+	}
+	logger.Infow("removed backend", "backend", addr, "drained", drained)
+	return drained, err
 }
 
-func (t *TCPProxy) acceptConns() {
-	defer t.exit()
-
-	// TODO: use a worker pool to limit concurrency.
-	// TODO: don't accept connections if there aren't any
-	//       healthy backends to proxy to.
-	for {
-		select {
-		case <-t.shutdownc:
-			return
-		default:
-			// Accept() is blocking. Adds a timeout
-			// to ensure we're still checking for
-			// shutdown messages if the proxy is idle.
-			src, err := t.acceptTimeout(3 * time.Second)
-			if isTimeout(err) {
-				continue
+func (t *TCPProxy) registriesWith(addr string) []*backend.Registry {
+	var registries []*backend.Registry
+	for _, f := range t.frontends {
+		for _, p := range f.pools {
+			if p.registry.Get(addr) != nil {
+				registries = append(registries, p.registry)
 			}
-			if err != nil {
-				t.exitc <- err
-				t.Shutdown()
-				return
-			}
-			logger.Info("accepted connection from ", src.RemoteAddr())
-			t.stats.incrRequests()
-			go t.handleConn(src)
 		}
 	}
+	return registries
 }
 
-func (t *TCPProxy) handleConn(src net.Conn) {
-	backend, err := t.lb.NextBackend(src)
-	if err != nil {
-		logger.Error(err)
-		t.stats.incrErrors()
-		src.Close()
-		return
+func (t *TCPProxy) Stats() map[string]interface{} {
+	stats := make(map[string]interface{})
+	for name, counter := range t.stats.registry.Counters() {
+		stats[name] = counter.Count()
 	}
-
-	dst, err := net.DialTimeout("tcp", backend.Addr(), t.cfg.Timeout)
-	if err != nil {
-		// TODO: attempt a different backend.
-		logger.Error(errors.Wrapf(err, "error dialing backend %v", backend))
-		t.stats.incrErrors()
-		src.Close()
-		return
+	for name, gauge := range t.stats.registry.Gauges() {
+		stats[name] = gauge.Value()
 	}
-
-	activeConns := backend.IncrActiveConns()
-	defer backend.DecrActiveConns()
-
-	logger.Infof("opened connection to %s (%d active)", dst.RemoteAddr(), activeConns)
-
-	// proxyConn will close the connections.
-	stats, err := t.proxyConn(src, dst)
-	if err != nil {
-		logger.Error(err)
-		t.stats.incrErrors()
+	for name, meter := range t.stats.registry.Meters() {
+		stats[name] = meter.Count()
+		for suffix, rate := range metrics.Rates(meter) {
+			stats[name+"."+suffix] = rate
+		}
 	}
-	t.stats.incrBackendIoStats(backend.Addr(), stats.backend)
-	t.stats.incrFrontendIoStats(stats.frontend)
+	return stats
 }
 
-func (t *TCPProxy) proxyConn(src net.Conn, dst net.Conn) (*proxyIoStats, error) {
-	errc := make(chan error, 2)
-
-	copy := func(dst net.Conn, src net.Conn, tx *uint64, rx *uint64) {
-		bytes, err := io.Copy(dst, src)
-		logger.Infof("proxied %v bytes from %v to %v", bytes, src.RemoteAddr(), dst.RemoteAddr())
-		*tx = uint64(bytes)
-		*rx = uint64(bytes)
-		errc <- err
-		src.Close()
-		dst.Close()
-	}
-
-	stats := newProxyIoStats()
-	go copy(dst, src, &stats.backend.tx, &stats.frontend.rx)
-	go copy(src, dst, &stats.frontend.tx, &stats.backend.rx)
-
-	// Await an error or EOF from either goroutine.
-	// The first to exit will close both connections, with the
-	// consequence of causing the other (likely blocked)
-	// goroutine to continue executing. We ignore the
-	// second error, if any.
-	err := <-errc
-	if err != nil {
-		err = errors.Wrapf(err, "error proxying data from %v to %v", src.RemoteAddr(), dst.RemoteAddr())
+func (t *TCPProxy) exit() {
+	listening := false
+	for _, f := range t.frontends {
+		if f.stopListening() {
+			listening = true
+		}
+	}
+	// Poor man's graceful shutdown.
+	// TODO: proper connection counting.
+	if listening && t.cfg.GracePeriod > 0 {
+		time.Sleep(t.cfg.GracePeriod)
+	}
+	for _, f := range t.frontends {
+		f.close()
+	}
+	if t.reporter != nil {
+		t.reporter.Stop()
 	}
+	if t.accessLog != nil {
+		t.accessLog.Close()
+	}
+	close(t.exitc)
+}
+
+// rejection is returned when a client is refused
+// by policy, rather than because of a failure.
+type rejection struct {
+	reason string
+	err    error
+}
 
-	// TODO: maybe select here as safeguard against blocking.
-	<-errc
-	return stats, err
+func (r *rejection) Error() string {
+	return r.reason + ": " + r.err.Error()
 }
 
 func isTimeout(err error) bool {
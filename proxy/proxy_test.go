@@ -3,12 +3,15 @@ package proxy
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/jmuia/tcp-proxy/loadbalancer"
+	"github.com/jmuia/tcp-proxy/metrics"
 	proxytesting "github.com/jmuia/tcp-proxy/testing"
 	"github.com/pkg/errors"
 )
@@ -30,7 +33,7 @@ func TestProxy(t *testing.T) {
 	check(t, err)
 
 	// Connect to the proxy as a client.
-	client, err := net.Dial("tcp", tcpProxy.ln.Addr().String())
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
 	defer client.Close()
 	check(t, err)
 
@@ -74,6 +77,117 @@ func TestProxy(t *testing.T) {
 	time.Sleep(1 * time.Second)
 }
 
+func TestHalfClose(t *testing.T) {
+	// Set up a backend to proxy to.
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	// Set up proxy.
+	tcpProxy := newSimpleTCPProxy(t, []string{backendListener.Addr().String()})
+
+	err := tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	// Send a request, then half-close.
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	_, err = io.WriteString(client, "request")
+	check(t, err)
+	check(t, client.(*net.TCPConn).CloseWrite())
+
+	// The backend reads the request through to EOF...
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	request, err := ioutil.ReadAll(backend)
+	check(t, err)
+	if string(request) != "request" {
+		t.Errorf("expected backend to receive %q, got %q", "request", request)
+	}
+
+	// ...and its response still reaches the client in full.
+	response := strings.Repeat("response", 64*1024)
+	_, err = io.WriteString(backend, response)
+	check(t, err)
+	backend.Close()
+
+	received, err := ioutil.ReadAll(client)
+	check(t, err)
+	if string(received) != response {
+		t.Errorf("expected client to receive a %d byte response, got %d bytes", len(response), len(received))
+	}
+}
+
+func TestHalfCloseIdleTimeout(t *testing.T) {
+	// Set up a backend to proxy to.
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	// Set up proxy.
+	tcpProxy := newSimpleTCPProxy(t, []string{backendListener.Addr().String()})
+	tcpProxy.frontends[0].cfg.IdleTimeout = 100 * time.Millisecond
+
+	err := tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	check(t, client.(*net.TCPConn).CloseWrite())
+
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+
+	// The backend never responds, so the proxy gives up on
+	// the half-closed connection after the idle timeout.
+	client.SetReadDeadline(time.Now().Add(1 * time.Second))
+	_, err = ioutil.ReadAll(client)
+	check(t, err)
+}
+
+func TestHalfCloseTimeout(t *testing.T) {
+	// Set up a backend to proxy to.
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	// Set up proxy, without an idle timeout.
+	tcpProxy := newSimpleTCPProxy(t, []string{backendListener.Addr().String()})
+	tcpProxy.frontends[0].cfg.HalfCloseTimeout = 200 * time.Millisecond
+
+	err := tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	check(t, client.(*net.TCPConn).CloseWrite())
+
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+
+	// A response streamed for longer than the timeout isn't cut
+	// off, but the connection is closed once the backend goes quiet.
+	go func() {
+		for i := 0; i < 6; i++ {
+			io.WriteString(backend, "chunk")
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	received, err := ioutil.ReadAll(client)
+	check(t, err)
+	if string(received) != strings.Repeat("chunk", 6) {
+		t.Errorf("expected the whole response, got %q", received)
+	}
+	waitForMetric(t, tcpProxy, "closed."+closedHalfClose, uint64(1))
+}
+
 func TestShutdownNoConnections(t *testing.T) {
 	tcpProxy := newSimpleTCPProxy(t, []string{})
 
@@ -118,12 +232,84 @@ func TestIdempotentShutdown(t *testing.T) {
 	tcpProxy.Shutdown()
 }
 
+func TestMultipleFrontends(t *testing.T) {
+	backendA := proxytesting.NewLocalListener(t)
+	defer backendA.Close()
+	backendB := proxytesting.NewLocalListener(t)
+	defer backendB.Close()
+
+	frontend := func(name string, backend net.Listener) FrontendConfig {
+		return FrontendConfig{
+			Name:        name,
+			Laddr:       "localhost:0",
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", Backends: []string{backend.Addr().String()}}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.RANDOM_TYPE},
+		}
+	}
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{frontend("a", backendA), frontend("b", backendB), frontend("c", backendA)},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	check(t, err)
+
+	// Each frontend proxies to its own backends.
+	for i, backendListener := range []net.Listener{backendA, backendB} {
+		client, err := net.Dial("tcp", tcpProxy.frontends[i].ln.Addr().String())
+		check(t, err)
+		defer client.Close()
+
+		backend, err := backendListener.Accept()
+		check(t, err)
+		defer backend.Close()
+		check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+	}
+
+	// Metrics are labelled per frontend.
+	stats := tcpProxy.Stats()
+	assertMetric(t, stats, "requests", uint64(2))
+	assertMetric(t, stats, "frontend.a.requests", uint64(1))
+	assertMetric(t, stats, "frontend.b.requests", uint64(1))
+
+	// So are those of a backend shared by several frontends.
+	assertMetric(t, stats, "frontend.a.pool.default.backend."+metrics.SanitizeSegment(backendA.Addr().String())+".active_connections", uint64(1))
+	assertMetric(t, stats, "frontend.c.pool.default.backend."+metrics.SanitizeSegment(backendA.Addr().String())+".active_connections", uint64(0))
+
+	// Shutting down stops every frontend.
+	tcpProxy.Shutdown()
+	select {
+	case <-tcpProxy.exitc:
+	case <-time.NewTimer(5 * time.Second).C:
+		t.Fatal("proxy didn't exit in 5s")
+	}
+	for _, f := range tcpProxy.frontends {
+		_, err := net.Dial("tcp", f.ln.Addr().String())
+		if err == nil {
+			t.Errorf("expected frontend %s to stop listening", f.name())
+		}
+	}
+
+	_, err = NewTCPProxy(Config{
+		Frontends: []FrontendConfig{frontend("a", backendA), frontend("a", backendB)},
+	})
+	if err == nil {
+		t.Error("expected an error for duplicate frontend names")
+	}
+}
+
 func newSimpleTCPProxy(t *testing.T, backends []string) *TCPProxy {
 	proxyConfig := Config{
-		Laddr:    "localhost:0",
-		Timeout:  1 * time.Second,
-		Backends: backends,
-		Lb:       loadbalancer.Config{loadbalancer.P2C_TYPE},
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Laddr:       "localhost:0",
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", Backends: backends}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
 	}
 	tcpProxy, err := NewTCPProxy(proxyConfig)
 	if err != nil {
@@ -161,7 +347,7 @@ func TestClosesClientConnectionOnBackendError(t *testing.T) {
 	check(t, err)
 
 	// Connect to the proxy as a client -- but there are no healthy backends!
-	client, err := net.Dial("tcp", tcpProxy.ln.Addr().String())
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
 	defer client.Close()
 	check(t, err)
 }
@@ -180,11 +366,11 @@ func TestStats(t *testing.T) {
 
 	// Check active connections.
 	stats := tcpProxy.Stats()
-	backendMetricPrefix := "backend." + backendListener.Addr().String() + "."
+	backendMetricPrefix := "frontend.default.pool.default.backend." + metrics.SanitizeSegment(backendListener.Addr().String()) + "."
 	assertMetric(t, stats, backendMetricPrefix+"active_connections", uint64(0))
 
 	// Connect to the proxy as a client.
-	client, err := net.Dial("tcp", tcpProxy.ln.Addr().String())
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
 	defer client.Close()
 	check(t, err)
 
@@ -197,7 +383,13 @@ func TestStats(t *testing.T) {
 	time.Sleep(1 * time.Millisecond)
 	stats = tcpProxy.Stats()
 	assertMetric(t, stats, backendMetricPrefix+"active_connections", uint64(1))
+	assertMetric(t, stats, backendMetricPrefix+"requests", uint64(1))
 	assertMetric(t, stats, "requests", uint64(1))
+	for _, suffix := range []string{"rate_1m", "rate_5m", "rate_15m", "rate_mean"} {
+		if _, ok := stats["frontend.default.requests."+suffix].(float64); !ok {
+			t.Errorf("expected frontend.default.requests.%s to be a rate: %v", suffix, stats)
+		}
+	}
 
 	// Send data back and forth.
 	check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
@@ -209,14 +401,14 @@ func TestStats(t *testing.T) {
 	time.Sleep(1 * time.Millisecond)
 	stats = tcpProxy.Stats()
 	assertMetric(t, stats, backendMetricPrefix+"active_connections", uint64(0))
-	assertMetric(t, stats, "frontend.io.rx", uint64(len("hi!")))
-	assertMetric(t, stats, "frontend.io.tx", uint64(len("hello!")))
+	assertMetric(t, stats, "frontend.default.io.rx", uint64(len("hi!")))
+	assertMetric(t, stats, "frontend.default.io.tx", uint64(len("hello!")))
 	assertMetric(t, stats, backendMetricPrefix+"io.tx", uint64(len("hi!")))
 	assertMetric(t, stats, backendMetricPrefix+"io.rx", uint64(len("hello!")))
 
 	// Connect to the proxy as a client -- but the backend is down!
 	backendListener.Close()
-	client, err = net.Dial("tcp", tcpProxy.ln.Addr().String())
+	client, err = net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
 	defer client.Close()
 	check(t, err)
 
@@ -225,6 +417,82 @@ func TestStats(t *testing.T) {
 	stats = tcpProxy.Stats()
 	assertMetric(t, stats, "requests", uint64(2))
 	assertMetric(t, stats, "errors", uint64(1))
+	assertMetric(t, stats, backendMetricPrefix+"errors", uint64(1))
+}
+
+func TestStatsMeterOpenConnections(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+	tcpProxy := newSimpleTCPProxy(t, []string{backendListener.Addr().String()})
+	check(t, tcpProxy.Start())
+	defer tcpProxy.Shutdown()
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+
+	// Bytes are metered as they're copied, not when the
+	// connection closes, a spliced chunk at a time.
+	go client.Write(make([]byte, spliceChunkSize))
+	_, err = io.ReadFull(backend, make([]byte, spliceChunkSize))
+	check(t, err)
+	waitForMetric(t, tcpProxy, "frontend.default.io.rx", uint64(spliceChunkSize))
+	waitForMetric(t, tcpProxy, "frontend.default.pool.default.backend."+metrics.SanitizeSegment(backendListener.Addr().String())+".io.tx", uint64(spliceChunkSize))
+}
+
+func TestStatsdReporting(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+	statsd, err := net.ListenPacket("udp", "localhost:0")
+	check(t, err)
+	defer statsd.Close()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Laddr:       "localhost:0",
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", Backends: []string{backendListener.Addr().String()}}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+		Statsd: metrics.StatsdConfig{Addr: statsd.LocalAddr().String(), Interval: 10 * time.Millisecond, DogStatsD: true},
+	})
+	check(t, err)
+	check(t, tcpProxy.Start())
+	defer tcpProxy.Shutdown()
+
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+
+	// Counters are sent once as they change, and gauges every time.
+	// A frontend's metrics are named apart from the proxy's totals.
+	expected := map[string]bool{
+		"frontend.requests:1|c|#frontend:default": true,
+		"requests:1|c": true,
+		"frontend.pool.backend.active_connections:1|g|#frontend:default,pool:default,backend:" + metrics.SanitizeSegment(backendListener.Addr().String()): true,
+	}
+	buf := make([]byte, 2048)
+	statsd.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(expected) > 0 {
+		n, _, err := statsd.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("expected %v to be sent: %v", expected, err)
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if strings.HasPrefix(line, "requests:") && strings.Contains(line, "|#") {
+				t.Errorf("expected tagged requests to be named apart from the total, got %s", line)
+			}
+			delete(expected, line)
+		}
+	}
 }
 
 func assertMetric(t *testing.T, stats map[string]interface{}, name string, expected interface{}) {
@@ -233,6 +501,17 @@ func assertMetric(t *testing.T, stats map[string]interface{}, name string, expec
 	}
 }
 
+// waitForMetric waits for a metric to reach its expected value,
+// since connections are recorded after they close.
+func waitForMetric(t *testing.T, tcpProxy *TCPProxy, name string, expected interface{}) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && tcpProxy.Stats()[name] != expected {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assertMetric(t, tcpProxy.Stats(), name, expected)
+}
+
 func check(t *testing.T, err error) {
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/ratelimit"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	tcpProxy := newSimpleTCPProxy(t, []string{backendListener.Addr().String()})
+	tcpProxy.frontends[0].limiter = ratelimit.NewLimiter(ratelimit.Config{MaxConnsPerSource: 1})
+
+	err := tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	// The first connection is proxied.
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+
+	// A second concurrent connection from the same IP is closed.
+	rejected, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(1 * time.Second))
+	_, err = ioutil.ReadAll(rejected)
+	check(t, err)
+
+	stats := tcpProxy.Stats()
+	assertMetric(t, stats, "rejected."+ratelimit.TooManyConns, uint64(1))
+	assertMetric(t, stats, "frontend.default.rejected."+ratelimit.TooManyConns, uint64(1))
+
+	// Once the first connection closes, the IP may connect again.
+	client.Close()
+	backend.Close()
+	time.Sleep(50 * time.Millisecond)
+	client, err = net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	backend, err = backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(client, backend, "hi again!"))
+}
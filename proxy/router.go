@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// router picks a pool for a connection based
+// on the TLS server name it requested.
+type router struct {
+	exact    map[string]*pool
+	wildcard []wildcardRoute
+	fallback *pool
+}
+
+// wildcardRoute matches any server name ending in suffix,
+// e.g. *.example.com matches a.example.com and a.b.example.com
+// but not example.com.
+type wildcardRoute struct {
+	suffix string
+	pool   *pool
+}
+
+func newRouter(pools []*pool, defaultPool string) (*router, error) {
+	r := &router{
+		exact:    make(map[string]*pool),
+		wildcard: make([]wildcardRoute, 0),
+	}
+
+	names := make(map[string]bool)
+	for _, p := range pools {
+		if names[p.name()] {
+			return nil, errors.New(fmt.Sprintf("duplicate pool name %s", p.name()))
+		}
+		names[p.name()] = true
+
+		if p.name() == defaultPool {
+			r.fallback = p
+		}
+
+		for _, pattern := range p.cfg.SNI {
+			pattern = strings.ToLower(pattern)
+			if strings.HasPrefix(pattern, "*.") {
+				r.wildcard = append(r.wildcard, wildcardRoute{pattern[1:], p})
+				continue
+			}
+			if strings.Contains(pattern, "*") {
+				return nil, errors.New(fmt.Sprintf("invalid SNI pattern %s", pattern))
+			}
+			if _, exists := r.exact[pattern]; exists {
+				return nil, errors.New(fmt.Sprintf("duplicate SNI pattern %s", pattern))
+			}
+			r.exact[pattern] = p
+		}
+	}
+
+	if defaultPool != "" && r.fallback == nil {
+		return nil, errors.New(fmt.Sprintf("default pool %s does not exist", defaultPool))
+	}
+
+	// Most specific wildcard wins.
+	sort.SliceStable(r.wildcard, func(i, j int) bool {
+		return len(r.wildcard[i].suffix) > len(r.wildcard[j].suffix)
+	})
+	return r, nil
+}
+
+// sniEnabled reports whether any pool is routed by server
+// name, in which case connections must be peeked at.
+func (r *router) sniEnabled() bool {
+	return len(r.exact) > 0 || len(r.wildcard) > 0
+}
+
+func (r *router) route(serverName string) (*pool, error) {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if serverName != "" {
+		if p, ok := r.exact[serverName]; ok {
+			return p, nil
+		}
+		for _, w := range r.wildcard {
+			if strings.HasSuffix(serverName, w.suffix) {
+				return w.pool, nil
+			}
+		}
+	}
+	if r.fallback == nil {
+		return nil, errors.New(fmt.Sprintf("no pool for server name %q", serverName))
+	}
+	return r.fallback, nil
+}
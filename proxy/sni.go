@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	recordHeaderLen     = 5
+	maxRecordLen        = 16384
+	recordTypeHandshake = 0x16
+	typeClientHello     = 0x01
+	extensionServerName = 0x0000
+	nameTypeHostName    = 0x00
+	handshakeHeaderLen  = 4
+	// How much of a connection is peeked at for a ClientHello,
+	// which may be fragmented across several records.
+	maxPeekLen = 2 * (recordHeaderLen + maxRecordLen)
+)
+
+var errNotTLS = errors.New("not a TLS handshake")
+var errMalformedClientHello = errors.New("malformed TLS ClientHello")
+var errClientHelloTooLarge = errors.New("TLS ClientHello too large to peek at")
+
+// peekedConn replays bytes that were peeked at
+// before continuing to read from the connection.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newPeekedConn(c net.Conn) *peekedConn {
+	return &peekedConn{c, bufio.NewReaderSize(c, maxPeekLen)}
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// writeBuffered writes any bytes that were peeked at but not yet
+// read to w, after which reads may bypass the buffer entirely.
+func (c *peekedConn) writeBuffered(w io.Writer) (int64, error) {
+	buffered, _ := c.r.Peek(c.r.Buffered())
+	n, err := w.Write(buffered)
+	c.r.Discard(n)
+	return int64(n), err
+}
+
+// peekServerName returns the server name requested in the TLS
+// ClientHello without consuming it, reassembling the ClientHello
+// if it spans several records. An empty name and no error are
+// returned if the ClientHello doesn't include one.
+func (c *peekedConn) peekServerName() (string, error) {
+	typ, err := c.r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if typ[0] != recordTypeHandshake {
+		return "", errNotTLS
+	}
+
+	var msg []byte
+	for offset := 0; ; {
+		if offset+recordHeaderLen > maxPeekLen {
+			return "", errClientHelloTooLarge
+		}
+		hdr, err := c.r.Peek(offset + recordHeaderLen)
+		if err != nil {
+			return "", err
+		}
+		hdr = hdr[offset:]
+		n := int(hdr[3])<<8 | int(hdr[4])
+		if hdr[0] != recordTypeHandshake || n > maxRecordLen {
+			return "", errMalformedClientHello
+		}
+		if offset+recordHeaderLen+n > maxPeekLen {
+			return "", errClientHelloTooLarge
+		}
+
+		record, err := c.r.Peek(offset + recordHeaderLen + n)
+		if err != nil {
+			return "", err
+		}
+		msg = append(msg, record[offset+recordHeaderLen:]...)
+		offset += recordHeaderLen + n
+
+		if len(msg) >= handshakeHeaderLen {
+			size := handshakeHeaderLen + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]))
+			if len(msg) >= size {
+				return parseServerName(msg[:size])
+			}
+		}
+	}
+}
+
+// parseServerName extracts the server_name extension from a
+// ClientHello handshake message (RFC 5246 7.4.1.2, RFC 6066 3).
+func parseServerName(msg []byte) (string, error) {
+	s := cursor(msg)
+
+	typ, ok := s.uint8()
+	if !ok || typ != typeClientHello {
+		return "", errNotTLS
+	}
+
+	var body cursor
+	if !s.lengthPrefixed(3, &body) {
+		return "", errMalformedClientHello
+	}
+
+	// Version (2), random (32), session id, cipher
+	// suites and compression methods precede extensions.
+	var ignored, exts cursor
+	if !body.skip(2+32) ||
+		!body.lengthPrefixed(1, &ignored) ||
+		!body.lengthPrefixed(2, &ignored) ||
+		!body.lengthPrefixed(1, &ignored) {
+		return "", errMalformedClientHello
+	}
+	if len(body) == 0 {
+		// No extensions.
+		return "", nil
+	}
+	if !body.lengthPrefixed(2, &exts) {
+		return "", errMalformedClientHello
+	}
+
+	for len(exts) > 0 {
+		var ext cursor
+		extType, ok := exts.uint16()
+		if !ok || !exts.lengthPrefixed(2, &ext) {
+			return "", errMalformedClientHello
+		}
+		if extType != extensionServerName {
+			continue
+		}
+
+		var names cursor
+		if !ext.lengthPrefixed(2, &names) {
+			return "", errMalformedClientHello
+		}
+		for len(names) > 0 {
+			var name cursor
+			nameType, ok := names.uint8()
+			if !ok || !names.lengthPrefixed(2, &name) {
+				return "", errMalformedClientHello
+			}
+			if nameType == nameTypeHostName {
+				return string(name), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// cursor is a minimal reader over a handshake message.
+type cursor []byte
+
+func (c *cursor) skip(n int) bool {
+	if len(*c) < n {
+		return false
+	}
+	*c = (*c)[n:]
+	return true
+}
+
+func (c *cursor) uint8() (uint8, bool) {
+	if len(*c) < 1 {
+		return 0, false
+	}
+	v := (*c)[0]
+	*c = (*c)[1:]
+	return v, true
+}
+
+func (c *cursor) uint16() (uint16, bool) {
+	if len(*c) < 2 {
+		return 0, false
+	}
+	v := uint16((*c)[0])<<8 | uint16((*c)[1])
+	*c = (*c)[2:]
+	return v, true
+}
+
+// lengthPrefixed reads a field preceded by
+// an n-byte big-endian length into out.
+func (c *cursor) lengthPrefixed(n int, out *cursor) bool {
+	if len(*c) < n {
+		return false
+	}
+	length := 0
+	for _, b := range (*c)[:n] {
+		length = length<<8 | int(b)
+	}
+	if len(*c) < n+length {
+		return false
+	}
+	*out = (*c)[n : n+length]
+	*c = (*c)[n+length:]
+	return true
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestPeekServerName(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go tls.Client(client, &tls.Config{ServerName: "api.example.com"}).Handshake()
+
+	conn := newPeekedConn(server)
+	serverName, err := conn.peekServerName()
+	check(t, err)
+	if serverName != "api.example.com" {
+		t.Errorf("expected server name api.example.com, got %q", serverName)
+	}
+
+	// The peeked bytes are still read from the connection.
+	buf := make([]byte, recordHeaderLen)
+	_, err = io.ReadFull(conn, buf)
+	check(t, err)
+	if buf[0] != recordTypeHandshake {
+		t.Errorf("expected peeked handshake record to be replayed, got %v", buf)
+	}
+}
+
+func TestPeekServerNameFragmented(t *testing.T) {
+	// Capture a ClientHello record...
+	client, server := net.Pipe()
+	go tls.Client(client, &tls.Config{ServerName: "api.example.com"}).Handshake()
+	hdr := make([]byte, recordHeaderLen)
+	_, err := io.ReadFull(server, hdr)
+	check(t, err)
+	hello := make([]byte, int(hdr[3])<<8|int(hdr[4]))
+	_, err = io.ReadFull(server, hello)
+	check(t, err)
+	client.Close()
+	server.Close()
+
+	// ...and split it across records of 100 bytes or less.
+	var fragmented []byte
+	for len(hello) > 0 {
+		n := 100
+		if len(hello) < n {
+			n = len(hello)
+		}
+		fragmented = append(fragmented, recordTypeHandshake, hdr[1], hdr[2], byte(n>>8), byte(n))
+		fragmented = append(fragmented, hello[:n]...)
+		hello = hello[n:]
+	}
+
+	client, server = net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go client.Write(fragmented)
+
+	conn := newPeekedConn(server)
+	serverName, err := conn.peekServerName()
+	check(t, err)
+	if serverName != "api.example.com" {
+		t.Errorf("expected server name api.example.com, got %q", serverName)
+	}
+}
+
+func TestPeekServerNameTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// A ClientHello claiming to be 1MB, in full records.
+	go func() {
+		record := make([]byte, recordHeaderLen+maxRecordLen)
+		copy(record, []byte{recordTypeHandshake, 3, 1, maxRecordLen >> 8, maxRecordLen & 0xff})
+		copy(record[recordHeaderLen:], []byte{typeClientHello, 0x10, 0, 0})
+		for {
+			if _, err := client.Write(record); err != nil {
+				return
+			}
+			copy(record[recordHeaderLen:], make([]byte, 4))
+		}
+	}()
+
+	conn := newPeekedConn(server)
+	if _, err := conn.peekServerName(); err != errClientHelloTooLarge {
+		t.Errorf("expected %v, got %v", errClientHelloTooLarge, err)
+	}
+}
+
+func TestPeekServerNameNotTLS(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go io.WriteString(client, "GET / HTTP/1.1\r\n\r\n")
+
+	conn := newPeekedConn(server)
+	_, err := conn.peekServerName()
+	if err != errNotTLS {
+		t.Errorf("expected %v, got %v", errNotTLS, err)
+	}
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(conn, buf)
+	check(t, err)
+	if string(buf) != "GET" {
+		t.Errorf("expected peeked bytes to be replayed, got %q", buf)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	api := &pool{cfg: PoolConfig{Name: "api", SNI: []string{"api.example.com"}}}
+	wildcard := &pool{cfg: PoolConfig{Name: "wildcard", SNI: []string{"*.example.com"}}}
+	internal := &pool{cfg: PoolConfig{Name: "internal", SNI: []string{"*.internal.example.com"}}}
+	fallback := &pool{cfg: PoolConfig{Name: "default"}}
+
+	r, err := newRouter([]*pool{api, wildcard, internal, fallback}, "default")
+	check(t, err)
+
+	routes := map[string]*pool{
+		"api.example.com":         api,
+		"API.Example.com.":        api,
+		"www.example.com":         wildcard,
+		"a.b.example.com":         wildcard,
+		"db.internal.example.com": internal,
+		"example.com":             fallback,
+		"":                        fallback,
+	}
+	for serverName, expected := range routes {
+		p, err := r.route(serverName)
+		check(t, err)
+		if p != expected {
+			t.Errorf("expected %q to route to %s, got %s", serverName, expected.name(), p.name())
+		}
+	}
+
+	r, err = newRouter([]*pool{api}, "")
+	check(t, err)
+	_, err = r.route("www.example.com")
+	if err == nil {
+		t.Error("expected an error routing an unmatched server name without a default pool")
+	}
+
+	_, err = newRouter([]*pool{api}, "missing")
+	if err == nil {
+		t.Error("expected an error when the default pool does not exist")
+	}
+}
+
+func TestSNIRouting(t *testing.T) {
+	apiBackend := proxytesting.NewLocalListener(t)
+	defer apiBackend.Close()
+	defaultBackend := proxytesting.NewLocalListener(t)
+	defer defaultBackend.Close()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:             "default",
+			Laddr:            "localhost:0",
+			Timeout:          1 * time.Second,
+			HandshakeTimeout: 1 * time.Second,
+			Pools: []PoolConfig{
+				{Name: "api", Backends: []string{apiBackend.Addr().String()}, SNI: []string{"api.example.com"}},
+				{Name: "default", Backends: []string{defaultBackend.Addr().String()}},
+			},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	// A TLS client asking for api.example.com reaches the api pool,
+	// which receives the ClientHello that was peeked at.
+	client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	go tls.Client(client, &tls.Config{ServerName: "api.example.com"}).Handshake()
+
+	backend, err := apiBackend.Accept()
+	check(t, err)
+	defer backend.Close()
+
+	buf := make([]byte, recordHeaderLen)
+	_, err = io.ReadFull(backend, buf)
+	check(t, err)
+	if buf[0] != recordTypeHandshake {
+		t.Errorf("expected api backend to receive the ClientHello, got %v", buf)
+	}
+
+	// Plaintext clients fall back to the default pool once
+	// they've sent enough to tell they aren't speaking TLS.
+	client, err = net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+	check(t, err)
+	defer client.Close()
+	_, err = io.WriteString(client, "hello!")
+	check(t, err)
+
+	backend, err = defaultBackend.Accept()
+	check(t, err)
+	defer backend.Close()
+
+	buf = make([]byte, len("hello!"))
+	_, err = io.ReadFull(backend, buf)
+	check(t, err)
+	if string(buf) != "hello!" {
+		t.Errorf("expected default backend to receive %q, got %q", "hello!", buf)
+	}
+}
@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"net"
+)
+
+// canSplice reports whether copying from src to dst can use
+// splice(2) through a pipe, which (*net.TCPConn).ReadFrom
+// does on Linux when reading from a TCP or Unix socket.
+func canSplice(dst net.Conn, src net.Conn) bool {
+	if _, ok := dst.(*net.TCPConn); !ok {
+		return false
+	}
+	switch src.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package proxy
+
+import (
+	"net"
+)
+
+// canSplice is always false off Linux; copies
+// use a pooled buffer instead.
+func canSplice(dst net.Conn, src net.Conn) bool {
+	return false
+}
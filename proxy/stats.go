@@ -1,33 +1,227 @@
 package proxy
 
 import (
+	"fmt"
+	"math"
+	"strings"
+
 	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/jmuia/tcp-proxy/loadbalancer"
 	logger "github.com/jmuia/tcp-proxy/logging"
 	"github.com/jmuia/tcp-proxy/metrics"
 )
 
+// statsdTagKeys are the segments of metric names that,
+// with the segment after them, become DogStatsD tags.
+var statsdTagKeys = []string{"frontend", "pool", "tier", "backend"}
+
+// proxyStats records the proxy's metrics. New connections, errors
+// and bytes are metered, to report their rates as well as totals.
+// Bytes are counted once their connection closes.
 type proxyStats struct {
 	registry metrics.Registry
-	requests metrics.Counter
-	errors   metrics.Counter
+	requests metrics.Meter
+	errors   metrics.Meter
 }
 
 func newProxyStats() *proxyStats {
 	stats := &proxyStats{
 		registry: metrics.NewRegistry(),
-		requests: metrics.NewCounter(),
-		errors:   metrics.NewCounter(),
+		requests: metrics.NewMeter(),
+		errors:   metrics.NewMeter(),
 	}
 	stats.registry.Register("requests", stats.requests)
 	stats.registry.Register("errors", stats.errors)
 	return stats
 }
 
+// frontendStats records metrics for a single frontend,
+// labelled by its name, as well as the proxy-wide totals.
+type frontendStats struct {
+	*proxyStats
+	prefix   string
+	requests metrics.Meter
+	errors   metrics.Meter
+}
+
+func newFrontendStats(name string, stats *proxyStats) *frontendStats {
+	fs := &frontendStats{
+		proxyStats: stats,
+		prefix:     "frontend." + name,
+		requests:   metrics.NewMeter(),
+		errors:     metrics.NewMeter(),
+	}
+	stats.registry.Register(fs.prefix+".requests", fs.requests)
+	stats.registry.Register(fs.prefix+".errors", fs.errors)
+	return fs
+}
+
+func (fs *frontendStats) incrRequests() {
+	fs.proxyStats.incrRequests()
+	fs.requests.Mark(1)
+}
+
+func (fs *frontendStats) incrErrors() {
+	fs.proxyStats.incrErrors()
+	fs.errors.Mark(1)
+}
+
+func (fs *frontendStats) incrRejected(reason string) {
+	fs.proxyStats.incrRejected(reason)
+	fs.incrCounter(fs.prefix+".rejected."+reason, 1)
+}
+
+func (fs *frontendStats) incrClosed(reason string) {
+	fs.proxyStats.incrClosed(reason)
+	fs.incrCounter(fs.prefix+".closed."+reason, 1)
+}
+
+// frontendIoMeters returns the meters of the bytes the
+// frontend's clients send and receive.
+func (fs *frontendStats) frontendIoMeters() *ioMeters {
+	return fs.ioMeters(fs.prefix)
+}
+
+// frontendPacketCounters returns the counters of the
+// datagrams the frontend's clients send and receive.
+func (fs *frontendStats) frontendPacketCounters() *ioCounters {
+	return fs.ioCounters(fs.prefix)
+}
+
+func (fs *frontendStats) sessionsGauge(sessions *udpSessions) {
+	gauge := metrics.NewUint64Gauge(func() uint64 {
+		return sessions.count()
+	})
+	fs.registry.Register(fs.prefix+".sessions", gauge)
+}
+
+// panicGauge reports 1 while the pool's load balancer
+// is in panic mode, ignoring health, and 0 otherwise.
+func (fs *frontendStats) panicGauge(p *pool) {
+	fs.registry.Register(fs.prefix+".pool."+p.name()+".panic", metrics.NewUint64Gauge(func() uint64 {
+		if p.lb.Panic() {
+			return 1
+		}
+		return 0
+	}))
+}
+
+// tierGauges reports the state of one of a pool's priority tiers:
+// its healthy and total backends, the percentage of new connections
+// it's sent, and how many were sent to it by spilling over from
+// higher priority tiers.
+func (fs *frontendStats) tierGauges(p *pool, priority int) {
+	prefix := fmt.Sprintf("%s.pool.%s.tier.%d", fs.prefix, p.name(), priority)
+	tier := func() loadbalancer.TierStats {
+		for _, t := range p.lb.Tiers() {
+			if t.Priority == priority {
+				return t
+			}
+		}
+		return loadbalancer.TierStats{Priority: priority}
+	}
+	fs.registry.Register(prefix+".healthy", metrics.NewUint64Gauge(func() uint64 {
+		return uint64(tier().Healthy)
+	}))
+	fs.registry.Register(prefix+".total", metrics.NewUint64Gauge(func() uint64 {
+		return uint64(tier().Total)
+	}))
+	fs.registry.Register(prefix+".load_percent", metrics.NewUint64Gauge(func() uint64 {
+		return uint64(math.Round(tier().Load * 100))
+	}))
+	fs.registry.Register(prefix+".spillovers", metrics.NewUint64Gauge(func() uint64 {
+		return tier().Spillovers
+	}))
+}
+
+// backendPrefix names a backend's metrics, labelled by
+// the frontend and pool it was registered with, since
+// the same address may be in several pools.
+func (fs *frontendStats) backendPrefix(p *pool, addr string) string {
+	return fs.prefix + ".pool." + p.name() + ".backend." + metrics.SanitizeSegment(addr)
+}
+
+func (fs *frontendStats) incrBackendRequests(p *pool, addr string) {
+	fs.markMeter(fs.backendPrefix(p, addr)+".requests", 1)
+}
+
+func (fs *frontendStats) incrBackendErrors(p *pool, addr string) {
+	fs.markMeter(fs.backendPrefix(p, addr)+".errors", 1)
+}
+
+// backendIoMeters returns the meters of the bytes sent to
+// and received from a backend.
+func (fs *frontendStats) backendIoMeters(p *pool, addr string) *ioMeters {
+	return fs.ioMeters(fs.backendPrefix(p, addr))
+}
+
+// backendPacketCounters returns the counters of the
+// datagrams sent to and received from a backend.
+func (fs *frontendStats) backendPacketCounters(p *pool, addr string) *ioCounters {
+	return fs.ioCounters(fs.backendPrefix(p, addr))
+}
+
+func (fs *frontendStats) backendActiveConnsGauge(p *pool, backend *backend.Backend) {
+	gauge := metrics.NewUint64Gauge(func() uint64 {
+		return backend.ActiveConns()
+	})
+	fs.registry.Register(fs.backendPrefix(p, backend.Addr())+".active_connections", gauge)
+}
+
+func (fs *frontendStats) backendHealthGauge(p *pool, backend *backend.Backend) {
+	gauge := metrics.NewStringGauge(func() string {
+		return backend.State().String()
+	})
+	fs.registry.Register(fs.backendPrefix(p, backend.Addr())+".state", gauge)
+}
+
+func (fs *frontendStats) backendCircuitGauges(p *pool, backend *backend.Backend) {
+	prefix := fs.backendPrefix(p, backend.Addr())
+	fs.registry.Register(prefix+".pending_dials", metrics.NewUint64Gauge(func() uint64 {
+		return backend.PendingDials()
+	}))
+	fs.registry.Register(prefix+".circuit", metrics.NewStringGauge(func() string {
+		if backend.CircuitOpen() {
+			return "OPEN"
+		}
+		return "CLOSED"
+	}))
+	fs.registry.Register(prefix+".circuit_trips", metrics.NewUint64Gauge(func() uint64 {
+		return backend.Trips()
+	}))
+}
+
+// unregisterBackend removes the metrics of a backend
+// that's no longer in the pool.
+func (fs *frontendStats) unregisterBackend(p *pool, addr string) {
+	prefix := fs.backendPrefix(p, addr) + "."
+	for name := range fs.registry.All() {
+		if strings.HasPrefix(name, prefix) {
+			fs.registry.Unregister(name)
+		}
+	}
+}
+
 type ioStats struct {
 	tx uint64
 	rx uint64
 }
 
+// ioMeters meter bytes sent (tx) and received (rx)
+// as they're copied, so their rates track the traffic
+// of connections that are still open.
+type ioMeters struct {
+	tx metrics.Meter
+	rx metrics.Meter
+}
+
+// ioCounters count datagrams sent (tx) and received (rx)
+// as they're relayed.
+type ioCounters struct {
+	tx metrics.Counter
+	rx metrics.Counter
+}
+
 type proxyIoStats struct {
 	frontend *ioStats
 	backend  *ioStats
@@ -38,49 +232,65 @@ func newProxyIoStats() *proxyIoStats {
 }
 
 func (ps *proxyStats) incrRequests() {
-	ps.requests.Incr()
+	ps.requests.Mark(1)
 }
 
 func (ps *proxyStats) incrErrors() {
-	ps.errors.Incr()
+	ps.errors.Mark(1)
 }
 
-func (ps *proxyStats) incrFrontendIoStats(stats *ioStats) {
-	ps.incrIoStats("frontend", stats)
+func (ps *proxyStats) incrRejected(reason string) {
+	ps.incrCounter("rejected."+reason, 1)
 }
 
-func (ps *proxyStats) incrBackendIoStats(addr string, stats *ioStats) {
-	ps.incrIoStats("backend."+addr, stats)
+func (ps *proxyStats) incrClosed(reason string) {
+	ps.incrCounter("closed."+reason, 1)
 }
 
-func (ps *proxyStats) backendActiveConnsGauge(backend *backend.Backend) {
-	gauge := metrics.NewUint64Gauge(func() uint64 {
-		return backend.ActiveConns()
-	})
-	ps.registry.Register("backend."+backend.Addr()+".active_connections", gauge)
+func (ps *proxyStats) ioMeters(name string) *ioMeters {
+	return &ioMeters{
+		tx: ps.loadMeter(name + ".io.tx"),
+		rx: ps.loadMeter(name + ".io.rx"),
+	}
 }
 
-func (ps *proxyStats) backendHealthGauge(backend *backend.Backend) {
-	gauge := metrics.NewStringGauge(func() string {
-		return backend.State().String()
-	})
-	ps.registry.Register("backend."+backend.Addr()+".state", gauge)
+func (ps *proxyStats) ioCounters(name string) *ioCounters {
+	return &ioCounters{
+		tx: ps.loadCounter(name + ".packets.tx"),
+		rx: ps.loadCounter(name + ".packets.rx"),
+	}
 }
 
 // TODO: don't pessimistically create new metrics.
 // Most of the time they'll already exist.
-func (ps *proxyStats) incrIoStats(name string, stats *ioStats) {
-	incr := func(suffix string, bytes *uint64) {
-		counter, err := ps.registry.LoadOrRegisterCounter(
-			name+suffix,
-			metrics.NewCounter(),
-		)
-		if err != nil {
-			logger.Error(err)
-		} else {
-			counter.Add(*bytes)
-		}
+func (ps *proxyStats) incrCounter(name string, delta uint64) {
+	ps.loadCounter(name).Add(delta)
+}
+
+// loadCounter returns the counter registered for name, registering
+// it if need be. If another kind of metric has the name, the
+// error is logged and an unregistered counter returned.
+func (ps *proxyStats) loadCounter(name string) metrics.Counter {
+	counter, err := ps.registry.LoadOrRegisterCounter(name, metrics.NewCounter())
+	if err != nil {
+		logger.Error(err)
+		return metrics.NewCounter()
+	}
+	return counter
+}
+
+func (ps *proxyStats) markMeter(name string, n uint64) {
+	ps.loadMeter(name).Mark(n)
+}
+
+// loadMeter returns the meter registered for name, registering
+// it if need be. If another kind of metric has the name, the
+// error is logged and an unregistered meter returned.
+func (ps *proxyStats) loadMeter(name string) metrics.Meter {
+	meter, err := ps.registry.LoadOrRegisterMeter(name, metrics.NewMeter())
+	if err != nil {
+		logger.Error(err)
+		return metrics.NewMeter()
 	}
-	incr(".io.tx", &stats.tx)
-	incr(".io.rx", &stats.rx)
+	return meter
 }
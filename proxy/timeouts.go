@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons a proxied connection was closed by the proxy.
+const (
+	closedIdle      = "idle_timeout"
+	closedFirstByte = "first_byte_timeout"
+	closedLifetime  = "max_lifetime"
+	closedHalfClose = "half_close_timeout"
+)
+
+// How long a half-closed connection may go without traffic from
+// the side still sending, unless the frontend configures it.
+const defaultHalfCloseTimeout = 1 * time.Minute
+
+// connWatchdog closes a proxied connection pair when it's idle,
+// the client hasn't sent its first byte in time, or it has
+// outlived its maximum lifetime, recording which happened.
+type connWatchdog struct {
+	src net.Conn
+	dst net.Conn
+	// Unix nanoseconds of the last read in either direction.
+	lastActive int64
+	// Set once the client has sent any data.
+	gotFirstByte int32
+	// Guards the timers, reason and done.
+	lock      sync.Mutex
+	timers    []*time.Timer
+	idleTimer *time.Timer
+	reason    string
+	done      bool
+}
+
+// watchConn starts enforcing the frontend's connection timeouts
+// on src and dst. It returns nil if none are configured.
+func (f *frontend) watchConn(src net.Conn, dst net.Conn) *connWatchdog {
+	if f.cfg.IdleTimeout <= 0 && f.cfg.FirstByteTimeout <= 0 && f.cfg.MaxLifetime <= 0 {
+		return nil
+	}
+
+	w := &connWatchdog{src: src, dst: dst}
+	w.touch()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if f.cfg.IdleTimeout > 0 {
+		w.idleTimer = time.AfterFunc(f.cfg.IdleTimeout, func() {
+			w.checkIdle(f.cfg.IdleTimeout)
+		})
+		w.timers = append(w.timers, w.idleTimer)
+	}
+	if f.cfg.FirstByteTimeout > 0 {
+		w.timers = append(w.timers, time.AfterFunc(f.cfg.FirstByteTimeout, func() {
+			if atomic.LoadInt32(&w.gotFirstByte) == 0 {
+				w.expire(closedFirstByte)
+			}
+		}))
+	}
+	if f.cfg.MaxLifetime > 0 {
+		w.timers = append(w.timers, time.AfterFunc(f.cfg.MaxLifetime, func() {
+			w.expire(closedLifetime)
+		}))
+	}
+	return w
+}
+
+func (w *connWatchdog) touch() {
+	atomic.StoreInt64(&w.lastActive, time.Now().UnixNano())
+}
+
+func (w *connWatchdog) idleFor() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&w.lastActive))
+}
+
+// checkIdle expires the connections if there hasn't been any
+// traffic for the idle timeout, or otherwise waits out the rest.
+func (w *connWatchdog) checkIdle(timeout time.Duration) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if remaining := timeout - w.idleFor(); remaining > 0 {
+		if !w.done {
+			w.idleTimer.Reset(remaining)
+		}
+		return
+	}
+	w.close(closedIdle)
+}
+
+func (w *connWatchdog) expire(reason string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.close(reason)
+}
+
+// close closes both connections, unless they've already
+// finished. The caller must hold the lock.
+func (w *connWatchdog) close(reason string) {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.reason = reason
+	w.src.Close()
+	w.dst.Close()
+}
+
+// stop cancels the timeouts, returning the reason the
+// connections were closed, or "" if none expired.
+func (w *connWatchdog) stop() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.done = true
+	for _, timer := range w.timers {
+		timer.Stop()
+	}
+	return w.reason
+}
+
+// watchedConn records reads on a connection with its watchdog.
+// Wrapping a connection hides it from copyConn's splice fast
+// path, so only connections with timeouts pay for it.
+type watchedConn struct {
+	net.Conn
+	w      *connWatchdog
+	client bool
+}
+
+func (c *watchedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.w.touch()
+		if c.client {
+			atomic.StoreInt32(&c.w.gotFirstByte, 1)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/metrics"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestConnectionTimeouts(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *FrontendConfig)
+		// Whether the client talks before going quiet.
+		talk   bool
+		reason string
+	}{
+		{
+			name:      "idle",
+			configure: func(cfg *FrontendConfig) { cfg.IdleTimeout = 300 * time.Millisecond },
+			talk:      true,
+			reason:    closedIdle,
+		},
+		{
+			name:      "first byte",
+			configure: func(cfg *FrontendConfig) { cfg.FirstByteTimeout = 100 * time.Millisecond },
+			reason:    closedFirstByte,
+		},
+		{
+			name:      "max lifetime",
+			configure: func(cfg *FrontendConfig) { cfg.MaxLifetime = 400 * time.Millisecond },
+			talk:      true,
+			reason:    closedLifetime,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backendListener := proxytesting.NewLocalListener(t)
+			defer backendListener.Close()
+
+			tcpProxy := newSimpleTCPProxy(t, []string{backendListener.Addr().String()})
+			test.configure(&tcpProxy.frontends[0].cfg)
+
+			err := tcpProxy.Start()
+			defer tcpProxy.Shutdown()
+			check(t, err)
+
+			client, err := net.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String())
+			check(t, err)
+			defer client.Close()
+
+			backend, err := backendListener.Accept()
+			check(t, err)
+			defer backend.Close()
+
+			if test.talk {
+				// Traffic spread over longer than the idle timeout
+				// keeps the connection open.
+				for i := 0; i < 3; i++ {
+					check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+					check(t, assertSendAndReceiveMessage(backend, client, "hello!"))
+					time.Sleep(100 * time.Millisecond)
+				}
+			}
+
+			// The proxy closes the connection once the timeout expires.
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, err = ioutil.ReadAll(client)
+			check(t, err)
+
+			// Give the proxy a moment to record the close.
+			time.Sleep(50 * time.Millisecond)
+			stats := tcpProxy.Stats()
+			assertMetric(t, stats, "closed."+test.reason, uint64(1))
+			assertMetric(t, stats, "frontend.default.closed."+test.reason, uint64(1))
+			assertMetric(t, stats, "errors", uint64(0))
+			assertMetric(t, stats, "frontend.default.pool.default.backend."+metrics.SanitizeSegment(backendListener.Addr().String())+".active_connections", uint64(0))
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestMutualTLSAuthorization(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcp-proxy")
+	check(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := proxytesting.NewCertificateAuthority(t)
+	certFile, keyFile := proxytesting.WriteKeyPair(t, dir, "server", ca.Issue(t, "proxy", "localhost", "127.0.0.1", "::1"))
+
+	backendListener := proxytesting.NewLocalListener(t)
+	defer backendListener.Close()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:             "default",
+			Laddr:            "localhost:0",
+			Timeout:          1 * time.Second,
+			HandshakeTimeout: 1 * time.Second,
+			TLS: TLSConfig{
+				CertFile:          certFile,
+				KeyFile:           keyFile,
+				ClientCAFile:      ca.WriteCA(t, dir),
+				RequireClientCert: true,
+			},
+			Pools: []PoolConfig{{
+				Name:      "db",
+				Backends:  []string{backendListener.Addr().String()},
+				Authorize: []string{"uri:spiffe://example.org/ns/prod/*"},
+			}},
+			DefaultPool: "db",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	dial := func(cert tls.Certificate) (*tls.Conn, error) {
+		return tls.Dial("tcp", tcpProxy.frontends[0].ln.Addr().String(), &tls.Config{
+			ServerName:   "localhost",
+			RootCAs:      ca.Pool,
+			Certificates: []tls.Certificate{cert},
+		})
+	}
+
+	// An authorized client is proxied to the backend in plaintext.
+	client, err := dial(ca.Issue(t, "db-client", "spiffe://example.org/ns/prod/sa/db-client"))
+	check(t, err)
+	defer client.Close()
+
+	_, err = io.WriteString(client, "hi!")
+	check(t, err)
+
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(backend, client, "hello!"))
+
+	// An unauthorized client is disconnected.
+	client, err = dial(ca.Issue(t, "dev-client", "spiffe://example.org/ns/dev/sa/db-client"))
+	check(t, err)
+	defer client.Close()
+
+	client.SetReadDeadline(time.Now().Add(1 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	if err == nil || isTimeout(err) {
+		t.Errorf("expected unauthorized client to be disconnected, got %v", err)
+	}
+
+	waitForMetric(t, tcpProxy, "rejected.unauthorized", uint64(1))
+}
+
+func TestAuthorizationRequiresClientCA(t *testing.T) {
+	_, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:  "default",
+			Laddr: "localhost:0",
+			Pools: []PoolConfig{{Name: "db", Authorize: []string{"cn:db-client"}}},
+			Lb:    loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	if err == nil {
+		t.Error("expected an error authorizing clients without verifying certificates")
+	}
+}
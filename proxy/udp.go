@@ -0,0 +1,358 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/backend"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultUDPIdleTimeout = 30 * time.Second
+	maxDatagramSize       = 65535
+	// How many of a client's datagrams are held while its
+	// session is set up; any more are dropped.
+	maxQueuedDatagrams = 16
+)
+
+// udpSession relays datagrams between a client,
+// identified by its source address, and a backend.
+// A session is set up in the background, so a client
+// waiting for a backend doesn't hold up the others.
+type udpSession struct {
+	client  net.Addr
+	// Guards opened and queued during setup.
+	lock   sync.Mutex
+	opened bool
+	// The client's datagrams received before the session opened.
+	queued [][]byte
+	pool    *pool
+	backend *backend.Backend
+	conn    net.Conn
+	// Releases the session's rate limits.
+	release func()
+	// Unix nanoseconds of the last datagram in either direction.
+	lastActive int64
+	bytes      *proxyIoStats
+	packets    *proxyIoStats
+	// The client's and backend's sides of the traffic.
+	frontendIo *udpIo
+	backendIo  *udpIo
+	record     *connRecord
+	// Set when the session is closed by closeAll.
+	shutdown  int32
+	closeOnce sync.Once
+}
+
+// enqueue holds a copy of datagram until the session has opened,
+// returning false once it has and datagrams can be forwarded.
+func (s *udpSession) enqueue(datagram []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.opened {
+		return false
+	}
+	if len(s.queued) < maxQueuedDatagrams {
+		s.queued = append(s.queued, append([]byte(nil), datagram...))
+	}
+	return true
+}
+
+func (s *udpSession) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+func (s *udpSession) idleFor() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&s.lastActive))
+}
+
+// udpIo counts one side of a session's traffic as it's relayed:
+// its totals, for the access log, and its frontend's or
+// backend's metrics.
+type udpIo struct {
+	bytes   *ioStats
+	packets *ioStats
+	meters  *ioMeters
+	counts  *ioCounters
+}
+
+func (u *udpIo) sent(n int) {
+	atomic.AddUint64(&u.bytes.tx, uint64(n))
+	atomic.AddUint64(&u.packets.tx, 1)
+	u.meters.tx.Mark(uint64(n))
+	u.counts.tx.Add(1)
+}
+
+func (u *udpIo) received(n int) {
+	atomic.AddUint64(&u.bytes.rx, uint64(n))
+	atomic.AddUint64(&u.packets.rx, 1)
+	u.meters.rx.Mark(uint64(n))
+	u.counts.rx.Add(1)
+}
+
+type udpSessions struct {
+	lock     sync.Mutex
+	sessions map[string]*udpSession
+	// Done once each session has ended.
+	wg sync.WaitGroup
+}
+
+func newUDPSessions() *udpSessions {
+	return &udpSessions{
+		lock:     sync.Mutex{},
+		sessions: make(map[string]*udpSession),
+	}
+}
+
+func (us *udpSessions) get(client net.Addr) *udpSession {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	return us.sessions[client.String()]
+}
+
+func (us *udpSessions) add(s *udpSession) {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	us.wg.Add(1)
+	us.sessions[s.client.String()] = s
+}
+
+func (us *udpSessions) remove(s *udpSession) {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	if us.sessions[s.client.String()] == s {
+		delete(us.sessions, s.client.String())
+	}
+}
+
+func (us *udpSessions) count() uint64 {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	return uint64(len(us.sessions))
+}
+
+// closeAll closes every session's backend connection, causing
+// its relay goroutine to end the session, and waits for them.
+// Sessions still being set up are closed once they open.
+func (us *udpSessions) closeAll() {
+	us.lock.Lock()
+	for _, s := range us.sessions {
+		atomic.StoreInt32(&s.shutdown, 1)
+		s.lock.Lock()
+		if s.opened {
+			s.conn.Close()
+		}
+		s.lock.Unlock()
+	}
+	us.lock.Unlock()
+	us.wg.Wait()
+}
+
+func (f *frontend) readTimeout(buf []byte, timeout time.Duration) (int, net.Addr, error) {
+	err := f.pc.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return 0, nil, err
+	}
+	return f.pc.ReadFrom(buf)
+}
+
+func (f *frontend) servePackets(shutdownc <-chan struct{}) error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		select {
+		case <-shutdownc:
+			return nil
+		default:
+			// ReadFrom() is blocking. Adds a timeout
+			// to ensure we're still checking for
+			// shutdown messages if the proxy is idle.
+			n, client, err := f.readTimeout(buf, 3*time.Second)
+			if isTimeout(err) {
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to read on %s", f.name())
+			}
+
+			session := f.sessions.get(client)
+			if session == nil {
+				session = &udpSession{client: client, record: f.newConnRecord(client)}
+				f.sessions.add(session)
+				go f.openSession(session)
+			}
+			if !session.enqueue(buf[:n]) {
+				f.forward(session, buf[:n])
+			}
+		}
+	}
+}
+
+// openSession picks a backend for a new client's session,
+// forwards the datagrams queued meanwhile and relays replies
+// until the session ends. If no backend can be had, the
+// session is dropped along with its queued datagrams.
+func (f *frontend) openSession(s *udpSession) {
+	err := f.dialSession(s)
+	if err != nil {
+		f.sessions.remove(s)
+		f.logFailure(s.client, err)
+		f.logAccess(s.record, err)
+		f.sessions.wg.Done()
+		return
+	}
+
+	s.lock.Lock()
+	for _, datagram := range s.queued {
+		f.forward(s, datagram)
+	}
+	s.queued = nil
+	s.opened = true
+	s.lock.Unlock()
+	// closeAll may have missed the session while it was opening.
+	if atomic.LoadInt32(&s.shutdown) == 1 {
+		s.conn.Close()
+	}
+	f.relayReplies(s)
+}
+
+// dialSession checks that the session's client is allowed
+// and connects it to a backend, waiting for one to free
+// up if the pool has a queue timeout.
+func (f *frontend) dialSession(s *udpSession) error {
+	r := s.record
+	client := s.client
+	f.log.Debugw("new session", "id", r.id, "client", client)
+	f.stats.incrRequests()
+
+	err := f.checkAccess(client)
+	if err != nil {
+		return err
+	}
+
+	release, err := f.limit(client)
+	if err != nil {
+		return err
+	}
+
+	pool, err := f.router.route("")
+	if err != nil {
+		release()
+		return err
+	}
+	r.pool = pool.name()
+
+	backend, err := pool.nextBackend(nil)
+	if err != nil {
+		release()
+		return err
+	}
+	r.backend = backend.Addr()
+
+	f.stats.incrBackendRequests(pool, backend.Addr())
+	dialStart := time.Now()
+	conn, err := net.DialTimeout("udp", backend.Addr(), f.cfg.Timeout)
+	r.dialTime = time.Since(dialStart)
+	backend.EndDial(err == nil)
+	if err != nil {
+		f.stats.incrBackendErrors(pool, backend.Addr())
+		pool.release()
+		release()
+		return errors.Wrapf(err, "error dialing backend %s", backend.Addr())
+	}
+
+	f.log.Debugw("opened session", "id", r.id, "client", client, "backend", backend.Addr(), "active", backend.ActiveConns())
+
+	s.pool = pool
+	s.backend = backend
+	s.conn = conn
+	s.release = release
+	s.bytes = newProxyIoStats()
+	s.packets = newProxyIoStats()
+	s.frontendIo = &udpIo{s.bytes.frontend, s.packets.frontend, f.stats.frontendIoMeters(), f.stats.frontendPacketCounters()}
+	s.backendIo = &udpIo{s.bytes.backend, s.packets.backend, f.stats.backendIoMeters(pool, backend.Addr()), f.stats.backendPacketCounters(pool, backend.Addr())}
+	r.bytes = s.bytes
+	s.touch()
+	return nil
+}
+
+func (f *frontend) forward(s *udpSession, datagram []byte) {
+	s.touch()
+	n, err := s.conn.Write(datagram)
+	if err != nil {
+		f.log.Errorw("error proxying datagram", "from", s.client, "to", s.conn.RemoteAddr(), "error", err)
+		f.stats.incrErrors()
+		f.stats.incrBackendErrors(s.pool, s.backend.Addr())
+		return
+	}
+	s.frontendIo.received(n)
+	s.backendIo.sent(n)
+}
+
+// relayReplies sends datagrams from the backend back to the
+// client until the session is idle or the backend errors.
+func (f *frontend) relayReplies(s *udpSession) {
+	var err error
+	defer func() {
+		f.closeSession(s, err)
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(f.cfg.IdleTimeout - s.idleFor()))
+		var n int
+		n, err = s.conn.Read(buf)
+		if isTimeout(err) {
+			if s.idleFor() >= f.cfg.IdleTimeout {
+				s.record.reason, s.record.timeout = closedTimeout, closedIdle
+				err = nil
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if atomic.LoadInt32(&s.shutdown) == 1 {
+				s.record.reason = closedShutdown
+				err = nil
+				return
+			}
+			f.log.Errorw("error reading datagram", "id", s.record.id, "from", s.conn.RemoteAddr(), "error", err)
+			f.stats.incrErrors()
+			f.stats.incrBackendErrors(s.pool, s.backend.Addr())
+			return
+		}
+
+		s.touch()
+		s.backendIo.received(n)
+
+		_, writeErr := f.pc.WriteTo(buf[:n], s.client)
+		if writeErr != nil {
+			f.log.Errorw("error proxying datagram", "from", s.conn.RemoteAddr(), "to", s.client, "error", writeErr)
+			f.stats.incrErrors()
+			continue
+		}
+		s.frontendIo.sent(n)
+	}
+}
+
+// closeSession ends s, recording it in the access log
+// as closed by err if it's not nil.
+func (f *frontend) closeSession(s *udpSession, err error) {
+	s.closeOnce.Do(func() {
+		f.sessions.remove(s)
+		s.conn.Close()
+		s.backend.DecrActiveConns()
+		s.pool.release()
+		s.release()
+
+		f.log.Debugw("closed session", "id", s.record.id,
+			"client", s.client, "backend", s.conn.RemoteAddr(),
+			"packets_rx", atomic.LoadUint64(&s.packets.frontend.rx), "packets_tx", atomic.LoadUint64(&s.packets.frontend.tx),
+			"bytes_rx", atomic.LoadUint64(&s.bytes.frontend.rx), "bytes_tx", atomic.LoadUint64(&s.bytes.frontend.tx),
+		)
+		f.logAccess(s.record, err)
+		f.sessions.wg.Done()
+	})
+}
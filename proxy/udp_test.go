@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	"github.com/jmuia/tcp-proxy/metrics"
+	"github.com/pkg/errors"
+)
+
+func TestUDPProxy(t *testing.T) {
+	// Set up a UDP echo backend to proxy to.
+	backendConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check(t, err)
+	defer backendConn.Close()
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := backendConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backendConn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Network:     "udp",
+			Laddr:       "127.0.0.1:0",
+			IdleTimeout: 100 * time.Millisecond,
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", Backends: []string{backendConn.LocalAddr().String()}}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	check(t, err)
+
+	client, err := net.Dial("udp", tcpProxy.frontends[0].addr().String())
+	check(t, err)
+	defer client.Close()
+
+	// Datagrams are relayed to the backend and back.
+	for _, msg := range []string{"ping", "pong!"} {
+		_, err = client.Write([]byte(msg))
+		check(t, err)
+
+		client.SetReadDeadline(time.Now().Add(1 * time.Second))
+		buf := make([]byte, maxDatagramSize)
+		n, err := client.Read(buf)
+		check(t, err)
+		if string(buf[:n]) != msg {
+			t.Errorf("expected echo %q, got %q", msg, buf[:n])
+		}
+	}
+
+	// Both datagrams belong to one session.
+	stats := tcpProxy.Stats()
+	backendMetricPrefix := "frontend.default.pool.default.backend." + metrics.SanitizeSegment(backendConn.LocalAddr().String()) + "."
+	assertMetric(t, stats, "requests", uint64(1))
+	assertMetric(t, stats, "frontend.default.sessions", uint64(1))
+	assertMetric(t, stats, backendMetricPrefix+"active_connections", uint64(1))
+
+	// Traffic is counted as it's relayed, while the session's open.
+	waitForMetric(t, tcpProxy, "frontend.default.packets.tx", uint64(2))
+	stats = tcpProxy.Stats()
+	assertMetric(t, stats, "frontend.default.packets.rx", uint64(2))
+	assertMetric(t, stats, "frontend.default.io.rx", uint64(len("ping")+len("pong!")))
+	assertMetric(t, stats, backendMetricPrefix+"io.tx", uint64(len("ping")+len("pong!")))
+	assertMetric(t, stats, "frontend.default.sessions", uint64(1))
+
+	// The session ends once it's idle.
+	time.Sleep(300 * time.Millisecond)
+	stats = tcpProxy.Stats()
+	assertMetric(t, stats, "frontend.default.sessions", uint64(0))
+	assertMetric(t, stats, backendMetricPrefix+"active_connections", uint64(0))
+	assertMetric(t, stats, "frontend.default.packets.rx", uint64(2))
+	assertMetric(t, stats, "frontend.default.packets.tx", uint64(2))
+	assertMetric(t, stats, "frontend.default.io.rx", uint64(len("ping")+len("pong!")))
+	assertMetric(t, stats, backendMetricPrefix+"packets.tx", uint64(2))
+	assertMetric(t, stats, backendMetricPrefix+"io.rx", uint64(len("ping")+len("pong!")))
+}
+
+func TestUDPQueuedSessionDoesntBlockOthers(t *testing.T) {
+	backendConn := newUDPEchoBackend(t)
+	defer backendConn.Close()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:    "default",
+			Network: "udp",
+			Laddr:   "127.0.0.1:0",
+			Timeout: 1 * time.Second,
+			Pools: []PoolConfig{{
+				Name:               "default",
+				Backends:           []string{backendConn.LocalAddr().String()},
+				MaxConnsPerBackend: 1,
+				QueueTimeout:       2 * time.Second,
+			}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+	check(t, tcpProxy.Start())
+	defer tcpProxy.Shutdown()
+
+	first, err := net.Dial("udp", tcpProxy.frontends[0].addr().String())
+	check(t, err)
+	defer first.Close()
+	check(t, assertUDPEcho(first, "ping"))
+
+	// The second client waits for the backend's only slot...
+	second, err := net.Dial("udp", tcpProxy.frontends[0].addr().String())
+	check(t, err)
+	defer second.Close()
+	_, err = second.Write([]byte("queued"))
+	check(t, err)
+
+	// ...without holding up the first client's datagrams.
+	start := time.Now()
+	check(t, assertUDPEcho(first, "pong!"))
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("expected the first client's datagram to be relayed promptly, took %v", waited)
+	}
+}
+
+// newUDPEchoBackend listens for datagrams and sends them back.
+func newUDPEchoBackend(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check(t, err)
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+// assertUDPEcho sends msg on conn and expects it back.
+func assertUDPEcho(conn net.Conn, msg string) error {
+	_, err := conn.Write([]byte(msg))
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	buf := make([]byte, maxDatagramSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	if string(buf[:n]) != msg {
+		return errors.New(fmt.Sprintf("expected echo %q, got %q", msg, buf[:n]))
+	}
+	return nil
+}
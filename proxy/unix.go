@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"time"
+
+	logger "github.com/jmuia/tcp-proxy/logging"
+	"github.com/pkg/errors"
+)
+
+// listenUnix listens on a Unix domain socket at path, replacing a
+// stale socket left behind by a previous process. The socket file
+// is removed when the listener is closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	err := removeStaleSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(true)
+
+	if mode != 0 {
+		err = os.Chmod(path, mode)
+		if err != nil {
+			ln.Close()
+			return nil, errors.Wrapf(err, "failed to set permissions on %s", path)
+		}
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket at path if nothing is
+// accepting connections on it. Files that aren't sockets, and
+// sockets that are still in use, are left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New(path + " exists and is not a socket")
+	}
+
+	conn, err := net.DialTimeout("unix", path, 1*time.Second)
+	if err == nil {
+		conn.Close()
+		return errors.New(path + " is in use")
+	}
+
+	logger.Infow("removing stale socket", "path", path)
+	return os.Remove(path)
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmuia/tcp-proxy/loadbalancer"
+	proxytesting "github.com/jmuia/tcp-proxy/testing"
+)
+
+func TestUnixSockets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcp-proxy")
+	check(t, err)
+	defer os.RemoveAll(dir)
+
+	backendListener := proxytesting.NewLocalUnixListener(t, dir)
+	defer backendListener.Close()
+
+	// Leave a stale socket behind where the proxy will listen.
+	path := filepath.Join(dir, "proxy.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	check(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:        "default",
+			Laddr:       "unix:" + path,
+			SocketMode:  0600,
+			Timeout:     1 * time.Second,
+			Pools:       []PoolConfig{{Name: "default", Backends: []string{"unix:" + backendListener.Addr().String()}}},
+			DefaultPool: "default",
+			Lb:          loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	check(t, err)
+
+	info, err := os.Stat(path)
+	check(t, err)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected socket permissions 0600, were %v", info.Mode().Perm())
+	}
+
+	client, err := net.Dial("unix", path)
+	check(t, err)
+	defer client.Close()
+
+	backend, err := backendListener.Accept()
+	check(t, err)
+	defer backend.Close()
+	check(t, assertSendAndReceiveMessage(client, backend, "hi!"))
+	check(t, assertSendAndReceiveMessage(backend, client, "hello!"))
+
+	// The socket is removed on shutdown.
+	tcpProxy.Shutdown()
+	select {
+	case <-tcpProxy.exitc:
+	case <-time.NewTimer(5 * time.Second).C:
+		t.Fatal("proxy didn't exit in 5s")
+	}
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed on shutdown: %v", err)
+	}
+}
+
+func TestUnixSocketInUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcp-proxy")
+	check(t, err)
+	defer os.RemoveAll(dir)
+
+	inUse := proxytesting.NewLocalUnixListener(t, dir)
+	defer inUse.Close()
+
+	tcpProxy, err := NewTCPProxy(Config{
+		Frontends: []FrontendConfig{{
+			Name:  "default",
+			Laddr: "unix:" + inUse.Addr().String(),
+			Lb:    loadbalancer.Config{Type: loadbalancer.P2C_TYPE},
+		}},
+	})
+	check(t, err)
+
+	err = tcpProxy.Start()
+	defer tcpProxy.Shutdown()
+	if err == nil {
+		t.Error("expected proxy to refuse to replace a socket that's in use")
+	}
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket that refills at rate tokens
+// per second, holding at most burst tokens.
+type Bucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewBucket returns a full bucket. A burst less than
+// one defaults to the rate, rounded up.
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	b := &Bucket{
+		rate:  rate,
+		burst: float64(burst),
+		now:   time.Now,
+	}
+	b.tokens = b.burst
+	b.last = b.now()
+	return b
+}
+
+// Reserve takes a token, returning how long the caller must wait
+// before using it. If that's longer than maxWait, no token is
+// taken and ok is false.
+func (b *Bucket) Reserve(maxWait time.Duration) (wait time.Duration, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill()
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+
+	// Tokens go negative to queue reservations
+	// behind ones that are already waiting.
+	wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait > maxWait {
+		return 0, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// Refund returns a token taken by Reserve that wasn't used.
+func (b *Bucket) Refund() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// full reports whether the bucket has refilled completely,
+// in which case it's equivalent to a new bucket.
+func (b *Bucket) full() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	return b.tokens >= b.burst
+}
+
+func (b *Bucket) refill() {
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(10, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	// The burst is available immediately.
+	for i := 0; i < 2; i++ {
+		wait, ok := b.Reserve(0)
+		if !ok || wait != 0 {
+			t.Fatalf("expected token %d to be available, waited %v (%v)", i, wait, ok)
+		}
+	}
+
+	// Then tokens aren't available without waiting.
+	if _, ok := b.Reserve(0); ok {
+		t.Error("expected empty bucket to refuse a reservation")
+	}
+
+	// Waiting reservations queue behind each other.
+	wait, ok := b.Reserve(time.Second)
+	if !ok || wait != 100*time.Millisecond {
+		t.Errorf("expected to wait 100ms, waited %v (%v)", wait, ok)
+	}
+	wait, ok = b.Reserve(time.Second)
+	if !ok || wait != 200*time.Millisecond {
+		t.Errorf("expected to wait 200ms, waited %v (%v)", wait, ok)
+	}
+
+	// The bucket refills at the rate, up to the burst.
+	now = now.Add(10 * time.Second)
+	if !b.full() {
+		t.Error("expected bucket to refill")
+	}
+	if b.tokens != 2 {
+		t.Errorf("expected bucket to hold 2 tokens, held %v", b.tokens)
+	}
+}
+
+func TestBucketDefaultBurst(t *testing.T) {
+	b := NewBucket(2.5, 0)
+	if b.burst != 3 {
+		t.Errorf("expected burst to default to 3, was %v", b.burst)
+	}
+}
@@ -0,0 +1,266 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrClosed is returned for a connection that was
+// waiting on a limit when the limiter was closed.
+var ErrClosed = errors.New("rate limiter closed")
+
+// Reasons a connection exceeded a limit.
+const (
+	RateLimited       = "rate_limited"
+	SourceRateLimited = "source_rate_limited"
+	TooManyConns      = "too_many_connections"
+)
+
+// How often idle sources are forgotten.
+const sweepInterval = 1 * time.Minute
+
+// Config limits new connections per second across all clients
+// (Rate) and per source (SourceRate), and the number of
+// concurrent connections per source (MaxConnsPerSource).
+// Zero disables a limit. Sources are client IPs, grouped by
+// the IPv4Prefix and IPv6Prefix lengths if they're set.
+//
+// Connections over a limit are closed, or with DELAY_POLICY,
+// held for up to MaxDelay until they're within the limits.
+type Config struct {
+	Rate              float64
+	Burst             int
+	SourceRate        float64
+	SourceBurst       int
+	MaxConnsPerSource int
+	IPv4Prefix        int
+	IPv6Prefix        int
+	Policy            Policy
+	MaxDelay          time.Duration
+}
+
+// Enabled reports whether any limit is set.
+func (cfg Config) Enabled() bool {
+	return cfg.Rate > 0 || cfg.SourceRate > 0 || cfg.MaxConnsPerSource > 0
+}
+
+// ExceededError is returned for a connection over a limit.
+type ExceededError struct {
+	Reason string
+	Source string
+}
+
+func (e *ExceededError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("connection %s", e.Reason)
+	}
+	return fmt.Sprintf("connection from %s %s", e.Source, e.Reason)
+}
+
+// source is the limiter state for a single source. It's
+// forgotten once it has no connections and its bucket is full.
+type source struct {
+	bucket *Bucket
+	// Holds a value per concurrent connection.
+	slots chan struct{}
+	// Connections holding or awaiting a slot.
+	users int
+}
+
+// Limiter admits connections within its limits.
+type Limiter struct {
+	cfg       Config
+	global    *Bucket
+	lock      sync.Mutex
+	sources   map[string]*source
+	lastSweep time.Time
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewLimiter(cfg Config) *Limiter {
+	if cfg.Policy == 0 {
+		cfg.Policy = CLOSE_POLICY
+	}
+	if cfg.Policy == CLOSE_POLICY {
+		cfg.MaxDelay = 0
+	}
+	l := &Limiter{
+		cfg:       cfg,
+		sources:   make(map[string]*source),
+		lastSweep: time.Now(),
+		closed:    make(chan struct{}),
+	}
+	if cfg.Rate > 0 {
+		l.global = NewBucket(cfg.Rate, cfg.Burst)
+	}
+	return l
+}
+
+// Acquire admits a new connection from ip, waiting if the policy
+// allows it. The returned release func must be called once the
+// connection closes. A nil ip is only subject to the global rate.
+func (l *Limiter) Acquire(ip net.IP) (release func(), err error) {
+	deadline := time.Now().Add(l.cfg.MaxDelay)
+
+	var key string
+	var s *source
+	if ip != nil && (l.cfg.SourceRate > 0 || l.cfg.MaxConnsPerSource > 0) {
+		key = l.key(ip)
+		s = l.use(key)
+	}
+	done := func() {
+		if s != nil {
+			l.unuse(key, s)
+		}
+	}
+	// Tokens taken so far are refunded if the
+	// connection is rejected by a later limit.
+	var taken []*Bucket
+	reject := func(err error) (func(), error) {
+		for _, b := range taken {
+			b.Refund()
+		}
+		done()
+		return nil, err
+	}
+
+	// The source's own rate is checked first, so that a
+	// misbehaving client doesn't use up the global rate.
+	if s != nil && s.bucket != nil {
+		if err := l.reserve(s.bucket, deadline, SourceRateLimited, key); err != nil {
+			return reject(err)
+		}
+		taken = append(taken, s.bucket)
+	}
+	if l.global != nil {
+		if err := l.reserve(l.global, deadline, RateLimited, key); err != nil {
+			return reject(err)
+		}
+		taken = append(taken, l.global)
+	}
+
+	if s != nil && s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			select {
+			case s.slots <- struct{}{}:
+			case <-timer.C:
+				return reject(&ExceededError{TooManyConns, key})
+			case <-l.closed:
+				return reject(ErrClosed)
+			}
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if s != nil && s.slots != nil {
+				<-s.slots
+			}
+			done()
+		})
+	}, nil
+}
+
+// reserve takes a token from b, sleeping until it may be used.
+func (l *Limiter) reserve(b *Bucket, deadline time.Time, reason string, key string) error {
+	wait, ok := b.Reserve(time.Until(deadline))
+	if !ok {
+		return &ExceededError{reason, key}
+	}
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-l.closed:
+		b.Refund()
+		return ErrClosed
+	}
+}
+
+// Close stops connections waiting on a limit, which fail with
+// ErrClosed. Connections that don't have to wait are still admitted.
+func (l *Limiter) Close() {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+}
+
+// key returns the source that ip belongs to.
+func (l *Limiter) key(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return prefixKey(ip4, l.cfg.IPv4Prefix, 32)
+	}
+	return prefixKey(ip, l.cfg.IPv6Prefix, 128)
+}
+
+func prefixKey(ip net.IP, ones int, bits int) string {
+	if ones <= 0 || ones >= bits {
+		return ip.String()
+	}
+	mask := net.CIDRMask(ones, bits)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+func (l *Limiter) use(key string) *source {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if time.Since(l.lastSweep) > sweepInterval {
+		l.sweep()
+	}
+
+	s, ok := l.sources[key]
+	if !ok {
+		s = &source{}
+		if l.cfg.SourceRate > 0 {
+			s.bucket = NewBucket(l.cfg.SourceRate, l.cfg.SourceBurst)
+		}
+		if l.cfg.MaxConnsPerSource > 0 {
+			s.slots = make(chan struct{}, l.cfg.MaxConnsPerSource)
+		}
+		l.sources[key] = s
+	}
+	s.users++
+	return s
+}
+
+func (l *Limiter) unuse(key string, s *source) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	s.users--
+	if s.users == 0 && (s.bucket == nil || s.bucket.full()) {
+		delete(l.sources, key)
+	}
+}
+
+// sweep forgets sources whose buckets have refilled since
+// their last connection closed. The caller must hold the lock.
+func (l *Limiter) sweep() {
+	for key, s := range l.sources {
+		if s.users == 0 && (s.bucket == nil || s.bucket.full()) {
+			delete(l.sources, key)
+		}
+	}
+	l.lastSweep = time.Now()
+}
+
+// Sources returns the number of sources being tracked.
+func (l *Limiter) Sources() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.sources)
+}
@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiterRates(t *testing.T) {
+	l := NewLimiter(Config{
+		Rate:        100,
+		Burst:       3,
+		SourceRate:  1,
+		SourceBurst: 2,
+	})
+
+	// Each source gets its own burst.
+	client := net.ParseIP("10.0.0.1")
+	for i := 0; i < 2; i++ {
+		_, err := l.Acquire(client)
+		check(t, err)
+	}
+	_, err := l.Acquire(client)
+	assertExceeded(t, err, SourceRateLimited)
+
+	// But all of them share the global burst.
+	_, err = l.Acquire(net.ParseIP("10.0.0.2"))
+	check(t, err)
+	_, err = l.Acquire(net.ParseIP("10.0.0.3"))
+	assertExceeded(t, err, RateLimited)
+}
+
+func TestLimiterMaxConns(t *testing.T) {
+	l := NewLimiter(Config{MaxConnsPerSource: 1})
+
+	client := net.ParseIP("2001:db8::1")
+	release, err := l.Acquire(client)
+	check(t, err)
+	_, err = l.Acquire(client)
+	assertExceeded(t, err, TooManyConns)
+
+	// Other sources aren't affected.
+	_, err = l.Acquire(net.ParseIP("2001:db8::2"))
+	check(t, err)
+
+	// Releasing (even twice) frees a single slot.
+	release()
+	release()
+	release, err = l.Acquire(client)
+	check(t, err)
+	_, err = l.Acquire(client)
+	assertExceeded(t, err, TooManyConns)
+	release()
+
+	// Clients without an IP aren't limited per source.
+	for i := 0; i < 2; i++ {
+		_, err = l.Acquire(nil)
+		check(t, err)
+	}
+}
+
+func TestLimiterPrefix(t *testing.T) {
+	l := NewLimiter(Config{
+		MaxConnsPerSource: 1,
+		IPv4Prefix:        24,
+		IPv6Prefix:        64,
+	})
+
+	_, err := l.Acquire(net.ParseIP("192.0.2.1"))
+	check(t, err)
+	_, err = l.Acquire(net.ParseIP("192.0.2.200"))
+	assertExceeded(t, err, TooManyConns)
+	_, err = l.Acquire(net.ParseIP("192.0.3.1"))
+	check(t, err)
+
+	_, err = l.Acquire(net.ParseIP("2001:db8:0:1::1"))
+	check(t, err)
+	_, err = l.Acquire(net.ParseIP("2001:db8:0:1::2"))
+	assertExceeded(t, err, TooManyConns)
+}
+
+func TestLimiterDelay(t *testing.T) {
+	l := NewLimiter(Config{
+		MaxConnsPerSource: 1,
+		Policy:            DELAY_POLICY,
+		MaxDelay:          200 * time.Millisecond,
+	})
+
+	client := net.ParseIP("10.0.0.1")
+	release, err := l.Acquire(client)
+	check(t, err)
+
+	// A connection waits for a slot to free up...
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		release()
+	}()
+	start := time.Now()
+	release, err = l.Acquire(client)
+	check(t, err)
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("expected connection to be delayed, waited %v", waited)
+	}
+
+	// ...but not for longer than the maximum delay.
+	_, err = l.Acquire(client)
+	assertExceeded(t, err, TooManyConns)
+	release()
+}
+
+func TestLimiterRefundsRejected(t *testing.T) {
+	l := NewLimiter(Config{
+		Rate:        20,
+		Burst:       1,
+		SourceRate:  0.1,
+		SourceBurst: 2,
+	})
+
+	client := net.ParseIP("10.0.0.1")
+	_, err := l.Acquire(client)
+	check(t, err)
+
+	// The global rate rejects the connection, so
+	// the source gets its token back.
+	_, err = l.Acquire(client)
+	assertExceeded(t, err, RateLimited)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = l.Acquire(client)
+	check(t, err)
+}
+
+func TestLimiterClose(t *testing.T) {
+	l := NewLimiter(Config{
+		Rate:              1,
+		Burst:             2,
+		MaxConnsPerSource: 1,
+		Policy:            DELAY_POLICY,
+		MaxDelay:          5 * time.Second,
+	})
+
+	_, err := l.Acquire(net.ParseIP("10.0.0.1"))
+	check(t, err)
+
+	// The first source waits for a slot, the second for the rate.
+	errc := make(chan error, 2)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		ip := net.ParseIP(ip)
+		go func() {
+			_, err := l.Acquire(ip)
+			errc <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+	l.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != ErrClosed {
+				t.Errorf("expected %v, got %v", ErrClosed, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected waiting connections to stop when the limiter closed")
+		}
+	}
+}
+
+func TestLimiterForgetsSources(t *testing.T) {
+	l := NewLimiter(Config{MaxConnsPerSource: 1})
+
+	release, err := l.Acquire(net.ParseIP("10.0.0.1"))
+	check(t, err)
+	if l.Sources() != 1 {
+		t.Errorf("expected 1 source to be tracked, was %d", l.Sources())
+	}
+	release()
+	if l.Sources() != 0 {
+		t.Errorf("expected source to be forgotten, %d tracked", l.Sources())
+	}
+}
+
+func assertExceeded(t *testing.T, err error, reason string) {
+	e, ok := err.(*ExceededError)
+	if !ok {
+		t.Fatalf("expected %s, got %v", reason, err)
+	}
+	if e.Reason != reason {
+		t.Errorf("expected %s, got %s", reason, e.Reason)
+	}
+}
+
+func check(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
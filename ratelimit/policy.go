@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Policy is how connections over a limit are treated.
+type Policy uint32
+
+const (
+	// CLOSE_POLICY rejects connections over a limit immediately.
+	CLOSE_POLICY Policy = 1
+	// DELAY_POLICY holds connections until they're within
+	// the limits, rejecting them if that takes too long.
+	DELAY_POLICY Policy = 2
+)
+
+func (p Policy) String() string {
+	strings := [...]string{"CLOSE", "DELAY"}
+	switch p {
+	case CLOSE_POLICY, DELAY_POLICY:
+		return strings[p-1]
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "CLOSE":
+		return CLOSE_POLICY, nil
+	case "DELAY":
+		return DELAY_POLICY, nil
+	default:
+		return 0, errors.New(fmt.Sprintf("invalid rate limit policy %s", s))
+	}
+}
@@ -9,3 +9,5 @@ import (
 
 var exitSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
 var statsSignals = []os.Signal{syscall.SIGUSR1, syscall.SIGINFO}
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+var reopenSignals = []os.Signal{syscall.SIGUSR2}
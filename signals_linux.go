@@ -7,3 +7,5 @@ import (
 
 var exitSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
 var statsSignals = []os.Signal{syscall.SIGUSR1}
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+var reopenSignals = []os.Signal{syscall.SIGUSR2}
@@ -6,3 +6,5 @@ import (
 
 var exitSignals = []os.Signal{os.Interrupt}
 var statsSignals = []os.Signal{}
+var reloadSignals = []os.Signal{}
+var reopenSignals = []os.Signal{}
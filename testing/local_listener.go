@@ -2,6 +2,7 @@ package testing
 
 import (
 	"net"
+	"path/filepath"
 	"testing"
 )
 
@@ -16,3 +17,11 @@ func NewLocalListener(t *testing.T) net.Listener {
 	}
 	return ln
 }
+
+func NewLocalUnixListener(t *testing.T, dir string) net.Listener {
+	ln, err := net.Listen("unix", filepath.Join(dir, "backend.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}